@@ -1,139 +1,143 @@
 package main
 
 import (
-	"html/template"
+	"context"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 
-	"github.com/go-chi/chi/v5"
-	"github.com/gorilla/sessions"
 	"github.com/romanzipp/feedback/internal/config"
 	"github.com/romanzipp/feedback/internal/database"
-	"github.com/romanzipp/feedback/internal/handlers"
 	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/server"
 	"github.com/romanzipp/feedback/internal/services"
+	"golang.org/x/crypto/acme/autocert"
 )
 
 func main() {
+	// The migrate command manages the schema without starting the server, so
+	// it only needs the database settings
+	if len(os.Args) > 1 {
+		if os.Args[1] != "migrate" {
+			log.Fatalf("Unknown command %q", os.Args[1])
+		}
+		db := openDatabase(config.LoadDatabase())
+		err := runMigrate(db, os.Args[2:])
+		db.Close()
+		if err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Ensure data directory exists
-	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
-	}
-
 	uploadsDir := filepath.Join(cfg.DataDir, "uploads")
 	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
 		log.Fatalf("Failed to create uploads directory: %v", err)
 	}
 
-	// Ensure database directory exists
-	dbDir := filepath.Dir(cfg.DBPath)
-	if err := os.MkdirAll(dbDir, 0755); err != nil {
-		log.Fatalf("Failed to create database directory: %v", err)
-	}
-
-	// Open database
-	db, err := database.Open(cfg.DBPath)
-	if err != nil {
-		log.Fatalf("Failed to open database: %v", err)
-	}
+	db := openDatabase(cfg)
 	defer db.Close()
 
-	// Run migrations
-	if err := database.RunMigrations(db); err != nil {
+	// Apply pending migrations
+	applied, err := database.MigrateUp(db)
+	if err != nil {
 		log.Fatalf("Failed to run migrations: %v", err)
 	}
+	for _, m := range applied {
+		log.Printf("Applied migration %d (%s)", m.Version, m.Name)
+	}
 
 	log.Println("Database initialized successfully")
 
-	// Initialize services
-	shareService := services.NewShareService(db)
-	fileService := services.NewFileService(db, cfg.DataDir)
-
-	// Initialize session store
-	store := sessions.NewCookieStore([]byte(cfg.SessionSecret))
-	store.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   86400 * 30, // 30 days
-		HttpOnly: true,
-		Secure:   false, // Set to true in production with HTTPS
-		SameSite: http.SameSiteLaxMode,
+	srv, err := server.New(cfg, db, "web")
+	if err != nil {
+		log.Fatalf("Failed to initialize server: %v", err)
 	}
+	defer srv.Close()
 
-	// Load templates
-	funcMap := template.FuncMap{
-		"hasPrefix": func(s, prefix string) bool {
-			return len(s) >= len(prefix) && s[:len(prefix)] == prefix
-		},
+	// Delete expired shares in the background
+	if cfg.SharePurge {
+		go services.NewSharePurger(srv.Shares, srv.Files, cfg.SharePurgeGrace).Run(context.Background())
 	}
 
-	// Admin templates
-	adminTmpl := template.Must(template.New("").Funcs(funcMap).ParseGlob("web/templates/layouts/*.html"))
-	adminTmpl = template.Must(adminTmpl.ParseGlob("web/templates/admin/*.html"))
-
-	// Public templates
-	publicTmpl := template.Must(template.New("").Funcs(funcMap).ParseGlob("web/templates/layouts/*.html"))
-	publicTmpl = template.Must(publicTmpl.ParseGlob("web/templates/public/*.html"))
+	// Empty the trash after the retention period in the background
+	go srv.Trash.Run(context.Background())
 
-	// Initialize handlers
-	adminHandler := handlers.NewAdminHandler(adminTmpl, shareService, fileService)
-	shareHandler := handlers.NewShareHandler(publicTmpl, shareService, fileService, store)
-	fileHandler := handlers.NewFileHandler(fileService)
-	commentHandler := handlers.NewCommentHandler(fileService)
-
-	// Setup router
-	r := chi.NewRouter()
+	// Start server
+	addr := cfg.Host + ":" + cfg.Port
+	httpServer := &http.Server{Addr: addr, Handler: srv.Router}
 
-	// Middleware
-	r.Use(middleware.Logger)
+	if !cfg.TLSEnabled() {
+		log.Printf("Server starting on %s", addr)
+		log.Printf("Admin login: http://%s/admin/login", addr)
 
-	// Static files
-	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
+		if err := httpServer.ListenAndServe(); err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+		return
+	}
 
-	// Health check
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("OK"))
-	})
+	// Plain HTTP only redirects to HTTPS and answers ACME challenges
+	redirect := middleware.RedirectHTTPS(cfg.Port)
+
+	if len(cfg.AutocertDomains) > 0 {
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(filepath.Join(cfg.DataDir, "autocert")),
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Email:      cfg.AutocertEmail,
+		}
+		httpServer.TLSConfig = manager.TLSConfig()
+		redirect = manager.HTTPHandler(redirect)
+	}
 
-	// Public routes
-	r.Group(func(r chi.Router) {
-		r.Use(middleware.UserSession(store))
+	if cfg.HTTPPort != "" {
+		httpAddr := cfg.Host + ":" + cfg.HTTPPort
+		go func() {
+			log.Printf("Redirecting HTTP on %s to HTTPS", httpAddr)
+			if err := http.ListenAndServe(httpAddr, redirect); err != nil {
+				log.Fatalf("HTTP redirect failed to start: %v", err)
+			}
+		}()
+	}
 
-		r.Get("/share/{hash}", shareHandler.View)
-		r.Post("/share/{hash}/name", shareHandler.SetUsername)
-		r.Post("/api/files/{hash}/comments", commentHandler.Create)
-	})
+	log.Printf("Server starting on %s with TLS", addr)
+	log.Printf("Admin login: %s/admin/login", cfg.BaseURL)
 
-	// File download (no auth needed if you have the hash)
-	r.Get("/files/{hash}", fileHandler.Download)
+	if err := httpServer.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile); err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
+}
 
-	// Admin routes
-	r.Route("/admin/{token}", func(r chi.Router) {
-		r.Use(middleware.AdminAuth(cfg.AdminToken))
+// openDatabase opens PostgreSQL when DATABASE_URL is set and the SQLite file
+// otherwise, creating the data directory first.
+func openDatabase(cfg *config.Config) *database.DB {
+	// Ensure data directory exists
+	if err := os.MkdirAll(cfg.DataDir, 0755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
 
-		r.Get("/", adminHandler.Dashboard)
-		r.Get("/shares/new", adminHandler.NewShareForm)
-		r.Post("/shares", adminHandler.CreateShare)
-		r.Get("/shares/{id}", adminHandler.ShareDetail)
-		r.Post("/shares/{id}/upload", adminHandler.UploadFile)
-		r.Post("/shares/{id}/delete", adminHandler.DeleteShare)
-		r.Post("/files/{id}/delete", adminHandler.DeleteFile)
-	})
+	dsn := cfg.DatabaseURL
+	if dsn == "" {
+		dsn = cfg.DBPath
 
-	// Start server
-	addr := cfg.Host + ":" + cfg.Port
-	log.Printf("Server starting on %s", addr)
-	log.Printf("Admin URL: http://%s/admin/%s", addr, cfg.AdminToken)
+		// Ensure database directory exists
+		dbDir := filepath.Dir(cfg.DBPath)
+		if err := os.MkdirAll(dbDir, 0755); err != nil {
+			log.Fatalf("Failed to create database directory: %v", err)
+		}
+	}
 
-	if err := http.ListenAndServe(addr, r); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	db, err := database.Open(dsn)
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
 	}
+	return db
 }
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const migrateUsage = "usage: feedback migrate status|up|down [steps]|down --to <version> [--force]"
+
+// runMigrate implements "feedback migrate status|up|down [steps]" and
+// "feedback migrate down --to <version> [--force]".
+func runMigrate(db *database.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	switch args[0] {
+	case "status":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		return printMigrationStatus(db)
+
+	case "up":
+		if len(args) > 1 {
+			return errors.New(migrateUsage)
+		}
+		applied, err := database.MigrateUp(db)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		return err
+
+	case "down":
+		steps, force, err := downSteps(db, args[1:])
+		if err != nil {
+			return err
+		}
+		reverted, err := database.MigrateDown(db, steps, force)
+		for _, m := range reverted {
+			fmt.Printf("Reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if errors.Is(err, database.ErrInitialMigration) {
+			return fmt.Errorf("%w, use \"migrate down --to 0 --force\" to drop the schema", err)
+		}
+		if err == nil && len(reverted) == 0 {
+			fmt.Println("No applied migrations")
+		}
+		return err
+
+	default:
+		return errors.New(migrateUsage)
+	}
+}
+
+// downSteps reads the arguments of "migrate down", either the number of
+// migrations to revert (default 1) or "--to <version>" to revert every
+// migration after the version. Reverting the initial migration with
+// "--to 0" also needs "--force".
+func downSteps(db *database.DB, args []string) (int, bool, error) {
+	switch {
+	case len(args) == 0:
+		return 1, false, nil
+
+	case len(args) == 1:
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 {
+			return 0, false, fmt.Errorf("invalid number of steps %q", args[0])
+		}
+		return n, false, nil
+
+	case args[0] == "--to" && (len(args) == 2 || (len(args) == 3 && args[2] == "--force")):
+		version, err := strconv.Atoi(args[1])
+		if err != nil || version < 0 {
+			return 0, false, fmt.Errorf("invalid version %q", args[1])
+		}
+		statuses, err := database.MigrationStatuses(db)
+		if err != nil {
+			return 0, false, err
+		}
+		steps := 0
+		for _, s := range statuses {
+			if s.AppliedAt != nil && s.Version > version {
+				steps++
+			}
+		}
+		return steps, len(args) == 3, nil
+
+	default:
+		return 0, false, errors.New(migrateUsage)
+	}
+}
+
+func printMigrationStatus(db *database.DB) error {
+	statuses, err := database.MigrationStatuses(db)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+	for _, s := range statuses {
+		status := "pending"
+		if s.AppliedAt != nil {
+			status = "applied " + s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			if s.Modified {
+				status += " (modified since)"
+			}
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, status)
+	}
+	return w.Flush()
+}
@@ -2,35 +2,187 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// RateLimit allows a client the number of requests per period. Zero requests
+// disables the limit.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
 type Config struct {
-	Port            string
-	Host            string
-	AdminToken      string
-	SessionSecret   string
-	DataDir         string
-	MaxUploadSize   int64
-	DBPath          string
+	Port          string
+	Host          string
+	AdminToken    string
+	SessionSecret string
+	DataDir       string
+	MaxUploadSize int64
+	DBPath        string
+	DatabaseURL   string
+	BaseURL       string
+	SMTPHost      string
+	SMTPPort      string
+	SMTPUsername  string
+	SMTPPassword  string
+	SMTPFrom      string
+
+	// HTTPS is served from the certificate files or with certificates from
+	// Let's Encrypt for the autocert domains, and plain HTTP on HTTPPort
+	// redirects to it
+	TLSCertFile     string
+	TLSKeyFile      string
+	AutocertDomains []string
+	AutocertEmail   string
+	HTTPPort        string
+	HSTSMaxAge      time.Duration
+
+	// Cookies are only sent over HTTPS, either served by the app or by a
+	// proxy in front of it
+	SecureCookies bool
+
+	// Proxies whose X-Forwarded-For header is trusted for client addresses
+	TrustedProxies []netip.Prefix
+
+	// Per-client request limits of the comment, upload, subscribe, admin
+	// login and share unlock routes. Unlocking is limited per client and share.
+	RateLimitComments  RateLimit
+	RateLimitUploads   RateLimit
+	RateLimitSubscribe RateLimit
+	RateLimitLogin     RateLimit
+	RateLimitUnlock    RateLimit
+
+	// Metadata is removed from uploaded images, optionally keeping the
+	// untouched upload on disk
+	StripMetadata bool
+	KeepOriginals bool
+
+	// Uploads are scanned by clamd if an address is set
+	ClamdAddress string
+	ClamdTimeout time.Duration
+
+	// Files are only served to visitors of the share, admins and signed URLs
+	ProtectFiles bool
+	SignedURLTTL time.Duration
+
+	// Expired shares are deleted after the grace period if purging is enabled
+	SharePurge      bool
+	SharePurgeGrace time.Duration
+
+	// Deleted shares, files and comments stay in the trash this long
+	TrashRetention time.Duration
+
+	// Share, file and comment queries are cancelled after this long, zero
+	// disables the timeout
+	QueryTimeout time.Duration
+
+	// OpenID Connect login for the admin panel, disabled without an issuer
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCRedirectURL  string
+	OIDCScopes       []string
+	OIDCGroupsClaim  string
+	OIDCRoleMapping  map[string]string
 }
 
-func Load() (*Config, error) {
+// LoadDatabase reads only the data directory and database settings, for
+// commands that manage the database without starting the server.
+func LoadDatabase() *Config {
 	// Load .env file if it exists (ignore error if not found)
 	_ = godotenv.Load()
 
+	return &Config{
+		DataDir:     getEnv("DATA_DIR", "./data"),
+		DBPath:      getEnv("DB_PATH", "./data/feedback.db"),
+		DatabaseURL: getEnv("DATABASE_URL", ""),
+	}
+}
+
+func Load() (*Config, error) {
+	db := LoadDatabase()
+
 	cfg := &Config{
 		Port:          getEnv("PORT", "8080"),
 		Host:          getEnv("HOST", "0.0.0.0"),
 		AdminToken:    getEnv("ADMIN_TOKEN", ""),
 		SessionSecret: getEnv("SESSION_SECRET", ""),
-		DataDir:       getEnv("DATA_DIR", "./data"),
-		DBPath:        getEnv("DB_PATH", "./data/feedback.db"),
+		DataDir:       db.DataDir,
+		DBPath:        db.DBPath,
+		DatabaseURL:   db.DatabaseURL,
+		SMTPHost:      getEnv("SMTP_HOST", ""),
+		SMTPPort:      getEnv("SMTP_PORT", "587"),
+		SMTPUsername:  getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:  getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:      getEnv("SMTP_FROM", "feedback@localhost"),
+
+		TLSCertFile:   getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:    getEnv("TLS_KEY_FILE", ""),
+		AutocertEmail: getEnv("AUTOCERT_EMAIL", ""),
+		ClamdAddress:  getEnv("CLAMD_ADDRESS", ""),
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCGroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
 	}
 
+	for _, domain := range strings.Split(getEnv("AUTOCERT_DOMAINS", ""), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			cfg.AutocertDomains = append(cfg.AutocertDomains, domain)
+		}
+	}
+
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		return nil, fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if cfg.TLSCertFile != "" && len(cfg.AutocertDomains) > 0 {
+		return nil, fmt.Errorf("TLS_CERT_FILE and AUTOCERT_DOMAINS cannot be used together")
+	}
+
+	// Public URL used in links sent by email
+	defaultBaseURL := "http://localhost:" + cfg.Port
+	if len(cfg.AutocertDomains) > 0 {
+		defaultBaseURL = "https://" + cfg.AutocertDomains[0]
+	} else if cfg.TLSEnabled() {
+		defaultBaseURL = "https://localhost:" + cfg.Port
+	}
+	cfg.BaseURL = strings.TrimRight(getEnv("BASE_URL", defaultBaseURL), "/")
+
+	// Plain HTTP redirect listener, needed on port 80 for ACME challenges
+	cfg.HTTPPort = getEnv("HTTP_PORT", "80")
+	if cfg.HTTPPort == "off" || !cfg.TLSEnabled() {
+		cfg.HTTPPort = ""
+	}
+
+	cfg.SecureCookies = cfg.TLSEnabled() || strings.HasPrefix(cfg.BaseURL, "https://")
+
+	// Parse HSTS max age, e.g. "8760h" for a year, "0" disables the header
+	hstsMaxAge, err := time.ParseDuration(getEnv("HSTS_MAX_AGE", "8760h"))
+	if err != nil || hstsMaxAge < 0 {
+		return nil, fmt.Errorf("invalid HSTS_MAX_AGE: %q", getEnv("HSTS_MAX_AGE", "8760h"))
+	}
+	if cfg.SecureCookies {
+		cfg.HSTSMaxAge = hstsMaxAge
+	}
+
+	cfg.OIDCRedirectURL = getEnv("OIDC_REDIRECT_URL", cfg.BaseURL+"/admin/oidc/callback")
+	cfg.OIDCScopes = strings.Fields(getEnv("OIDC_SCOPES", "openid profile email"))
+
+	// Parse group to role mapping, e.g. "it-admins=owner,project-managers=editor"
+	roleMapping, err := parseMapping(getEnv("OIDC_ROLE_MAPPING", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC_ROLE_MAPPING: %w", err)
+	}
+	cfg.OIDCRoleMapping = roleMapping
+
 	// Parse max upload size
 	maxUploadStr := getEnv("MAX_UPLOAD_SIZE", "52428800")
 	maxUpload, err := strconv.ParseInt(maxUploadStr, 10, 64)
@@ -39,6 +191,88 @@ func Load() (*Config, error) {
 	}
 	cfg.MaxUploadSize = maxUpload
 
+	trustedProxies, err := parsePrefixes(getEnv("TRUSTED_PROXIES", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	cfg.TrustedProxies = trustedProxies
+
+	// Parse rate limits, e.g. "10/1m" for 10 requests per minute
+	for _, limit := range []struct {
+		key          string
+		defaultValue string
+		target       *RateLimit
+	}{
+		{"RATE_LIMIT_COMMENTS", "10/1m", &cfg.RateLimitComments},
+		{"RATE_LIMIT_UPLOADS", "60/1h", &cfg.RateLimitUploads},
+		{"RATE_LIMIT_SUBSCRIBE", "5/1h", &cfg.RateLimitSubscribe},
+		{"RATE_LIMIT_LOGIN", "10/15m", &cfg.RateLimitLogin},
+		{"RATE_LIMIT_UNLOCK", "10/15m", &cfg.RateLimitUnlock},
+	} {
+		value := getEnv(limit.key, limit.defaultValue)
+		parsed, err := parseRateLimit(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %q", limit.key, value)
+		}
+		*limit.target = parsed
+	}
+
+	protectFiles, err := strconv.ParseBool(getEnv("PROTECT_FILES", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROTECT_FILES: %w", err)
+	}
+	cfg.ProtectFiles = protectFiles
+
+	// Parse lifetime of signed file URLs, e.g. "15m"
+	signedURLTTL, err := time.ParseDuration(getEnv("SIGNED_URL_TTL", "15m"))
+	if err != nil || signedURLTTL <= 0 {
+		return nil, fmt.Errorf("invalid SIGNED_URL_TTL: %q", getEnv("SIGNED_URL_TTL", "15m"))
+	}
+	cfg.SignedURLTTL = signedURLTTL
+
+	stripMetadata, err := strconv.ParseBool(getEnv("STRIP_METADATA", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid STRIP_METADATA: %w", err)
+	}
+	cfg.StripMetadata = stripMetadata
+
+	keepOriginals, err := strconv.ParseBool(getEnv("KEEP_ORIGINALS", "false"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KEEP_ORIGINALS: %w", err)
+	}
+	cfg.KeepOriginals = keepOriginals
+
+	// Parse clamd scan timeout, e.g. "2m"
+	clamdTimeout, err := time.ParseDuration(getEnv("CLAMD_TIMEOUT", "2m"))
+	if err != nil || clamdTimeout <= 0 {
+		return nil, fmt.Errorf("invalid CLAMD_TIMEOUT: %q", getEnv("CLAMD_TIMEOUT", "2m"))
+	}
+	cfg.ClamdTimeout = clamdTimeout
+
+	// Parse purge grace period, e.g. "720h" to keep expired shares for 30 days
+	if v := getEnv("SHARE_PURGE_GRACE", ""); v != "" {
+		grace, err := time.ParseDuration(v)
+		if err != nil || grace < 0 {
+			return nil, fmt.Errorf("invalid SHARE_PURGE_GRACE: %q", v)
+		}
+		cfg.SharePurge = true
+		cfg.SharePurgeGrace = grace
+	}
+
+	// Parse trash retention, e.g. "168h" to purge deleted items after a week
+	trashRetention, err := time.ParseDuration(getEnv("TRASH_RETENTION", "720h"))
+	if err != nil || trashRetention < 0 {
+		return nil, fmt.Errorf("invalid TRASH_RETENTION: %q", getEnv("TRASH_RETENTION", "720h"))
+	}
+	cfg.TrashRetention = trashRetention
+
+	// Parse query timeout, e.g. "10s"
+	queryTimeout, err := time.ParseDuration(getEnv("DB_QUERY_TIMEOUT", "10s"))
+	if err != nil || queryTimeout < 0 {
+		return nil, fmt.Errorf("invalid DB_QUERY_TIMEOUT: %q", getEnv("DB_QUERY_TIMEOUT", "10s"))
+	}
+	cfg.QueryTimeout = queryTimeout
+
 	// Validate required fields
 	if cfg.AdminToken == "" {
 		return nil, fmt.Errorf("ADMIN_TOKEN is required")
@@ -46,13 +280,92 @@ func Load() (*Config, error) {
 	if cfg.SessionSecret == "" {
 		return nil, fmt.Errorf("SESSION_SECRET is required")
 	}
+	if cfg.DatabaseURL != "" && !strings.HasPrefix(cfg.DatabaseURL, "postgres://") && !strings.HasPrefix(cfg.DatabaseURL, "postgresql://") {
+		return nil, fmt.Errorf("DATABASE_URL must be a postgres:// URL")
+	}
+	if cfg.OIDCIssuer != "" && cfg.OIDCClientID == "" {
+		return nil, fmt.Errorf("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+	}
 
 	return cfg, nil
 }
 
+// TLSEnabled reports whether the app serves HTTPS itself.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || len(c.AutocertDomains) > 0
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
+
+// parseMapping parses a comma separated list of key=value pairs.
+func parseMapping(value string) (map[string]string, error) {
+	mapping := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(key) == "" || strings.TrimSpace(val) == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+		mapping[strings.TrimSpace(key)] = strings.TrimSpace(val)
+	}
+	return mapping, nil
+}
+
+// parsePrefixes parses a comma separated list of IP addresses and CIDR ranges.
+func parsePrefixes(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// parseRateLimit parses "requests/period", e.g. "10/1m". "off" disables the limit.
+func parseRateLimit(value string) (RateLimit, error) {
+	if value == "off" {
+		return RateLimit{}, nil
+	}
+
+	requests, period, ok := strings.Cut(value, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("expected requests/period")
+	}
+
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 0 {
+		return RateLimit{}, fmt.Errorf("invalid number of requests")
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid period")
+	}
+
+	return RateLimit{Requests: n, Period: d}, nil
+}
@@ -1,71 +1,232 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
+	"strings"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 )
 
-func Open(dbPath string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+// Dialect is the SQL flavour of the database backend.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DB is a database connection of either backend. Queries are written for
+// SQLite with ? placeholders and translated for PostgreSQL.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Tx is a transaction on a DB translating queries like it.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+// IsPostgresURL reports whether dsn is a PostgreSQL connection URL.
+func IsPostgresURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// Open connects to PostgreSQL if dsn is a postgres:// URL, otherwise it opens
+// the SQLite database file at that path.
+func Open(dsn string) (*DB, error) {
+	if IsPostgresURL(dsn) {
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+		if err := db.Ping(); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		return &DB{DB: db, Dialect: Postgres}, nil
+	}
+
+	// Foreign keys are set in the DSN so every pooled connection enforces
+	// them. The DSN may already carry options of its own.
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+	db, err := sql.Open("sqlite3", dsn+separator+"_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Enable WAL mode for better concurrency
 	if _, err := db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to enable WAL mode: %w", err)
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys=ON"); err != nil {
-		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
+	if err := checkSQLiteVersion(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{DB: db, Dialect: SQLite}, nil
+}
+
+// minSQLiteVersion is the oldest SQLite release with the ALTER TABLE DROP
+// COLUMN (3.35) of down migrations, the window functions (3.25) and row
+// values (3.15) used by the queries. The library bundled with go-sqlite3 is
+// newer, older ones are only linked with -tags libsqlite3.
+var minSQLiteVersion = [3]int{3, 35, 0}
+
+func checkSQLiteVersion(db *sql.DB) error {
+	var version string
+	if err := db.QueryRow("SELECT sqlite_version()").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read SQLite version: %w", err)
 	}
 
-	return db, nil
-}
-
-func RunMigrations(db *sql.DB) error {
-	migrations := []string{
-		`CREATE TABLE IF NOT EXISTS shares (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			hash TEXT NOT NULL UNIQUE,
-			name TEXT NOT NULL,
-			description TEXT,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_shares_hash ON shares(hash)`,
-		`CREATE TABLE IF NOT EXISTS files (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			share_id INTEGER NOT NULL,
-			hash TEXT NOT NULL UNIQUE,
-			filename TEXT NOT NULL,
-			storage_path TEXT NOT NULL,
-			mime_type TEXT NOT NULL,
-			size_bytes INTEGER NOT NULL,
-			uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (share_id) REFERENCES shares(id) ON DELETE CASCADE
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_files_share_id ON files(share_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_files_hash ON files(hash)`,
-		`CREATE TABLE IF NOT EXISTS comments (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			file_id INTEGER NOT NULL,
-			username TEXT NOT NULL,
-			content TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
-		)`,
-		`CREATE INDEX IF NOT EXISTS idx_comments_file_id ON comments(file_id)`,
+	if !versionAtLeast(version, minSQLiteVersion) {
+		return fmt.Errorf("SQLite %s is too old, at least %d.%d.%d is required", version, minSQLiteVersion[0], minSQLiteVersion[1], minSQLiteVersion[2])
 	}
+	return nil
+}
 
-	for _, migration := range migrations {
-		if _, err := db.Exec(migration); err != nil {
-			return fmt.Errorf("migration failed: %w", err)
+// versionAtLeast reports whether a dotted version like "3.45.1" is min or
+// newer.
+func versionAtLeast(version string, min [3]int) bool {
+	var parsed [3]int
+	for i, part := range strings.SplitN(version, ".", 3) {
+		parsed[i], _ = strconv.Atoi(part)
+	}
+	for i := range parsed {
+		if parsed[i] != min[i] {
+			return parsed[i] > min[i]
 		}
 	}
+	return true
+}
 
-	return nil
+// Rebind translates the ? placeholders of a query to the dialect.
+func (db *DB) Rebind(query string) string {
+	return rebind(db.Dialect, query)
+}
+
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.Rebind(query), args...)
+}
+
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.PrepareContext(context.Background(), query)
+}
+
+func (db *DB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return db.DB.PrepareContext(ctx, db.Rebind(query))
+}
+
+// Insert runs an INSERT statement and returns the id of the new row, using
+// RETURNING on PostgreSQL which has no last insert id.
+func (db *DB) Insert(query string, args ...interface{}) (int, error) {
+	return db.InsertContext(context.Background(), query, args...)
+}
+
+func (db *DB) InsertContext(ctx context.Context, query string, args ...interface{}) (int, error) {
+	if db.Dialect == Postgres {
+		var id int
+		err := db.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.Dialect}, nil
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.ExecContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, rebind(tx.dialect, query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.QueryContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, rebind(tx.dialect, query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.QueryRowContext(context.Background(), query, args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, rebind(tx.dialect, query), args...)
+}
+
+// rebind replaces ? placeholders outside of string literals with $1, $2, ...
+// for PostgreSQL.
+func rebind(dialect Dialect, query string) string {
+	if dialect != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	quoted := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			quoted = !quoted
+		case c == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
 }
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenSQLiteEnforcesForeignKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "feedback.db")
+
+	for _, dsn := range []string{path, path + "?_busy_timeout=5000", "file:" + path + "?mode=rwc&_busy_timeout=5000"} {
+		db, err := Open(dsn)
+		if err != nil {
+			t.Fatalf("open %q: %v", dsn, err)
+		}
+
+		var enabled int
+		if err := db.QueryRow("PRAGMA foreign_keys").Scan(&enabled); err != nil {
+			t.Errorf("%q: read pragma: %v", dsn, err)
+		}
+		if enabled != 1 {
+			t.Errorf("%q: foreign keys not enforced", dsn)
+		}
+		db.Close()
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	for version, want := range map[string]bool{
+		"3.35.0": true,
+		"3.45.1": true,
+		"4.0.0":  true,
+		"3.35":   true,
+		"3.34.1": false,
+		"3.25.0": false,
+		"3.9.2":  false,
+		"2.99.0": false,
+	} {
+		if got := versionAtLeast(version, minSQLiteVersion); got != want {
+			t.Errorf("versionAtLeast(%q) = %v, want %v", version, got, want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package database
+
+import "fmt"
+
+// legacyColumns are the columns of the initial migration that the last
+// release before versioned migrations did not create. Its schema only had the
+// shares, files and comments tables, all others are new to such databases and
+// created by the initial migration.
+var legacyColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"shares", "owner_id", "INTEGER REFERENCES admins(id) ON DELETE SET NULL"},
+	{"shares", "password_hash", "TEXT"},
+	{"shares", "expires_at", "DATETIME"},
+	{"shares", "invite_only", "BOOLEAN NOT NULL DEFAULT 0"},
+	{"shares", "deleted_at", "DATETIME"},
+	{"files", "approved_at", "DATETIME"},
+	{"files", "approved_by", "TEXT"},
+	{"files", "scan_status", "TEXT NOT NULL DEFAULT 'unscanned'"},
+	{"files", "scan_signature", "TEXT"},
+	{"files", "scanned_at", "DATETIME"},
+	{"files", "original_path", "TEXT"},
+	{"files", "deleted_at", "DATETIME"},
+	{"comments", "reviewer_id", "INTEGER REFERENCES reviewers(id) ON DELETE SET NULL"},
+	{"comments", "deleted_at", "DATETIME"},
+}
+
+// upgradeLegacySchema adds the columns an older release may not have created
+// yet. Tables that do not exist are left to the initial migration. Only
+// SQLite databases predate versioned migrations.
+func upgradeLegacySchema(db *DB) error {
+	for _, c := range legacyColumns {
+		columns, err := tableColumns(db, c.table)
+		if err != nil {
+			return err
+		}
+		if len(columns) == 0 || columns[c.column] {
+			continue
+		}
+
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableColumns returns the column names of a table, or none if it does not
+// exist.
+func tableColumns(db *DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		columns[name] = true
+	}
+	return columns, rows.Err()
+}
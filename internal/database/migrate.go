@@ -0,0 +1,330 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// Migration is a numbered schema change read from
+// migrations/{dialect}/{version}_{name}.up.sql and the matching .down.sql
+// file. Every version exists for each dialect.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus is a migration and whether it was applied to the database.
+// Modified is set if the migration file changed after it was applied.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+	Modified  bool
+}
+
+// Migrations returns the embedded migrations of a dialect ordered by version.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := "migrations/" + string(dialect)
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		base, direction, ok := strings.Cut(strings.TrimSuffix(name, ".sql"), ".")
+		prefix, label, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || !found || err != nil || version <= 0 || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("invalid migration file name %q", name)
+		}
+
+		content, err := migrationFiles.ReadFile(dir + "/" + name)
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		}
+		if m.Name != label {
+			return nil, fmt.Errorf("migration %d has different names %q and %q", version, m.Name, label)
+		}
+		if direction == "up" {
+			m.Up = string(content)
+			sum := sha256.Sum256(content)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) needs an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func ensureMigrationsTable(db *DB) error {
+	timestamp := "DATETIME"
+	if db.Dialect == Postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at ` + timestamp + ` NOT NULL
+	)`)
+	return err
+}
+
+func appliedMigrations(db *DB) (map[int]appliedMigration, error) {
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, name, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// MigrationStatuses lists the embedded migrations and whether they were
+// applied. Migrations applied by a newer release are not listed.
+func MigrationStatuses(db *DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if a, ok := applied[m.Version]; ok {
+			appliedAt := a.appliedAt
+			status.AppliedAt = &appliedAt
+			status.Modified = a.checksum != m.Checksum
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
+}
+
+// checkApplied fails if an applied migration was edited or is unknown to
+// this release, which means the database is ahead of the binary.
+func checkApplied(migrations []Migration, applied map[int]appliedMigration) error {
+	known := make(map[int]bool, len(migrations))
+	for _, m := range migrations {
+		known[m.Version] = true
+		if a, ok := applied[m.Version]; ok && a.checksum != m.Checksum {
+			return fmt.Errorf("migration %d (%s) was modified after it was applied", m.Version, m.Name)
+		}
+	}
+	for version, a := range applied {
+		if !known[version] {
+			return fmt.Errorf("database has migration %d (%s) applied, which this release does not know", version, a.name)
+		}
+	}
+	return nil
+}
+
+// migrationLockKey identifies the advisory lock taken while migrating a
+// PostgreSQL database. The value is arbitrary but must never change.
+const migrationLockKey = 4362039171
+
+// lockMigrations keeps several instances starting at the same time from
+// applying the same migrations to a PostgreSQL database. The session level
+// lock is held on a dedicated connection until unlock is called. SQLite
+// serializes writers itself and needs no lock.
+func lockMigrations(db *DB) (unlock func(), err error) {
+	if db.Dialect != Postgres {
+		return func() {}, nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to lock migrations: %w", err)
+	}
+
+	return func() {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		conn.Close()
+	}, nil
+}
+
+// MigrateUp applies all pending migrations in order, each in its own
+// transaction, and returns the applied ones.
+func MigrateUp(db *DB) ([]Migration, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkApplied(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	// Databases created before versioned migrations get the columns they
+	// miss, so the initial migration can adopt them
+	if len(applied) == 0 && db.Dialect == SQLite {
+		if err := upgradeLegacySchema(db); err != nil {
+			return nil, fmt.Errorf("failed to upgrade legacy schema: %w", err)
+		}
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err := inTransaction(db, func(tx *Tx) error {
+			if _, err := tx.Exec(m.Up); err != nil {
+				return err
+			}
+			_, err := tx.Exec(
+				"INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)",
+				m.Version, m.Name, m.Checksum, time.Now().UTC(),
+			)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+// ErrInitialMigration is returned when reverting the initial migration
+// without force. Its down migration drops every table with all data.
+var ErrInitialMigration = errors.New("the initial migration drops all data and is only reverted with force")
+
+// MigrateDown reverts the given number of most recently applied migrations
+// and returns the reverted ones. The initial migration is only reverted with
+// force; without it nothing is reverted if steps would include it.
+func MigrateDown(db *DB, steps int, force bool) ([]Migration, error) {
+	migrations, err := Migrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkApplied(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	var revert []Migration
+	for i := len(migrations) - 1; i >= 0 && len(revert) < steps; i-- {
+		if _, ok := applied[migrations[i].Version]; ok {
+			revert = append(revert, migrations[i])
+		}
+	}
+	if len(revert) > 0 && revert[len(revert)-1].Version == migrations[0].Version && !force {
+		return nil, ErrInitialMigration
+	}
+
+	var done []Migration
+	for _, m := range revert {
+		err := inTransaction(db, func(tx *Tx) error {
+			if _, err := tx.Exec(m.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("reverting migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+
+	return done, nil
+}
+
+func inTransaction(db *DB, fn func(tx *Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
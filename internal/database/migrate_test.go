@@ -0,0 +1,260 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	db, err := Open(filepath.Join(t.TempDir(), "feedback.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateDownKeepsInitialMigration(t *testing.T) {
+	db := openTestDB(t)
+	migrations, err := MigrateUp(db)
+	if err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	// Nothing is reverted if the steps include the initial migration
+	if _, err := MigrateDown(db, len(migrations), false); !errors.Is(err, ErrInitialMigration) {
+		t.Fatalf("revert all: error %v, want ErrInitialMigration", err)
+	}
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		t.Fatalf("status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			t.Errorf("migration %d reverted", s.Version)
+		}
+	}
+
+	reverted, err := MigrateDown(db, len(migrations)-1, false)
+	if err != nil || len(reverted) != len(migrations)-1 {
+		t.Fatalf("revert to initial: reverted %d, error %v", len(reverted), err)
+	}
+	if _, err := MigrateDown(db, 1, false); !errors.Is(err, ErrInitialMigration) {
+		t.Fatalf("revert initial: error %v, want ErrInitialMigration", err)
+	}
+
+	reverted, err = MigrateDown(db, 1, true)
+	if err != nil || len(reverted) != 1 || reverted[0].Version != 1 {
+		t.Fatalf("force revert initial: reverted %v, error %v", reverted, err)
+	}
+	var tables int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'shares'").Scan(&tables)
+	if tables != 0 {
+		t.Error("shares table left after reverting the initial migration")
+	}
+}
+
+// schema is the tables with their columns and the indexes created by
+// migrations, as far as they can be told apart without a database.
+type schema struct {
+	tables  map[string][]string
+	indexes map[string]string
+}
+
+var (
+	sqlComment  = regexp.MustCompile(`--[^\n]*`)
+	createTable = regexp.MustCompile(`(?is)^CREATE TABLE (?:IF NOT EXISTS )?(\w+)\s*\((.*)\)$`)
+	dropTable   = regexp.MustCompile(`(?i)^DROP TABLE (?:IF EXISTS )?(\w+)$`)
+	addColumn   = regexp.MustCompile(`(?i)^ALTER TABLE (\w+) ADD COLUMN (\w+)`)
+	dropColumn  = regexp.MustCompile(`(?i)^ALTER TABLE (\w+) DROP COLUMN (\w+)$`)
+	createIndex = regexp.MustCompile(`(?i)^CREATE (?:UNIQUE )?INDEX (?:IF NOT EXISTS )?(\w+) ON (\w+)\s*\(([^)]*)\)$`)
+	dropIndex   = regexp.MustCompile(`(?i)^DROP INDEX (?:IF EXISTS )?(\w+)$`)
+	constraint  = regexp.MustCompile(`(?i)^(PRIMARY|UNIQUE|FOREIGN|CHECK|CONSTRAINT)\b`)
+)
+
+// apply changes the schema by the statements of a migration. Statements it
+// does not understand fail the test, so new kinds of changes are compared
+// as well once the parser learns them.
+func (s *schema) apply(t *testing.T, name, script string) {
+	t.Helper()
+
+	for _, statement := range strings.Split(sqlComment.ReplaceAllString(script, ""), ";") {
+		statement = strings.Join(strings.Fields(statement), " ")
+		if statement == "" {
+			continue
+		}
+
+		if m := createTable.FindStringSubmatch(statement); m != nil {
+			var columns []string
+			depth, start := 0, 0
+			body := m[2] + ","
+			for i, c := range body {
+				switch {
+				case c == '(':
+					depth++
+				case c == ')':
+					depth--
+				case c == ',' && depth == 0:
+					if item := strings.TrimSpace(body[start:i]); !constraint.MatchString(item) {
+						columns = append(columns, strings.Fields(item)[0])
+					}
+					start = i + 1
+				}
+			}
+			s.tables[m[1]] = columns
+		} else if m := dropTable.FindStringSubmatch(statement); m != nil {
+			delete(s.tables, m[1])
+			maps.DeleteFunc(s.indexes, func(_, index string) bool { return strings.HasPrefix(index, m[1]+"(") })
+		} else if m := addColumn.FindStringSubmatch(statement); m != nil {
+			s.tables[m[1]] = append(s.tables[m[1]], m[2])
+		} else if m := dropColumn.FindStringSubmatch(statement); m != nil {
+			s.tables[m[1]] = slices.DeleteFunc(slices.Clone(s.tables[m[1]]), func(c string) bool { return c == m[2] })
+		} else if m := createIndex.FindStringSubmatch(statement); m != nil {
+			s.indexes[m[1]] = m[2] + "(" + strings.ReplaceAll(m[3], " ", "") + ")"
+		} else if m := dropIndex.FindStringSubmatch(statement); m != nil {
+			delete(s.indexes, m[1])
+		} else {
+			t.Fatalf("%s: unknown statement %q", name, statement)
+		}
+	}
+}
+
+func newSchema() *schema {
+	return &schema{tables: map[string][]string{}, indexes: map[string]string{}}
+}
+
+func (s *schema) clone() *schema {
+	return &schema{tables: maps.Clone(s.tables), indexes: maps.Clone(s.indexes)}
+}
+
+func TestMigrationsInSync(t *testing.T) {
+	sqlite, err := Migrations(SQLite)
+	if err != nil {
+		t.Fatalf("sqlite migrations: %v", err)
+	}
+	postgres, err := Migrations(Postgres)
+	if err != nil {
+		t.Fatalf("postgres migrations: %v", err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("%d sqlite and %d postgres migrations", len(sqlite), len(postgres))
+	}
+
+	// Both dialects must end up with the same tables, columns and indexes
+	// after every version, and with the previous ones after reverting it
+	current := map[Dialect]*schema{SQLite: newSchema(), Postgres: newSchema()}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Fatalf("sqlite migration %d (%s) does not match postgres migration %d (%s)",
+				sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+
+		for dialect, m := range map[Dialect]Migration{SQLite: sqlite[i], Postgres: postgres[i]} {
+			name := fmt.Sprintf("%s migration %d (%s)", dialect, m.Version, m.Name)
+			previous := current[dialect].clone()
+			current[dialect].apply(t, name, m.Up)
+
+			reverted := current[dialect].clone()
+			reverted.apply(t, name, m.Down)
+			if !reflect.DeepEqual(reverted, previous) {
+				t.Errorf("reverting %s leaves %v, want %v", name, reverted, previous)
+			}
+		}
+
+		if !reflect.DeepEqual(current[SQLite], current[Postgres]) {
+			t.Fatalf("schemas differ after migration %d (%s):\nsqlite:   %v\npostgres: %v",
+				sqlite[i].Version, sqlite[i].Name, current[SQLite], current[Postgres])
+		}
+	}
+}
+
+// releasedSchema is the schema of the last release before versioned
+// migrations, which created its tables at startup.
+var releasedSchema = []string{
+	`CREATE TABLE IF NOT EXISTS shares (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		hash TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		description TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_shares_hash ON shares(hash)`,
+	`CREATE TABLE IF NOT EXISTS files (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		share_id INTEGER NOT NULL,
+		hash TEXT NOT NULL UNIQUE,
+		filename TEXT NOT NULL,
+		storage_path TEXT NOT NULL,
+		mime_type TEXT NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		uploaded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (share_id) REFERENCES shares(id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_files_share_id ON files(share_id)`,
+	`CREATE INDEX IF NOT EXISTS idx_files_hash ON files(hash)`,
+	`CREATE TABLE IF NOT EXISTS comments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		file_id INTEGER NOT NULL,
+		username TEXT NOT NULL,
+		content TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_comments_file_id ON comments(file_id)`,
+}
+
+func TestMigrateUpAdoptsReleasedSchema(t *testing.T) {
+	db := openTestDB(t)
+	for _, statement := range releasedSchema {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("create released schema: %v", err)
+		}
+	}
+	for _, statement := range []string{
+		"INSERT INTO shares (hash, name) VALUES ('share-hash', 'Share')",
+		"INSERT INTO files (share_id, hash, filename, storage_path, mime_type, size_bytes) VALUES (1, 'file-hash', 'a.txt', 'a.txt', 'text/plain', 1)",
+		"INSERT INTO comments (file_id, username, content) VALUES (1, 'alice', 'Looks good')",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("insert released data: %v", err)
+		}
+	}
+
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("migrate up: %v", err)
+	}
+
+	// The adopted tables end up like freshly migrated ones
+	fresh := openTestDB(t)
+	if _, err := MigrateUp(fresh); err != nil {
+		t.Fatalf("migrate fresh database: %v", err)
+	}
+	for _, table := range []string{"shares", "files", "comments"} {
+		adopted, err := tableColumns(db, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := tableColumns(fresh, table)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(adopted, want) {
+			t.Errorf("%s columns %v, want %v", table, slices.Sorted(maps.Keys(adopted)), slices.Sorted(maps.Keys(want)))
+		}
+	}
+
+	var scanStatus string
+	if err := db.QueryRow("SELECT scan_status FROM files WHERE hash = 'file-hash'").Scan(&scanStatus); err != nil || scanStatus != "unscanned" {
+		t.Errorf("released file: scan status %q, error %v", scanStatus, err)
+	}
+}
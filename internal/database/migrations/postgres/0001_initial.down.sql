@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS subscriptions;
+DROP TABLE IF EXISTS comments;
+DROP TABLE IF EXISTS reviewers;
+DROP TABLE IF EXISTS share_links;
+DROP TABLE IF EXISTS files;
+DROP TABLE IF EXISTS shares;
+DROP TABLE IF EXISTS admin_recovery_codes;
+DROP TABLE IF EXISTS admin_sessions;
+DROP TABLE IF EXISTS admins;
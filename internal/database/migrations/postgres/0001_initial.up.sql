@@ -0,0 +1,154 @@
+-- PostgreSQL version of the initial schema. Keep it in sync with the SQLite
+-- migration of the same version.
+
+CREATE TABLE IF NOT EXISTS admins (
+	id SERIAL PRIMARY KEY,
+	username TEXT NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	role TEXT NOT NULL,
+	oidc_subject TEXT,
+	totp_secret TEXT,
+	totp_enabled_at TIMESTAMPTZ,
+	totp_last_step BIGINT NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_admins_oidc_subject ON admins(oidc_subject);
+
+CREATE TABLE IF NOT EXISTS admin_sessions (
+	id SERIAL PRIMARY KEY,
+	admin_id INTEGER REFERENCES admins(id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	ip TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	last_seen_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS admin_recovery_codes (
+	id SERIAL PRIMARY KEY,
+	admin_id INTEGER NOT NULL,
+	code_hash TEXT NOT NULL,
+	used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (admin_id) REFERENCES admins(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_admin_recovery_codes_admin_id ON admin_recovery_codes(admin_id);
+
+CREATE TABLE IF NOT EXISTS shares (
+	id SERIAL PRIMARY KEY,
+	hash TEXT NOT NULL UNIQUE,
+	name TEXT NOT NULL,
+	description TEXT,
+	owner_id INTEGER REFERENCES admins(id) ON DELETE SET NULL,
+	password_hash TEXT,
+	expires_at TIMESTAMPTZ,
+	invite_only BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_shares_hash ON shares(hash);
+CREATE INDEX IF NOT EXISTS idx_shares_deleted_at ON shares(deleted_at);
+
+CREATE TABLE IF NOT EXISTS files (
+	id SERIAL PRIMARY KEY,
+	share_id INTEGER NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
+	filename TEXT NOT NULL,
+	storage_path TEXT NOT NULL,
+	mime_type TEXT NOT NULL,
+	size_bytes BIGINT NOT NULL,
+	uploaded_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	approved_at TIMESTAMPTZ,
+	approved_by TEXT,
+	scan_status TEXT NOT NULL DEFAULT 'unscanned',
+	scan_signature TEXT,
+	scanned_at TIMESTAMPTZ,
+	original_path TEXT,
+	deleted_at TIMESTAMPTZ,
+	FOREIGN KEY (share_id) REFERENCES shares(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_files_share_id ON files(share_id);
+CREATE INDEX IF NOT EXISTS idx_files_hash ON files(hash);
+CREATE INDEX IF NOT EXISTS idx_files_deleted_at ON files(deleted_at);
+
+CREATE TABLE IF NOT EXISTS share_links (
+	id SERIAL PRIMARY KEY,
+	share_id INTEGER NOT NULL,
+	hash TEXT NOT NULL UNIQUE,
+	label TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (share_id) REFERENCES shares(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_share_links_share_id ON share_links(share_id);
+
+CREATE TABLE IF NOT EXISTS reviewers (
+	id SERIAL PRIMARY KEY,
+	share_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	email TEXT NOT NULL,
+	permission TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	verified_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (share_id) REFERENCES shares(id) ON DELETE CASCADE,
+	UNIQUE (share_id, email)
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+	id SERIAL PRIMARY KEY,
+	file_id INTEGER NOT NULL,
+	reviewer_id INTEGER REFERENCES reviewers(id) ON DELETE SET NULL,
+	username TEXT NOT NULL,
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	deleted_at TIMESTAMPTZ,
+	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_comments_file_id ON comments(file_id);
+CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments(deleted_at);
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id SERIAL PRIMARY KEY,
+	share_id INTEGER NOT NULL,
+	file_id INTEGER,
+	email TEXT NOT NULL,
+	confirm_token TEXT NOT NULL UNIQUE,
+	unsubscribe_token TEXT NOT NULL UNIQUE,
+	access_hash TEXT,
+	confirmed_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (share_id) REFERENCES shares(id) ON DELETE CASCADE,
+	FOREIGN KEY (file_id) REFERENCES files(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_share_id ON subscriptions(share_id);
+
+CREATE TABLE IF NOT EXISTS api_keys (
+	id SERIAL PRIMARY KEY,
+	name TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	key_hash TEXT NOT NULL UNIQUE,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audit events keep no foreign keys so they outlive what they describe
+CREATE TABLE IF NOT EXISTS audit_events (
+	id SERIAL PRIMARY KEY,
+	actor_type TEXT NOT NULL,
+	actor_id INTEGER,
+	actor_name TEXT NOT NULL,
+	action TEXT NOT NULL,
+	target_type TEXT NOT NULL,
+	target_id INTEGER,
+	target TEXT NOT NULL,
+	ip TEXT NOT NULL,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events(created_at);
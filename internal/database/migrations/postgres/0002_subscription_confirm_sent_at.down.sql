@@ -0,0 +1 @@
+ALTER TABLE subscriptions DROP COLUMN confirm_sent_at;
@@ -0,0 +1,3 @@
+-- Time the last confirmation mail of a pending subscription was sent, so it
+-- is not resent within the cooldown
+ALTER TABLE subscriptions ADD COLUMN confirm_sent_at TIMESTAMPTZ;
@@ -0,0 +1,11 @@
+-- Admins who passed the password check and still have to enter their
+-- authentication code. The cookie only holds the random token, the attempts
+-- are counted here so replaying the cookie does not reset them.
+CREATE TABLE IF NOT EXISTS pending_logins (
+	id SERIAL PRIMARY KEY,
+	admin_id INTEGER NOT NULL REFERENCES admins(id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMPTZ NOT NULL
+);
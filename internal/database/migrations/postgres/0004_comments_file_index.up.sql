@@ -0,0 +1,4 @@
+-- Comments are listed per file in order, skipping the trash. The index
+-- replaces the one on file_id alone, which is a prefix of it.
+CREATE INDEX IF NOT EXISTS idx_comments_file_id_created_at ON comments(file_id, deleted_at, created_at, id);
+DROP INDEX IF EXISTS idx_comments_file_id;
@@ -0,0 +1,4 @@
+-- Reviewer and share password a subscription was made with, notifications
+-- stop once the reviewer is revoked or the password changes
+ALTER TABLE subscriptions ADD COLUMN reviewer_id INTEGER REFERENCES reviewers(id) ON DELETE CASCADE;
+ALTER TABLE subscriptions ADD COLUMN unlock_fingerprint TEXT;
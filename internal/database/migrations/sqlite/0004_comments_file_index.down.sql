@@ -0,0 +1,2 @@
+CREATE INDEX IF NOT EXISTS idx_comments_file_id ON comments(file_id);
+DROP INDEX IF EXISTS idx_comments_file_id_created_at;
@@ -0,0 +1,2 @@
+ALTER TABLE subscriptions DROP COLUMN unlock_fingerprint;
+ALTER TABLE subscriptions DROP COLUMN reviewer_id;
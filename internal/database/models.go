@@ -3,31 +3,273 @@ package database
 import "time"
 
 type Share struct {
-	ID          int
-	Hash        string
-	Name        string
-	Description string
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	ID           int
+	Hash         string
+	Name         string
+	Description  string
+	OwnerID      *int
+	PasswordHash *string
+	ExpiresAt    *time.Time
+	InviteOnly   bool
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	DeletedAt    *time.Time
+}
+
+// IsExpired reports whether the share is past its expiry date.
+func (s Share) IsExpired() bool {
+	return s.ExpiresAt != nil && time.Now().After(*s.ExpiresAt)
+}
+
+// HasPassword reports whether visitors must unlock the share with a password.
+func (s Share) HasPassword() bool {
+	return s.PasswordHash != nil
+}
+
+// OwnedBy reports whether the share is assigned to the given admin.
+func (s Share) OwnedBy(adminID int) bool {
+	return s.OwnerID != nil && *s.OwnerID == adminID
 }
 
 type File struct {
-	ID          int
-	ShareID     int
-	Hash        string
-	Filename    string
-	StoragePath string
-	MimeType    string
-	SizeBytes   int64
-	UploadedAt  time.Time
+	ID            int
+	ShareID       int
+	Hash          string
+	Filename      string
+	StoragePath   string
+	MimeType      string
+	SizeBytes     int64
+	UploadedAt    time.Time
+	ApprovedAt    *time.Time
+	ApprovedBy    *string
+	ScanStatus    string
+	ScanSignature *string
+	ScannedAt     *time.Time
+	OriginalPath  *string
+	DeletedAt     *time.Time
+}
+
+// Malware scan states of files. Files are unscanned when no scanner is
+// configured, pending until the scan succeeded and infected files are moved
+// to quarantine.
+const (
+	ScanStatusUnscanned = "unscanned"
+	ScanStatusPending   = "pending"
+	ScanStatusClean     = "clean"
+	ScanStatusInfected  = "infected"
+)
+
+// Blocked reports whether the file may not be downloaded because its scan is
+// pending or found malware.
+func (f File) Blocked() bool {
+	return f.ScanStatus == ScanStatusPending || f.ScanStatus == ScanStatusInfected
+}
+
+// Quarantined reports whether malware was found in the file.
+func (f File) Quarantined() bool {
+	return f.ScanStatus == ScanStatusInfected
 }
 
 type Comment struct {
-	ID        int
-	FileID    int
-	Username  string
-	Content   string
-	CreatedAt time.Time
+	ID         int
+	FileID     int
+	ReviewerID *int
+	Username   string
+	Content    string
+	CreatedAt  time.Time
+	DeletedAt  *time.Time
+}
+
+// Verified reports whether the comment was written by an invited reviewer.
+func (c Comment) Verified() bool {
+	return c.ReviewerID != nil
+}
+
+type Subscription struct {
+	ID                int
+	ShareID           int
+	FileID            *int
+	Email             string
+	ConfirmToken      string
+	UnsubscribeToken  string
+	AccessHash        *string
+	ReviewerID        *int
+	UnlockFingerprint *string
+	ConfirmedAt       *time.Time
+	ConfirmSentAt     *time.Time
+	CreatedAt         time.Time
+}
+
+// Permission levels of share links, each including the ones before it.
+const (
+	PermissionView    = "view"
+	PermissionComment = "comment"
+	PermissionApprove = "approve"
+)
+
+// Permissions lists the link permission levels from lowest to highest.
+var Permissions = []string{PermissionView, PermissionComment, PermissionApprove}
+
+// ShareLink is an additional link to a share with its own hash and permission.
+type ShareLink struct {
+	ID         int
+	ShareID    int
+	Hash       string
+	Label      string
+	Permission string
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// Reviewer is a person invited to a share by email. They sign in with a
+// personal magic link and comment under a verified identity.
+type Reviewer struct {
+	ID         int
+	ShareID    int
+	Name       string
+	Email      string
+	Permission string
+	VerifiedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// ShareAccess is a share as reached through a hash. Hash is the hash of the
+// link used, Link is nil for the share's own hash. Reviewer is set for
+// visitors signed in with their magic link, whose invitation grants the
+// permission instead of the link.
+type ShareAccess struct {
+	Share      *Share
+	Link       *ShareLink
+	Reviewer   *Reviewer
+	Hash       string
+	Permission string
+}
+
+// CanView reports whether the visitor may open the share. Invite-only shares
+// grant no permission without a reviewer.
+func (a ShareAccess) CanView() bool {
+	return a.Allows(PermissionView)
+}
+
+// Allows reports whether the access grants at least the given permission.
+func (a ShareAccess) Allows(permission string) bool {
+	return permissionLevel(a.Permission) >= permissionLevel(permission)
+}
+
+func (a ShareAccess) CanComment() bool {
+	return a.Allows(PermissionComment)
+}
+
+func (a ShareAccess) CanApprove() bool {
+	return a.Allows(PermissionApprove)
+}
+
+func permissionLevel(permission string) int {
+	for i, p := range Permissions {
+		if p == permission {
+			return i
+		}
+	}
+	return -1
+}
+
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (k APIKey) IsExpired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Admin is a signed-in admin panel user. The admin token signs in as an
+// owner without an account, represented by ID 0.
+type Admin struct {
+	ID            int
+	Username      string
+	Role          string
+	OIDCSubject   *string
+	TOTPEnabledAt *time.Time
+	CreatedAt     time.Time
+}
+
+func (a Admin) IsOwner() bool {
+	return a.Role == RoleOwner
+}
+
+// HasPassword reports whether the admin signs in with a password rather than single sign-on.
+func (a Admin) HasPassword() bool {
+	return a.ID != 0 && a.OIDCSubject == nil
+}
+
+// CanCreateShares reports whether the admin may create new shares.
+func (a Admin) CanCreateShares() bool {
+	return a.Role == RoleOwner || a.Role == RoleEditor
+}
+
+// CanViewShare reports whether the share is visible to the admin. Owners see
+// every share, everyone else only the shares assigned to them.
+func (a Admin) CanViewShare(share Share) bool {
+	return a.IsOwner() || share.OwnedBy(a.ID)
+}
+
+// CanEditShare reports whether the admin may modify the share and its files.
+func (a Admin) CanEditShare(share Share) bool {
+	return a.IsOwner() || (a.Role == RoleEditor && a.CanViewShare(share))
+}
+
+type AdminSession struct {
+	ID         int
+	AdminID    *int
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Actors of audit events.
+const (
+	ActorAdmin    = "admin"
+	ActorAPIKey   = "api_key"
+	ActorReviewer = "reviewer"
+	ActorVisitor  = "visitor"
+)
+
+// AuditEvent records who did what to which object. Target is a readable name
+// of the object, kept for when the object is deleted.
+type AuditEvent struct {
+	ID         int
+	ActorType  string
+	ActorID    *int
+	ActorName  string
+	Action     string
+	TargetType string
+	TargetID   *int
+	Target     string
+	IP         string
+	CreatedAt  time.Time
 }
 
 type ShareWithStats struct {
@@ -36,7 +278,29 @@ type ShareWithStats struct {
 	CommentCount int
 }
 
+// TrashedFile is a deleted file in the trash together with its share.
+type TrashedFile struct {
+	File
+	Share Share
+}
+
+// TrashedComment is a deleted comment in the trash together with its file
+// and share.
+type TrashedComment struct {
+	Comment
+	Filename string
+	Share    Share
+}
+
+// FileWithComments is a file with its most recent comments. CommentCount is
+// the number of all its comments, earlier ones are loaded on demand.
 type FileWithComments struct {
 	File
-	Comments []Comment
+	Comments     []Comment
+	CommentCount int
+}
+
+// HasEarlierComments reports whether comments before the loaded ones exist.
+func (f FileWithComments) HasEarlierComments() bool {
+	return f.CommentCount > len(f.Comments)
 }
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTestPostgres connects to the PostgreSQL server of DATABASE_URL and
+// skips the test without it. Every test works in a schema of its own, which
+// is dropped afterwards.
+func openTestPostgres(t *testing.T) string {
+	t.Helper()
+
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		t.Skip("DATABASE_URL is not set")
+	}
+	if !IsPostgresURL(url) {
+		t.Fatalf("DATABASE_URL is not a postgres:// URL")
+	}
+
+	admin, err := Open(url)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("feedback_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec("CREATE SCHEMA " + schema); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() { admin.Exec("DROP SCHEMA " + schema + " CASCADE") })
+
+	separator := "?"
+	if strings.Contains(url, "?") {
+		separator = "&"
+	}
+	return url + separator + "search_path=" + schema
+}
+
+func TestPostgresMigrations(t *testing.T) {
+	dsn := openTestPostgres(t)
+	migrations, err := Migrations(Postgres)
+	if err != nil {
+		t.Fatalf("migrations: %v", err)
+	}
+
+	// Instances starting at the same time apply every migration once
+	var wg sync.WaitGroup
+	applied := make([][]Migration, 3)
+	errs := make([]error, len(applied))
+	for i := range applied {
+		db, err := Open(dsn)
+		if err != nil {
+			t.Fatalf("open database: %v", err)
+		}
+		defer db.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			applied[i], errs[i] = MigrateUp(db)
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i := range applied {
+		if errs[i] != nil {
+			t.Fatalf("migrate up: %v", errs[i])
+		}
+		total += len(applied[i])
+	}
+	if total != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", total, len(migrations))
+	}
+
+	db, err := Open(dsn)
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	defer db.Close()
+
+	// Placeholders are rebound and ids are returned on insert
+	id, err := db.Insert("INSERT INTO shares (hash, name, description) VALUES (?, ?, '')", "share-hash", "Share")
+	if err != nil || id == 0 {
+		t.Fatalf("insert share: id %d, error %v", id, err)
+	}
+	var name string
+	if err := db.QueryRow("SELECT name FROM shares WHERE id = ? AND hash = ?", id, "share-hash").Scan(&name); err != nil || name != "Share" {
+		t.Fatalf("select share: %q, %v", name, err)
+	}
+	if _, err := db.Exec("INSERT INTO files (share_id, hash, filename, storage_path, mime_type, size_bytes) VALUES (?, 'file-hash', 'a.txt', 'a.txt', 'text/plain', 1)", id+1); err == nil {
+		t.Error("file of an unknown share inserted")
+	}
+
+	if _, err := MigrateDown(db, len(migrations), false); !errors.Is(err, ErrInitialMigration) {
+		t.Errorf("revert all: error %v, want ErrInitialMigration", err)
+	}
+	reverted, err := MigrateDown(db, len(migrations), true)
+	if err != nil || len(reverted) != len(migrations) {
+		t.Fatalf("force revert all: reverted %d, error %v", len(reverted), err)
+	}
+	if _, err := MigrateUp(db); err != nil {
+		t.Fatalf("migrate up again: %v", err)
+	}
+}
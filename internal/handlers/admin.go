@@ -5,56 +5,81 @@ import (
 	"html/template"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
 	"github.com/romanzipp/feedback/internal/services"
 )
 
 type AdminHandler struct {
-	templates    *template.Template
-	shareService *services.ShareService
-	fileService  *services.FileService
+	templates           *template.Template
+	shareService        *services.ShareService
+	fileService         *services.FileService
+	subscriptionService *services.SubscriptionService
+	reviewerService     *services.ReviewerService
+	apiKeyService       *services.APIKeyService
+	adminService        *services.AdminService
+	adminSessionService *services.AdminSessionService
+	auditService        *services.AuditService
+	trashService        *services.TrashService
+	maxUploadSize       int64
 }
 
-func NewAdminHandler(templates *template.Template, shareService *services.ShareService, fileService *services.FileService) *AdminHandler {
+func NewAdminHandler(templates *template.Template, shareService *services.ShareService, fileService *services.FileService, subscriptionService *services.SubscriptionService, reviewerService *services.ReviewerService, apiKeyService *services.APIKeyService, adminService *services.AdminService, adminSessionService *services.AdminSessionService, auditService *services.AuditService, trashService *services.TrashService, maxUploadSize int64) *AdminHandler {
 	return &AdminHandler{
-		templates:    templates,
-		shareService: shareService,
-		fileService:  fileService,
+		templates:           templates,
+		shareService:        shareService,
+		fileService:         fileService,
+		subscriptionService: subscriptionService,
+		reviewerService:     reviewerService,
+		apiKeyService:       apiKeyService,
+		adminService:        adminService,
+		adminSessionService: adminSessionService,
+		auditService:        auditService,
+		trashService:        trashService,
+		maxUploadSize:       maxUploadSize,
 	}
 }
 
 func (h *AdminHandler) Dashboard(w http.ResponseWriter, r *http.Request) {
-	token := chi.URLParam(r, "token")
-	shares, err := h.shareService.List()
+	admin := middleware.GetAdmin(r)
+
+	var shares []database.ShareWithStats
+	var err error
+	if admin.IsOwner() {
+		shares, err = h.shareService.List(r.Context())
+	} else {
+		shares, err = h.shareService.ListOwnedBy(r.Context(), admin.ID)
+	}
 	if err != nil {
 		http.Error(w, "Failed to load shares", http.StatusInternalServerError)
 		return
 	}
 
 	data := map[string]interface{}{
-		"Token":  token,
 		"Shares": shares,
 	}
 
-	if err := h.templates.ExecuteTemplate(w, "dashboard", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	render(w, r, h.templates, "dashboard", data)
 }
 
 func (h *AdminHandler) NewShareForm(w http.ResponseWriter, r *http.Request) {
-	token := chi.URLParam(r, "token")
-	data := map[string]interface{}{
-		"Token": token,
+	if !middleware.GetAdmin(r).CanCreateShares() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
 	}
 
-	if err := h.templates.ExecuteTemplate(w, "share_form", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	render(w, r, h.templates, "share_form", nil)
 }
 
 func (h *AdminHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
-	token := chi.URLParam(r, "token")
+	admin := middleware.GetAdmin(r)
+	if !admin.CanCreateShares() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Invalid form data", http.StatusBadRequest)
@@ -69,59 +94,338 @@ func (h *AdminHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	share, err := h.shareService.Create(name, description)
+	password := r.FormValue("password")
+	if password != "" {
+		if err := services.ValidatePassword(password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	expiresAt, err := parseExpiryDate(r.FormValue("expires_at"))
+	if err != nil {
+		http.Error(w, "Invalid expiry date", http.StatusBadRequest)
+		return
+	}
+
+	// Shares created with the admin token have no owner
+	var ownerID *int
+	if admin.ID != services.TokenAdmin.ID {
+		ownerID = &admin.ID
+	}
+
+	share, err := h.shareService.Create(r.Context(), name, description, ownerID, services.ShareOptions{Password: password, ExpiresAt: expiresAt})
 	if err != nil {
 		http.Error(w, "Failed to create share", http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, "/admin/"+token+"/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+	h.auditService.Record(r.Context(), auditEvent(r, "share.create", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
 }
 
 func (h *AdminHandler) ShareDetail(w http.ResponseWriter, r *http.Request) {
-	token := chi.URLParam(r, "token")
-	shareID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	share, ok := h.loadShare(w, r, false)
+	if !ok {
+		return
+	}
+
+	files, err := h.fileService.GetByShareID(r.Context(), share.ID)
 	if err != nil {
-		http.NotFound(w, r)
+		http.Error(w, "Failed to load files", http.StatusInternalServerError)
+		return
+	}
+
+	links, err := h.shareService.ListLinks(r.Context(), share.ID)
+	if err != nil {
+		http.Error(w, "Failed to load links", http.StatusInternalServerError)
+		return
+	}
+
+	reviewers, err := h.reviewerService.ListByShareID(r.Context(), share.ID)
+	if err != nil {
+		http.Error(w, "Failed to load reviewers", http.StatusInternalServerError)
+		return
+	}
+
+	admin := middleware.GetAdmin(r)
+	data := map[string]interface{}{
+		"Share":       share,
+		"Files":       files,
+		"Links":       links,
+		"Reviewers":   reviewers,
+		"Permissions": database.Permissions,
+		"CanEdit":     admin.CanEditShare(*share),
+		"ScanEnabled": h.fileService.ScanEnabled(),
+	}
+
+	// Owners can reassign the share to another admin
+	if admin.IsOwner() {
+		admins, err := h.adminService.List(r.Context())
+		if err != nil {
+			http.Error(w, "Failed to load admins", http.StatusInternalServerError)
+			return
+		}
+		data["Admins"] = admins
+	}
+
+	render(w, r, h.templates, "share_detail", data)
+}
+
+func (h *AdminHandler) SetShareOwner(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	var ownerID *int
+	if v := r.FormValue("owner_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, "Invalid owner", http.StatusBadRequest)
+			return
+		}
+		if _, err := h.adminService.GetByID(r.Context(), id); err != nil {
+			http.Error(w, "Invalid owner", http.StatusBadRequest)
+			return
+		}
+		ownerID = &id
+	}
+
+	if err := h.shareService.SetOwner(r.Context(), share.ID, ownerID); err != nil {
+		http.Error(w, "Failed to update owner", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.owner", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+}
+
+// SetSharePassword changes or removes the password of a share.
+func (h *AdminHandler) SetSharePassword(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	password := r.FormValue("password")
+	if r.FormValue("remove") != "" {
+		password = ""
+	} else if err := services.ValidatePassword(password); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.shareService.SetPassword(r.Context(), share.ID, password); err != nil {
+		http.Error(w, "Failed to update password", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.subscriptionService.CancelRevoked(r.Context(), share.ID); err != nil {
+		http.Error(w, "Failed to cancel subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.password", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+}
+
+// SetShareExpiry changes or removes the expiry date of a share.
+func (h *AdminHandler) SetShareExpiry(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	var expiresAt *time.Time
+	if r.FormValue("remove") == "" {
+		var err error
+		expiresAt, err = parseExpiryDate(r.FormValue("expires_at"))
+		if err != nil || expiresAt == nil {
+			http.Error(w, "Invalid expiry date", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := h.shareService.SetExpiry(r.Context(), share.ID, expiresAt); err != nil {
+		http.Error(w, "Failed to update expiry", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.expiry", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+}
+
+// CreateShareLink adds an access link with its own permission to a share.
+func (h *AdminHandler) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
 		return
 	}
 
-	share, err := h.shareService.GetByID(shareID)
+	link, err := h.shareService.CreateLink(r.Context(), share.ID, r.FormValue("label"), r.FormValue("permission"))
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share_link.create", auditTargetLink, link.ID, share.Name+": "+link.Label+" ("+link.Permission+")"))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+}
+
+// RevokeShareLink disables an access link of a share.
+func (h *AdminHandler) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	linkID, err := strconv.Atoi(chi.URLParam(r, "linkID"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := h.shareService.RevokeLink(r.Context(), share.ID, linkID); err != nil {
 		if err == sql.ErrNoRows {
 			http.NotFound(w, r)
 			return
 		}
-		http.Error(w, "Failed to load share", http.StatusInternalServerError)
+		http.Error(w, "Failed to revoke link", http.StatusInternalServerError)
 		return
 	}
 
-	files, err := h.fileService.GetByShareID(shareID)
-	if err != nil {
-		http.Error(w, "Failed to load files", http.StatusInternalServerError)
+	if err := h.subscriptionService.CancelRevoked(r.Context(), share.ID); err != nil {
+		http.Error(w, "Failed to cancel subscriptions", http.StatusInternalServerError)
 		return
 	}
 
-	data := map[string]interface{}{
-		"Token": token,
-		"Share": share,
-		"Files": files,
+	h.auditService.Record(r.Context(), auditEvent(r, "share_link.revoke", auditTargetLink, linkID, share.Name))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+}
+
+// SetShareInviteOnly limits the share to invited reviewers or opens it to
+// everyone with a link.
+func (h *AdminHandler) SetShareInviteOnly(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.shareService.SetInviteOnly(r.Context(), share.ID, r.FormValue("invite_only") != ""); err != nil {
+		http.Error(w, "Failed to update share", http.StatusInternalServerError)
+		return
 	}
 
-	if err := h.templates.ExecuteTemplate(w, "share_detail", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if err := h.subscriptionService.CancelRevoked(r.Context(), share.ID); err != nil {
+		http.Error(w, "Failed to cancel subscriptions", http.StatusInternalServerError)
+		return
 	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.invite_only", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
 }
 
-func (h *AdminHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
-	token := chi.URLParam(r, "token")
-	shareID, err := strconv.Atoi(chi.URLParam(r, "id"))
+// InviteReviewer emails a personal magic link to a reviewer of the share.
+func (h *AdminHandler) InviteReviewer(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	reviewer, err := h.reviewerService.Invite(r.Context(), share, r.FormValue("name"), r.FormValue("email"), r.FormValue("permission"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "reviewer.invite", auditTargetReviewer, reviewer.ID, share.Name+": "+reviewer.Email))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+}
+
+// RevokeReviewer disables the magic link of a reviewer and signs them out.
+func (h *AdminHandler) RevokeReviewer(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+
+	reviewerID, err := strconv.Atoi(chi.URLParam(r, "reviewerID"))
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	if err := r.ParseMultipartForm(200 << 20); err != nil { // 200MB for multiple files
+	if err := h.reviewerService.Revoke(r.Context(), share.ID, reviewerID); err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to revoke reviewer", http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.subscriptionService.CancelRevoked(r.Context(), share.ID); err != nil {
+		http.Error(w, "Failed to cancel subscriptions", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "reviewer.revoke", auditTargetReviewer, reviewerID, share.Name))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(share.ID), http.StatusSeeOther)
+}
+
+func (h *AdminHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
+		return
+	}
+	shareID := share.ID
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		if tooLarge(err) {
+			http.Error(w, "Upload exceeds the maximum size of "+strconv.FormatInt(h.maxUploadSize, 10)+" bytes", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
@@ -134,30 +438,37 @@ func (h *AdminHandler) UploadFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Save each file
+	saved := make([]database.File, 0, len(files))
 	for _, header := range files {
-		if _, err := h.fileService.Save(shareID, header); err != nil {
+		file, err := h.fileService.Save(r.Context(), shareID, header)
+		if err != nil {
+			h.subscriptionService.NotifyUploads(r.Context(), share, saved)
 			http.Error(w, "Failed to save file: "+header.Filename, http.StatusInternalServerError)
 			return
 		}
+		saved = append(saved, *file)
+		h.auditService.Record(r.Context(), auditEvent(r, "file.upload", auditTargetFile, file.ID, share.Name+": "+file.Filename))
 	}
 
-	http.Redirect(w, r, "/admin/"+token+"/shares/"+strconv.Itoa(shareID), http.StatusSeeOther)
+	h.subscriptionService.NotifyUploads(r.Context(), share, saved)
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(shareID), http.StatusSeeOther)
 }
 
 func (h *AdminHandler) DeleteShare(w http.ResponseWriter, r *http.Request) {
-	token := chi.URLParam(r, "token")
-	shareID, err := strconv.Atoi(chi.URLParam(r, "id"))
-	if err != nil {
-		http.NotFound(w, r)
+	share, ok := h.loadShare(w, r, true)
+	if !ok {
 		return
 	}
 
-	if err := h.shareService.Delete(shareID); err != nil {
+	if err := h.shareService.Delete(r.Context(), share.ID); err != nil {
 		http.Error(w, "Failed to delete share", http.StatusInternalServerError)
 		return
 	}
 
-	http.Redirect(w, r, "/admin/"+token, http.StatusSeeOther)
+	h.auditService.Record(r.Context(), auditEvent(r, "share.delete", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
 }
 
 func (h *AdminHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
@@ -167,17 +478,111 @@ func (h *AdminHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := h.fileService.GetByID(fileID)
+	file, err := h.fileService.GetByID(r.Context(), fileID)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
-	if err := h.fileService.Delete(fileID); err != nil {
+	share, err := h.shareService.GetByID(r.Context(), file.ShareID)
+	if err != nil || !middleware.GetAdmin(r).CanViewShare(*share) {
+		http.NotFound(w, r)
+		return
+	}
+	if !middleware.GetAdmin(r).CanEditShare(*share) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.fileService.Delete(r.Context(), fileID); err != nil {
 		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
 		return
 	}
 
-	token := chi.URLParam(r, "token")
-	http.Redirect(w, r, "/admin/"+token+"/shares/"+strconv.Itoa(file.ShareID), http.StatusSeeOther)
+	h.auditService.Record(r.Context(), auditEvent(r, "file.delete", auditTargetFile, file.ID, share.Name+": "+file.Filename))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(file.ShareID), http.StatusSeeOther)
+}
+
+// ScanFile scans a file for malware again, e.g. after clamd was unreachable
+// during the upload.
+func (h *AdminHandler) ScanFile(w http.ResponseWriter, r *http.Request) {
+	fileID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := h.fileService.GetByID(r.Context(), fileID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), file.ShareID)
+	if err != nil || !middleware.GetAdmin(r).CanViewShare(*share) {
+		http.NotFound(w, r)
+		return
+	}
+	if !middleware.GetAdmin(r).CanEditShare(*share) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := h.fileService.Scan(r.Context(), fileID); err != nil {
+		http.Error(w, "Failed to scan file: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "file.scan", auditTargetFile, file.ID, share.Name+": "+file.Filename))
+
+	http.Redirect(w, r, "/admin/shares/"+strconv.Itoa(file.ShareID), http.StatusSeeOther)
+}
+
+// loadShare resolves the share of the {id} route parameter. Shares the admin
+// may not see are reported as missing; edit requires write access.
+func (h *AdminHandler) loadShare(w http.ResponseWriter, r *http.Request, edit bool) (*database.Share, bool) {
+	shareID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), shareID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return nil, false
+		}
+		http.Error(w, "Failed to load share", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	admin := middleware.GetAdmin(r)
+	if !admin.CanViewShare(*share) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if edit && !admin.CanEditShare(*share) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return nil, false
+	}
+
+	return share, true
+}
+
+// parseExpiryDate parses a date input. The expiry is the end of that day, so
+// the selected day is still included. An empty value means no expiry.
+func parseExpiryDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	day, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+
+	end := day.AddDate(0, 0, 1).UTC()
+	return &end, nil
 }
@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+func (h *AdminHandler) APIKeys(w http.ResponseWriter, r *http.Request) {
+	h.renderAPIKeys(w, r, "")
+}
+
+func (h *AdminHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		http.Error(w, "Name is required", http.StatusBadRequest)
+		return
+	}
+
+	scopes := r.Form["scopes"]
+	if len(scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+
+	expiresAt, err := parseExpiryDate(r.FormValue("expires_at"))
+	if err != nil {
+		http.Error(w, "Invalid expiry date", http.StatusBadRequest)
+		return
+	}
+
+	apiKey, key, err := h.apiKeyService.Create(r.Context(), name, scopes, expiresAt)
+	if err != nil {
+		http.Error(w, "Failed to create API key: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "api_key.create", auditTargetAPIKey, apiKey.ID, apiKey.Name+" ("+strings.Join(apiKey.Scopes, ", ")+")"))
+
+	// The plaintext key is only available in this response
+	h.renderAPIKeys(w, r, key)
+}
+
+func (h *AdminHandler) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The name is kept for the audit log
+	name := ""
+	if apiKey, err := h.apiKeyService.GetByID(r.Context(), keyID); err == nil {
+		name = apiKey.Name
+	}
+
+	if err := h.apiKeyService.Delete(r.Context(), keyID); err != nil {
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "api_key.delete", auditTargetAPIKey, keyID, name))
+
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) renderAPIKeys(w http.ResponseWriter, r *http.Request, newKey string) {
+	keys, err := h.apiKeyService.List(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to load API keys", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Keys":   keys,
+		"Scopes": services.APIScopes,
+		"NewKey": newKey,
+	}
+
+	render(w, r, h.templates, "api_keys", data)
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+// auditPageSize is the number of events per page of the audit log.
+const auditPageSize = 50
+
+func (h *AdminHandler) AuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseAuditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	total, err := h.auditService.Count(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	events, err := h.auditService.List(r.Context(), filter, auditPageSize, (page-1)*auditPageSize)
+	if err != nil {
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	actions, err := h.auditService.Actions(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	// Pagination and export links keep the filter
+	query := r.URL.Query()
+	query.Del("page")
+	pageURL := func(p int) string {
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		q.Set("page", strconv.Itoa(p))
+		return "/admin/audit?" + q.Encode()
+	}
+
+	data := map[string]interface{}{
+		"Events":    events,
+		"Actions":   actions,
+		"Filter":    filter,
+		"From":      query.Get("from"),
+		"To":        query.Get("to"),
+		"Total":     total,
+		"ExportURL": "/admin/audit.csv?" + query.Encode(),
+	}
+	if page > 1 {
+		data["PrevURL"] = pageURL(page - 1)
+	}
+	if page*auditPageSize < total {
+		data["NextURL"] = pageURL(page + 1)
+	}
+
+	render(w, r, h.templates, "audit", data)
+}
+
+// ExportAuditLog downloads the filtered audit log as CSV.
+func (h *AdminHandler) ExportAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseAuditFilter(w, r)
+	if !ok {
+		return
+	}
+
+	events, err := h.auditService.All(r.Context(), filter)
+	if err != nil {
+		http.Error(w, "Failed to load audit log", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"audit-"+time.Now().UTC().Format("2006-01-02")+".csv\"")
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"time", "actor_type", "actor_id", "actor", "action", "target_type", "target_id", "target", "ip"})
+	for _, e := range events {
+		out.Write([]string{
+			e.CreatedAt.UTC().Format(time.RFC3339),
+			csvSafe(e.ActorType),
+			optionalID(e.ActorID),
+			csvSafe(e.ActorName),
+			csvSafe(e.Action),
+			csvSafe(e.TargetType),
+			optionalID(e.TargetID),
+			csvSafe(e.Target),
+			csvSafe(e.IP),
+		})
+	}
+	out.Flush()
+}
+
+// parseAuditFilter reads the filter from the query. Dates are whole days,
+// including the "to" day.
+func parseAuditFilter(w http.ResponseWriter, r *http.Request) (services.AuditFilter, bool) {
+	query := r.URL.Query()
+	filter := services.AuditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+	}
+
+	if from := query.Get("from"); from != "" {
+		day, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			http.Error(w, "Invalid from date", http.StatusBadRequest)
+			return filter, false
+		}
+		filter.From = &day
+	}
+
+	to, err := parseExpiryDate(query.Get("to"))
+	if err != nil {
+		http.Error(w, "Invalid to date", http.StatusBadRequest)
+		return filter, false
+	}
+	filter.To = to
+
+	return filter, true
+}
+
+func optionalID(id *int) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.Itoa(*id)
+}
+
+// csvSafe keeps names entered by visitors from being run as spreadsheet
+// formulas when the export is opened. Spreadsheets also ignore a leading tab
+// or carriage return before a formula.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,61 @@
+package handlers_test
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAuditExportEscapesFormulas(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	// Visitors choose the username of a failed login, admins the share name
+	visitor := app.newBrowser()
+	visitor.post("/admin/login", url.Values{"username": {"\t=1+1"}, "password": {"wrong password"}})
+	visitor.post("/admin/login", url.Values{"username": {"\r@SUM(A1)"}, "password": {"wrong password"}})
+	app.createShare(map[string]interface{}{"name": "=HYPERLINK(\"https://example.com\")"})
+	app.createShare(map[string]interface{}{"name": "-2+3"})
+
+	admin := app.newBrowser()
+	admin.loginWithToken()
+	resp := admin.get("/admin/audit.csv")
+	expectStatus(t, resp, http.StatusOK)
+
+	records, err := csv.NewReader(strings.NewReader(resp.body)).ReadAll()
+	if err != nil {
+		t.Fatalf("parse export: %v", err)
+	}
+	if len(records) < 5 {
+		t.Fatalf("export has %d rows", len(records))
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+	want := map[string]bool{
+		"'\t=1+1":                              false,
+		"'\r@SUM(A1)":                          false,
+		"'=HYPERLINK(\"https://example.com\")": false,
+		"'-2+3":                                false,
+	}
+	for _, record := range records[1:] {
+		for _, value := range record {
+			if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+				t.Errorf("unescaped value %q", value)
+			}
+		}
+		for _, column := range []string{"actor", "target"} {
+			if _, ok := want[record[columns[column]]]; ok {
+				want[record[columns[column]]] = true
+			}
+		}
+	}
+	for value, found := range want {
+		if !found {
+			t.Errorf("escaped value %q not exported", value)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+)
+
+func (h *AdminHandler) Trash(w http.ResponseWriter, r *http.Request) {
+	trash, err := h.trashService.List(r.Context(), middleware.GetAdmin(r))
+	if err != nil {
+		http.Error(w, "Failed to load trash", http.StatusInternalServerError)
+		return
+	}
+
+	render(w, r, h.templates, "trash", map[string]interface{}{
+		"Trash":     trash,
+		"Retention": formatRetention(h.trashService.Retention()),
+	})
+}
+
+func (h *AdminHandler) RestoreShare(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadTrashedShare(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.shareService.Restore(r.Context(), share.ID); err != nil {
+		http.Error(w, "Failed to restore share", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.restore", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) PurgeShare(w http.ResponseWriter, r *http.Request) {
+	share, ok := h.loadTrashedShare(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.trashService.PurgeShare(r.Context(), share.ID); err != nil {
+		http.Error(w, "Failed to delete share", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.purge", auditTargetShare, share.ID, share.Name))
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) RestoreFile(w http.ResponseWriter, r *http.Request) {
+	file, share, ok := h.loadTrashedFile(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.fileService.Restore(r.Context(), file.ID); err != nil {
+		http.Error(w, "Failed to restore file", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "file.restore", auditTargetFile, file.ID, share.Name+": "+file.Filename))
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) PurgeFile(w http.ResponseWriter, r *http.Request) {
+	file, share, ok := h.loadTrashedFile(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.fileService.Purge(r.Context(), file.ID); err != nil {
+		http.Error(w, "Failed to delete file", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "file.purge", auditTargetFile, file.ID, share.Name+": "+file.Filename))
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) RestoreComment(w http.ResponseWriter, r *http.Request) {
+	comment, file, ok := h.loadTrashedComment(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.fileService.RestoreComment(r.Context(), comment.ID); err != nil {
+		http.Error(w, "Failed to restore comment", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "comment.restore", auditTargetComment, comment.ID, file.Filename+": "+comment.Username))
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) PurgeComment(w http.ResponseWriter, r *http.Request) {
+	comment, file, ok := h.loadTrashedComment(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.fileService.PurgeComment(r.Context(), comment.ID); err != nil {
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "comment.purge", auditTargetComment, comment.ID, file.Filename+": "+comment.Username))
+
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// loadTrashedShare loads the deleted share from the URL if the admin may
+// edit it.
+func (h *AdminHandler) loadTrashedShare(w http.ResponseWriter, r *http.Request) (*database.Share, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	share, err := h.shareService.GetDeleted(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if !canEditTrashed(w, r, share) {
+		return nil, false
+	}
+	return share, true
+}
+
+// loadTrashedFile loads the deleted file from the URL and its share. Files of
+// deleted shares are restored with the share instead.
+func (h *AdminHandler) loadTrashedFile(w http.ResponseWriter, r *http.Request) (*database.File, *database.Share, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	file, err := h.fileService.GetDeleted(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), file.ShareID)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+	if !canEditTrashed(w, r, share) {
+		return nil, nil, false
+	}
+	return file, share, true
+}
+
+// loadTrashedComment loads the deleted comment from the URL and its file.
+func (h *AdminHandler) loadTrashedComment(w http.ResponseWriter, r *http.Request) (*database.Comment, *database.File, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	comment, err := h.fileService.GetDeletedComment(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	file, err := h.fileService.GetByID(r.Context(), comment.FileID)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), file.ShareID)
+	if err != nil {
+		http.NotFound(w, r)
+		return nil, nil, false
+	}
+	if !canEditTrashed(w, r, share) {
+		return nil, nil, false
+	}
+	return comment, file, true
+}
+
+// canEditTrashed checks like loadShare whether the admin may restore or purge
+// items of the share.
+func canEditTrashed(w http.ResponseWriter, r *http.Request, share *database.Share) bool {
+	admin := middleware.GetAdmin(r)
+	if !admin.CanViewShare(*share) {
+		http.NotFound(w, r)
+		return false
+	}
+	if !admin.CanEditShare(*share) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// formatRetention describes the trash retention in days where possible.
+func formatRetention(d time.Duration) string {
+	if d > 0 && d%(24*time.Hour) == 0 {
+		days := int(d / (24 * time.Hour))
+		if days == 1 {
+			return "1 day"
+		}
+		return fmt.Sprintf("%d days", days)
+	}
+	return d.String()
+}
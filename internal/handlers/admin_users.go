@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+func (h *AdminHandler) Admins(w http.ResponseWriter, r *http.Request) {
+	h.renderAdmins(w, r, "", http.StatusOK)
+}
+
+func (h *AdminHandler) CreateAdmin(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	admin, err := h.adminService.Create(r.Context(), r.FormValue("username"), r.FormValue("password"), r.FormValue("role"))
+	if err != nil {
+		h.renderAdmins(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.create", auditTargetAdmin, admin.ID, admin.Username+" ("+admin.Role+")"))
+
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) UpdateAdminRole(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := h.otherAdminID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.adminService.SetRole(r.Context(), adminID, r.FormValue("role")); err != nil {
+		h.renderAdmins(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.role", auditTargetAdmin, adminID, h.adminName(r.Context(), adminID)+" ("+r.FormValue("role")+")"))
+
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) ResetAdminPassword(w http.ResponseWriter, r *http.Request) {
+	adminID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	admin, err := h.adminService.GetByID(r.Context(), adminID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.adminService.SetPassword(r.Context(), adminID, r.FormValue("password")); err != nil {
+		h.renderAdmins(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A new password signs the admin out everywhere else
+	current := middleware.GetAdminSession(r)
+	if current.AdminID != nil && *current.AdminID == adminID {
+		err = h.adminSessionService.RevokeOthers(r.Context(), &adminID, current.ID)
+	} else {
+		err = h.adminSessionService.RevokeAll(r.Context(), &adminID)
+	}
+	if err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.password_reset", auditTargetAdmin, adminID, admin.Username))
+
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
+
+// ResetAdminTOTP turns off two-factor authentication for an admin who lost
+// their authenticator and recovery codes.
+func (h *AdminHandler) ResetAdminTOTP(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := h.otherAdminID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.adminService.DisableTOTP(r.Context(), adminID); err != nil {
+		http.Error(w, "Failed to reset two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.2fa_reset", auditTargetAdmin, adminID, h.adminName(r.Context(), adminID)))
+
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
+
+func (h *AdminHandler) DeleteAdmin(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := h.otherAdminID(w, r)
+	if !ok {
+		return
+	}
+
+	// The name is looked up first, the account is gone afterwards
+	username := h.adminName(r.Context(), adminID)
+	if err := h.adminService.Delete(r.Context(), adminID); err != nil {
+		http.Error(w, "Failed to delete admin", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.delete", auditTargetAdmin, adminID, username))
+
+	http.Redirect(w, r, "/admin/admins", http.StatusSeeOther)
+}
+
+// otherAdminID parses the {id} route parameter and rejects the signed-in
+// admin, who must not demote or delete themselves.
+func (h *AdminHandler) otherAdminID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	adminID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return 0, false
+	}
+
+	if adminID == middleware.GetAdmin(r).ID {
+		http.Error(w, "You cannot change your own role or account", http.StatusBadRequest)
+		return 0, false
+	}
+
+	return adminID, true
+}
+
+// adminName returns the username of an admin for the audit log.
+func (h *AdminHandler) adminName(ctx context.Context, adminID int) string {
+	admin, err := h.adminService.GetByID(ctx, adminID)
+	if err != nil {
+		return ""
+	}
+	return admin.Username
+}
+
+func (h *AdminHandler) renderAdmins(w http.ResponseWriter, r *http.Request, errorMessage string, status int) {
+	admins, err := h.adminService.List(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to load admins", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Admins": admins,
+		"Roles":  services.AdminRoles,
+		"Error":  errorMessage,
+	}
+
+	w.WriteHeader(status)
+	render(w, r, h.templates, "admins", data)
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+type APIHandler struct {
+	shareService        *services.ShareService
+	fileService         *services.FileService
+	subscriptionService *services.SubscriptionService
+	urlSigner           *services.URLSigner
+	auditService        *services.AuditService
+	maxUploadSize       int64
+}
+
+func NewAPIHandler(shareService *services.ShareService, fileService *services.FileService, subscriptionService *services.SubscriptionService, urlSigner *services.URLSigner, auditService *services.AuditService, maxUploadSize int64) *APIHandler {
+	return &APIHandler{
+		shareService:        shareService,
+		fileService:         fileService,
+		subscriptionService: subscriptionService,
+		urlSigner:           urlSigner,
+		auditService:        auditService,
+		maxUploadSize:       maxUploadSize,
+	}
+}
+
+type apiShare struct {
+	ID           int        `json:"id"`
+	Hash         string     `json:"hash"`
+	Name         string     `json:"name"`
+	Description  string     `json:"description"`
+	URL          string     `json:"url"`
+	Protected    bool       `json:"password_protected"`
+	ExpiresAt    *time.Time `json:"expires_at"`
+	InviteOnly   bool       `json:"invite_only"`
+	FileCount    *int       `json:"file_count,omitempty"`
+	CommentCount *int       `json:"comment_count,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type apiFile struct {
+	ID         int        `json:"id"`
+	ShareID    int        `json:"share_id"`
+	Hash       string     `json:"hash"`
+	Filename   string     `json:"filename"`
+	MimeType   string     `json:"mime_type"`
+	SizeBytes  int64      `json:"size_bytes"`
+	URL        string     `json:"url"`
+	UploadedAt time.Time  `json:"uploaded_at"`
+	ApprovedAt *time.Time `json:"approved_at"`
+	ApprovedBy *string    `json:"approved_by"`
+	ScanStatus string     `json:"scan_status"`
+	Signature  *string    `json:"scan_signature"`
+}
+
+type apiSignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type apiComment struct {
+	ID        int       `json:"id"`
+	FileID    int       `json:"file_id"`
+	Username  string    `json:"username"`
+	Verified  bool      `json:"verified"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type apiPagination struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Total  int `json:"total"`
+}
+
+func toAPIShare(s database.Share) apiShare {
+	return apiShare{
+		ID:          s.ID,
+		Hash:        s.Hash,
+		Name:        s.Name,
+		Description: s.Description,
+		URL:         "/share/" + s.Hash,
+		Protected:   s.HasPassword(),
+		ExpiresAt:   s.ExpiresAt,
+		InviteOnly:  s.InviteOnly,
+		CreatedAt:   s.CreatedAt,
+		UpdatedAt:   s.UpdatedAt,
+	}
+}
+
+func toAPIFile(f database.File) apiFile {
+	return apiFile{
+		ID:         f.ID,
+		ShareID:    f.ShareID,
+		Hash:       f.Hash,
+		Filename:   f.Filename,
+		MimeType:   f.MimeType,
+		SizeBytes:  f.SizeBytes,
+		URL:        "/files/" + f.Hash,
+		UploadedAt: f.UploadedAt,
+		ApprovedAt: f.ApprovedAt,
+		ApprovedBy: f.ApprovedBy,
+		ScanStatus: f.ScanStatus,
+		Signature:  f.ScanSignature,
+	}
+}
+
+func toAPIComment(c database.Comment) apiComment {
+	return apiComment{
+		ID:        c.ID,
+		FileID:    c.FileID,
+		Username:  c.Username,
+		Verified:  c.Verified(),
+		Content:   c.Content,
+		CreatedAt: c.CreatedAt,
+	}
+}
+
+func (h *APIHandler) NotFound(w http.ResponseWriter, r *http.Request) {
+	middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "Route not found")
+}
+
+func (h *APIHandler) MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	middleware.WriteAPIError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func writeJSONPage(w http.ResponseWriter, data interface{}, page apiPagination) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data":       data,
+		"pagination": page,
+	})
+}
+
+// decodeJSON reads a JSON request body into v and reports malformed input.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		middleware.WriteAPIError(w, http.StatusBadRequest, "invalid_body", "Request body must be valid JSON")
+		return false
+	}
+	return true
+}
+
+// parsePagination reads the limit and offset query parameters.
+func parsePagination(w http.ResponseWriter, r *http.Request) (apiPagination, bool) {
+	page := apiPagination{Limit: defaultPageLimit}
+
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			middleware.WriteAPIError(w, http.StatusBadRequest, "invalid_pagination", "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+			return page, false
+		}
+		page.Limit = limit
+	}
+
+	if v := r.URL.Query().Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			middleware.WriteAPIError(w, http.StatusBadRequest, "invalid_pagination", "offset must be a non-negative integer")
+			return page, false
+		}
+		page.Offset = offset
+	}
+
+	return page, true
+}
+
+// urlID parses a numeric route parameter, answering 404 for malformed values.
+func urlID(w http.ResponseWriter, r *http.Request, name, resource string) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, name))
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusNotFound, "not_found", resource+" not found")
+		return 0, false
+	}
+	return id, true
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+)
+
+type commentRequest struct {
+	Username string `json:"username"`
+	Content  string `json:"content"`
+}
+
+func (h *APIHandler) ListComments(w http.ResponseWriter, r *http.Request) {
+	fileID, ok := urlID(w, r, "id", "File")
+	if !ok {
+		return
+	}
+
+	if _, err := h.fileService.GetByID(r.Context(), fileID); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load file")
+		return
+	}
+
+	page, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+
+	total, err := h.fileService.CountComments(r.Context(), fileID)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load comments")
+		return
+	}
+
+	comments, err := h.fileService.CommentsPage(r.Context(), fileID, page.Limit, page.Offset)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load comments")
+		return
+	}
+
+	data := make([]apiComment, 0, len(comments))
+	for _, c := range comments {
+		data = append(data, toAPIComment(c))
+	}
+
+	page.Total = total
+	writeJSONPage(w, data, page)
+}
+
+func (h *APIHandler) CreateComment(w http.ResponseWriter, r *http.Request) {
+	fileID, ok := urlID(w, r, "id", "File")
+	if !ok {
+		return
+	}
+
+	file, err := h.fileService.GetByID(r.Context(), fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load file")
+		return
+	}
+
+	var req commentRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if strings.TrimSpace(req.Username) == "" {
+		middleware.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "Username is required")
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		middleware.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "Content is required")
+		return
+	}
+
+	comment, err := h.fileService.AddComment(r.Context(), file.ID, req.Username, req.Content, nil)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to add comment")
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "comment.create", auditTargetComment, comment.ID, file.Filename+": "+comment.Username))
+
+	if share, err := h.shareService.GetByID(r.Context(), file.ShareID); err == nil {
+		h.subscriptionService.NotifyComment(r.Context(), share, file, comment, "")
+	}
+
+	writeJSON(w, http.StatusCreated, toAPIComment(*comment))
+}
+
+func (h *APIHandler) DeleteComment(w http.ResponseWriter, r *http.Request) {
+	commentID, ok := urlID(w, r, "id", "Comment")
+	if !ok {
+		return
+	}
+
+	comment, err := h.fileService.GetComment(r.Context(), commentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "Comment not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load comment")
+		return
+	}
+
+	if err := h.fileService.DeleteComment(r.Context(), commentID); err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to delete comment")
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "comment.delete", auditTargetComment, commentID, h.commentTarget(r.Context(), comment)))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// commentTarget names a comment in the audit log by its file and author.
+func (h *APIHandler) commentTarget(ctx context.Context, comment *database.Comment) string {
+	if file, err := h.fileService.GetByID(ctx, comment.FileID); err == nil {
+		return file.Filename + ": " + comment.Username
+	}
+	return comment.Username
+}
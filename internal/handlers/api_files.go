@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+)
+
+func (h *APIHandler) ListFiles(w http.ResponseWriter, r *http.Request) {
+	shareID, ok := urlID(w, r, "id", "Share")
+	if !ok {
+		return
+	}
+
+	if _, err := h.shareService.GetByID(r.Context(), shareID); err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "Share not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load share")
+		return
+	}
+
+	page, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+
+	total, err := h.fileService.CountByShareID(r.Context(), shareID)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load files")
+		return
+	}
+
+	files, err := h.fileService.ListPage(r.Context(), shareID, page.Limit, page.Offset)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load files")
+		return
+	}
+
+	data := make([]apiFile, 0, len(files))
+	for _, f := range files {
+		data = append(data, toAPIFile(f))
+	}
+
+	page.Total = total
+	writeJSONPage(w, data, page)
+}
+
+func (h *APIHandler) UploadFiles(w http.ResponseWriter, r *http.Request) {
+	shareID, ok := urlID(w, r, "id", "Share")
+	if !ok {
+		return
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), shareID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "Share not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load share")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxUploadSize)
+	if err := r.ParseMultipartForm(multipartMemory); err != nil {
+		if tooLarge(err) {
+			middleware.WriteAPIError(w, http.StatusRequestEntityTooLarge, "too_large", "Upload exceeds the maximum size of "+strconv.FormatInt(h.maxUploadSize, 10)+" bytes")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusBadRequest, "invalid_body", "Request body must be multipart/form-data")
+		return
+	}
+
+	headers := r.MultipartForm.File["files"]
+	if len(headers) == 0 {
+		middleware.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "No files uploaded in the \"files\" field")
+		return
+	}
+
+	saved := make([]database.File, 0, len(headers))
+	for _, header := range headers {
+		file, err := h.fileService.Save(r.Context(), shareID, header)
+		if err != nil {
+			h.subscriptionService.NotifyUploads(r.Context(), share, saved)
+			middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to save file: "+header.Filename)
+			return
+		}
+		saved = append(saved, *file)
+		h.auditService.Record(r.Context(), auditEvent(r, "file.upload", auditTargetFile, file.ID, share.Name+": "+file.Filename))
+	}
+
+	h.subscriptionService.NotifyUploads(r.Context(), share, saved)
+
+	data := make([]apiFile, 0, len(saved))
+	for _, f := range saved {
+		data = append(data, toAPIFile(f))
+	}
+
+	writeJSON(w, http.StatusCreated, data)
+}
+
+func (h *APIHandler) GetFile(w http.ResponseWriter, r *http.Request) {
+	fileID, ok := urlID(w, r, "id", "File")
+	if !ok {
+		return
+	}
+
+	file, err := h.fileService.GetByID(r.Context(), fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load file")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAPIFile(*file))
+}
+
+// SignFileURL creates a short-lived download URL for embedding the file. It
+// works without a session, also when files are protected.
+func (h *APIHandler) SignFileURL(w http.ResponseWriter, r *http.Request) {
+	fileID, ok := urlID(w, r, "id", "File")
+	if !ok {
+		return
+	}
+
+	file, err := h.fileService.GetByID(r.Context(), fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load file")
+		return
+	}
+
+	url, expiresAt := h.urlSigner.SignFile(file.Hash)
+	writeJSON(w, http.StatusCreated, apiSignedURL{URL: url, ExpiresAt: expiresAt})
+}
+
+func (h *APIHandler) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	fileID, ok := urlID(w, r, "id", "File")
+	if !ok {
+		return
+	}
+
+	file, err := h.fileService.GetByID(r.Context(), fileID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "File not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load file")
+		return
+	}
+
+	if err := h.fileService.Delete(r.Context(), fileID); err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to delete file")
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "file.delete", auditTargetFile, file.ID, file.Filename))
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,158 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var apiKeyPattern = regexp.MustCompile(`fbk_[A-Za-z0-9_-]+`)
+
+// createAPIKey creates a key in the admin panel and returns the plaintext key
+// shown once on the page.
+func createAPIKey(t *testing.T, admin *browser, form url.Values) string {
+	t.Helper()
+
+	resp := admin.post("/admin/api-keys", form)
+	expectStatus(t, resp, http.StatusOK)
+
+	key := apiKeyPattern.FindString(resp.body)
+	if key == "" {
+		t.Fatal("new API key not shown")
+	}
+	return key
+}
+
+// apiStatus sends an API request with the bearer token and returns the status.
+func (a *testApp) apiStatus(method, path, token string) int {
+	r := newRequest(method, path, "")
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return a.do(r).Code
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := app.newBrowser()
+	admin.loginWithToken()
+
+	key := createAPIKey(t, admin, url.Values{"name": {"CI"}, "scopes": {"shares:read"}})
+
+	if status := app.apiStatus("GET", "/api/v1/shares", key); status != http.StatusOK {
+		t.Errorf("with key: status %d, want 200", status)
+	}
+	if status := app.apiStatus("GET", "/api/v1/shares", testAdminToken); status != http.StatusOK {
+		t.Errorf("with admin token: status %d, want 200", status)
+	}
+
+	// Change the last character of the key
+	modified := key[:len(key)-1] + "x"
+	if strings.HasSuffix(key, "x") {
+		modified = key[:len(key)-1] + "y"
+	}
+
+	for name, token := range map[string]string{
+		"no token":       "",
+		"unknown key":    "fbk_" + strings.Repeat("a", 40),
+		"modified key":   modified,
+		"without prefix": strings.TrimPrefix(key, "fbk_"),
+	} {
+		if status := app.apiStatus("GET", "/api/v1/shares", token); status != http.StatusUnauthorized {
+			t.Errorf("%s: status %d, want 401", name, status)
+		}
+	}
+
+	r := newRequest("GET", "/api/v1/shares", "")
+	r.Header.Set("Authorization", "Basic "+key)
+	if w := app.do(r); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("basic auth: status %d, WWW-Authenticate %q", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
+
+func TestAPIKeyStoredHashedWithLastUse(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := app.newBrowser()
+	admin.loginWithToken()
+
+	key := createAPIKey(t, admin, url.Values{"name": {"CI"}, "scopes": {"shares:read"}})
+
+	var keyHash string
+	var lastUsedAt *time.Time
+	if err := app.db.QueryRow("SELECT key_hash, last_used_at FROM api_keys").Scan(&keyHash, &lastUsedAt); err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	if strings.Contains(keyHash, strings.TrimPrefix(key, "fbk_")) {
+		t.Error("key stored in plaintext")
+	}
+	if lastUsedAt != nil {
+		t.Error("unused key has a last use")
+	}
+
+	app.apiStatus("GET", "/api/v1/shares", key)
+
+	if err := app.db.QueryRow("SELECT last_used_at FROM api_keys").Scan(&lastUsedAt); err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	if lastUsedAt == nil {
+		t.Error("last use not recorded")
+	}
+
+	// The key is only shown when it is created
+	if resp := admin.get("/admin/api-keys"); strings.Contains(resp.body, key) {
+		t.Error("plaintext key shown again")
+	}
+}
+
+func TestAPIKeyExpiredAndRevoked(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := app.newBrowser()
+	admin.loginWithToken()
+
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02")
+	expired := createAPIKey(t, admin, url.Values{"name": {"Old"}, "scopes": {"shares:read"}, "expires_at": {yesterday}})
+	if status := app.apiStatus("GET", "/api/v1/shares", expired); status != http.StatusUnauthorized {
+		t.Errorf("expired key: status %d, want 401", status)
+	}
+
+	key := createAPIKey(t, admin, url.Values{"name": {"CI"}, "scopes": {"shares:read"}})
+	if status := app.apiStatus("GET", "/api/v1/shares", key); status != http.StatusOK {
+		t.Fatalf("key: status %d, want 200", status)
+	}
+
+	var id int
+	if err := app.db.QueryRow("SELECT id FROM api_keys WHERE name = 'CI'").Scan(&id); err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	expectRedirect(t, admin.post("/admin/api-keys/"+itoa(id)+"/delete", nil), "/admin/api-keys")
+
+	if status := app.apiStatus("GET", "/api/v1/shares", key); status != http.StatusUnauthorized {
+		t.Errorf("revoked key: status %d, want 401", status)
+	}
+}
+
+func TestAPIKeyRejectsUnknownScope(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := app.newBrowser()
+	admin.loginWithToken()
+
+	resp := admin.post("/admin/api-keys", url.Values{"name": {"CI"}, "scopes": {"admin:all"}})
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestAPIKeysRequireAdminSession(t *testing.T) {
+	app := newTestApp(t, nil)
+	visitor := app.newBrowser()
+
+	expectRedirect(t, visitor.get("/admin/api-keys"), "/admin/login")
+	expectRedirect(t, visitor.post("/admin/api-keys", url.Values{"name": {"CI"}, "scopes": {"shares:read"}}), "/admin/login")
+
+	var count int
+	app.db.QueryRow("SELECT COUNT(*) FROM api_keys").Scan(&count)
+	if count != 0 {
+		t.Errorf("%d keys created without a session", count)
+	}
+}
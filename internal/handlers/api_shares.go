@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+// shareRequest is the body of share create and update requests. An empty
+// password or expires_at removes the protection or expiry.
+type shareRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Password    *string `json:"password"`
+	ExpiresAt   *string `json:"expires_at"`
+	InviteOnly  *bool   `json:"invite_only"`
+}
+
+func (h *APIHandler) ListShares(w http.ResponseWriter, r *http.Request) {
+	page, ok := parsePagination(w, r)
+	if !ok {
+		return
+	}
+
+	total, err := h.shareService.Count(r.Context())
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load shares")
+		return
+	}
+
+	shares, err := h.shareService.ListPage(r.Context(), page.Limit, page.Offset)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load shares")
+		return
+	}
+
+	data := make([]apiShare, 0, len(shares))
+	for _, s := range shares {
+		share := toAPIShare(s.Share)
+		share.FileCount = &s.FileCount
+		share.CommentCount = &s.CommentCount
+		data = append(data, share)
+	}
+
+	page.Total = total
+	writeJSONPage(w, data, page)
+}
+
+func (h *APIHandler) CreateShare(w http.ResponseWriter, r *http.Request) {
+	var req shareRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	if req.Name == nil || strings.TrimSpace(*req.Name) == "" {
+		middleware.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "Name is required")
+		return
+	}
+
+	description := ""
+	if req.Description != nil {
+		description = *req.Description
+	}
+
+	expiresAt, ok := validateShareOptions(w, req)
+	if !ok {
+		return
+	}
+
+	options := services.ShareOptions{ExpiresAt: expiresAt}
+	if req.Password != nil {
+		options.Password = *req.Password
+	}
+	if req.InviteOnly != nil {
+		options.InviteOnly = *req.InviteOnly
+	}
+
+	share, err := h.shareService.Create(r.Context(), *req.Name, description, nil, options)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to create share")
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.create", auditTargetShare, share.ID, share.Name))
+
+	writeJSON(w, http.StatusCreated, toAPIShare(*share))
+}
+
+func (h *APIHandler) GetShare(w http.ResponseWriter, r *http.Request) {
+	shareID, ok := urlID(w, r, "id", "Share")
+	if !ok {
+		return
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), shareID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "Share not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load share")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toAPIShare(*share))
+}
+
+func (h *APIHandler) UpdateShare(w http.ResponseWriter, r *http.Request) {
+	shareID, ok := urlID(w, r, "id", "Share")
+	if !ok {
+		return
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), shareID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "Share not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load share")
+		return
+	}
+
+	var req shareRequest
+	if !decodeJSON(w, r, &req) {
+		return
+	}
+
+	// Only the fields present in the body are changed
+	name, description := share.Name, share.Description
+	if req.Name != nil {
+		if strings.TrimSpace(*req.Name) == "" {
+			middleware.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "Name must not be empty")
+			return
+		}
+		name = *req.Name
+	}
+	if req.Description != nil {
+		description = *req.Description
+	}
+
+	expiresAt, ok := validateShareOptions(w, req)
+	if !ok {
+		return
+	}
+
+	if _, err = h.shareService.Update(r.Context(), shareID, name, description); err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to update share")
+		return
+	}
+
+	if share, err = h.applyShareOptions(r.Context(), shareID, req, expiresAt); err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to update share")
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.update", auditTargetShare, share.ID, share.Name))
+
+	writeJSON(w, http.StatusOK, toAPIShare(*share))
+}
+
+func (h *APIHandler) DeleteShare(w http.ResponseWriter, r *http.Request) {
+	shareID, ok := urlID(w, r, "id", "Share")
+	if !ok {
+		return
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), shareID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			middleware.WriteAPIError(w, http.StatusNotFound, "not_found", "Share not found")
+			return
+		}
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to load share")
+		return
+	}
+
+	if err := h.shareService.Delete(r.Context(), shareID); err != nil {
+		middleware.WriteAPIError(w, http.StatusInternalServerError, "internal_error", "Failed to delete share")
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "share.delete", auditTargetShare, share.ID, share.Name))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// validateShareOptions checks the password and parses the expiry of a request.
+func validateShareOptions(w http.ResponseWriter, req shareRequest) (*time.Time, bool) {
+	if req.Password != nil && *req.Password != "" {
+		if err := services.ValidatePassword(*req.Password); err != nil {
+			middleware.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "Invalid password: "+err.Error())
+			return nil, false
+		}
+	}
+
+	if req.ExpiresAt == nil || *req.ExpiresAt == "" {
+		return nil, true
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+	if err != nil {
+		middleware.WriteAPIError(w, http.StatusUnprocessableEntity, "validation_failed", "expires_at must be an RFC 3339 timestamp")
+		return nil, false
+	}
+	expiresAt = expiresAt.UTC()
+	return &expiresAt, true
+}
+
+// applyShareOptions stores the password, expiry and invite-only flag if they
+// are present in the request. Subscriptions the new password or invite-only
+// flag lock out are cancelled.
+func (h *APIHandler) applyShareOptions(ctx context.Context, shareID int, req shareRequest, expiresAt *time.Time) (*database.Share, error) {
+	if req.Password != nil {
+		if err := h.shareService.SetPassword(ctx, shareID, *req.Password); err != nil {
+			return nil, err
+		}
+	}
+	if req.ExpiresAt != nil {
+		if err := h.shareService.SetExpiry(ctx, shareID, expiresAt); err != nil {
+			return nil, err
+		}
+	}
+	if req.InviteOnly != nil {
+		if err := h.shareService.SetInviteOnly(ctx, shareID, *req.InviteOnly); err != nil {
+			return nil, err
+		}
+	}
+	if req.Password != nil || req.InviteOnly != nil {
+		if err := h.subscriptionService.CancelRevoked(ctx, shareID); err != nil {
+			return nil, err
+		}
+	}
+	return h.shareService.GetByID(ctx, shareID)
+}
@@ -0,0 +1,370 @@
+package handlers_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/config"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/server"
+)
+
+const (
+	testAdminToken    = "test-admin-token"
+	testSessionSecret = "test-session-secret-0123456789abcdef"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the request log out of the test output
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// testApp is the real router on a migrated SQLite database in a temporary
+// directory.
+type testApp struct {
+	t      *testing.T
+	db     *database.DB
+	cfg    *config.Config
+	server *server.Server
+	url    string
+}
+
+// newTestApp builds the router, configure may adjust the configuration first.
+// Rate limits are off unless configure sets them.
+func newTestApp(t *testing.T, configure func(cfg *config.Config)) *testApp {
+	t.Helper()
+
+	dir := t.TempDir()
+	db, err := database.Open(filepath.Join(dir, "feedback.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+
+	cfg := &config.Config{
+		AdminToken:     testAdminToken,
+		SessionSecret:  testSessionSecret,
+		DataDir:        dir,
+		MaxUploadSize:  10 << 20,
+		BaseURL:        "http://feedback.test",
+		SMTPFrom:       "feedback@localhost",
+		SignedURLTTL:   time.Hour,
+		TrashRetention: 30 * 24 * time.Hour,
+		QueryTimeout:   10 * time.Second,
+	}
+	if configure != nil {
+		configure(cfg)
+	}
+
+	srv, err := server.New(cfg, db, filepath.Join("..", "..", "web"))
+	if err != nil {
+		t.Fatalf("create server: %v", err)
+	}
+	t.Cleanup(func() { srv.Close() })
+
+	httpServer := httptest.NewServer(srv.Router)
+	t.Cleanup(httpServer.Close)
+
+	return &testApp{t: t, db: db, cfg: cfg, server: srv, url: httpServer.URL}
+}
+
+// do serves a request and returns the recorded response.
+func (a *testApp) do(r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	a.server.Router.ServeHTTP(w, r)
+	return w
+}
+
+// newRequest creates a request with an optional body, form bodies are
+// detected by their "=".
+func newRequest(method, target, body string) *http.Request {
+	if body == "" {
+		return httptest.NewRequest(method, target, nil)
+	}
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if strings.HasPrefix(body, "{") {
+		r.Header.Set("Content-Type", "application/json")
+	} else {
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	return r
+}
+
+// browser is a client of the test server keeping cookies like a browser. It
+// does not follow redirects so their targets can be checked.
+type browser struct {
+	t      *testing.T
+	app    *testApp
+	client *http.Client
+
+	// forwardedFor is sent as X-Forwarded-For to appear as another client
+	// behind a trusted proxy
+	forwardedFor string
+}
+
+func (a *testApp) newBrowser() *browser {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		a.t.Fatalf("create cookie jar: %v", err)
+	}
+	return &browser{
+		t:   a.t,
+		app: a,
+		client: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// testResponse is a response with its body already read.
+type testResponse struct {
+	*http.Response
+	body string
+}
+
+func (b *browser) do(r *http.Request) *testResponse {
+	b.t.Helper()
+
+	if b.forwardedFor != "" {
+		r.Header.Set("X-Forwarded-For", b.forwardedFor)
+	}
+	resp, err := b.client.Do(r)
+	if err != nil {
+		b.t.Fatalf("%s %s: %v", r.Method, r.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		b.t.Fatalf("%s %s: read body: %v", r.Method, r.URL.Path, err)
+	}
+	return &testResponse{Response: resp, body: string(body)}
+}
+
+func (b *browser) get(path string) *testResponse {
+	b.t.Helper()
+
+	r, err := http.NewRequest(http.MethodGet, b.app.url+path, nil)
+	if err != nil {
+		b.t.Fatalf("GET %s: %v", path, err)
+	}
+	return b.do(r)
+}
+
+// post submits a form like the pages do, with the CSRF token of the browser's
+// cookie, which a GET request is made to obtain if there is none yet.
+func (b *browser) post(path string, form url.Values) *testResponse {
+	b.t.Helper()
+
+	if form == nil {
+		form = url.Values{}
+	}
+	if form.Get(middleware.CSRFFieldName) == "" {
+		form.Set(middleware.CSRFFieldName, b.csrfToken())
+	}
+	return b.postRaw(path, form)
+}
+
+// postRaw submits a form as is.
+func (b *browser) postRaw(path string, form url.Values) *testResponse {
+	b.t.Helper()
+
+	r, err := http.NewRequest(http.MethodPost, b.app.url+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		b.t.Fatalf("POST %s: %v", path, err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return b.do(r)
+}
+
+// cookie returns the value of a cookie of the browser.
+func (b *browser) cookie(name string) string {
+	u, _ := url.Parse(b.app.url)
+	for _, cookie := range b.client.Jar.Cookies(u) {
+		if cookie.Name == name {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// setCookie replaces a cookie of the browser.
+func (b *browser) setCookie(name, value string) {
+	u, _ := url.Parse(b.app.url)
+	b.client.Jar.SetCookies(u, []*http.Cookie{{Name: name, Value: value, Path: "/"}})
+}
+
+func (b *browser) csrfToken() string {
+	b.t.Helper()
+
+	if token := b.cookie(middleware.CSRFCookieName); token != "" {
+		return token
+	}
+	b.get("/admin/login")
+	token := b.cookie(middleware.CSRFCookieName)
+	if token == "" {
+		b.t.Fatal("no CSRF cookie set")
+	}
+	return token
+}
+
+// loginWithToken signs the browser in with the admin token.
+func (b *browser) loginWithToken() {
+	b.t.Helper()
+
+	resp := b.post("/admin/login", url.Values{"password": {testAdminToken}})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/admin" {
+		b.t.Fatalf("login: status %d, location %q", resp.StatusCode, resp.Header.Get("Location"))
+	}
+}
+
+// expectStatus fails the test if the response has another status.
+func expectStatus(t *testing.T, resp *testResponse, status int) {
+	t.Helper()
+
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d\n%s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, resp.body)
+	}
+}
+
+// expectRedirect fails the test if the response does not redirect to location.
+func expectRedirect(t *testing.T, resp *testResponse, location string) {
+	t.Helper()
+
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != location {
+		t.Fatalf("%s %s: status %d, location %q, want redirect to %q", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, resp.Header.Get("Location"), location)
+	}
+}
+
+func itoa(i int) string {
+	return strconv.Itoa(i)
+}
+
+// api sends a JSON API request with the admin token and decodes the data of
+// the response into v unless it is nil.
+func (a *testApp) api(method, path string, body interface{}, v interface{}) {
+	a.t.Helper()
+
+	var payload string
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatalf("encode request: %v", err)
+		}
+		payload = string(data)
+	}
+
+	r := newRequest(method, path, payload)
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := a.do(r)
+	if w.Code >= 300 {
+		a.t.Fatalf("%s %s: status %d: %s", method, path, w.Code, w.Body.String())
+	}
+	if v != nil {
+		decodeData(a.t, w.Body.Bytes(), v)
+	}
+}
+
+// testShare is a share created through the API.
+type testShare struct {
+	ID   int    `json:"id"`
+	Hash string `json:"hash"`
+}
+
+// testFile is a file uploaded through the API.
+type testFile struct {
+	ID         int    `json:"id"`
+	Hash       string `json:"hash"`
+	Filename   string `json:"filename"`
+	ScanStatus string `json:"scan_status"`
+}
+
+// createShare creates a share, options are passed as fields of the request.
+func (a *testApp) createShare(options map[string]interface{}) *testShare {
+	a.t.Helper()
+
+	body := map[string]interface{}{"name": "Share"}
+	for name, value := range options {
+		body[name] = value
+	}
+	share := &testShare{}
+	a.api("POST", "/api/v1/shares", body, share)
+	return share
+}
+
+// upload adds a file to the share.
+func (a *testApp) upload(share *testShare, filename string, content []byte) *testFile {
+	a.t.Helper()
+	return a.uploadAs(share, filename, "application/octet-stream", content)
+}
+
+// uploadAs adds a file with the given content type to the share.
+func (a *testApp) uploadAs(share *testShare, filename, contentType string, content []byte) *testFile {
+	a.t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "files", "filename": filename}))
+	header.Set("Content-Type", contentType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		a.t.Fatalf("create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	r := httptest.NewRequest("POST", "/api/v1/shares/"+itoa(share.ID)+"/files", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := a.do(r)
+	if w.Code != http.StatusCreated {
+		a.t.Fatalf("upload %s: status %d: %s", filename, w.Code, w.Body.String())
+	}
+
+	var files []testFile
+	decodeData(a.t, w.Body.Bytes(), &files)
+	if len(files) != 1 {
+		a.t.Fatalf("upload returned %d files", len(files))
+	}
+	return &files[0]
+}
+
+// decodeData decodes the data of a JSON API response into v.
+func decodeData(t *testing.T, body []byte, v interface{}) {
+	t.Helper()
+
+	var response struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if err := json.Unmarshal(response.Data, v); err != nil {
+		t.Fatalf("decode response data: %v", err)
+	}
+}
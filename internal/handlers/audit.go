@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+// Targets of audit events.
+const (
+	auditTargetShare    = "share"
+	auditTargetLink     = "share_link"
+	auditTargetReviewer = "reviewer"
+	auditTargetFile     = "file"
+	auditTargetComment  = "comment"
+	auditTargetAdmin    = "admin"
+	auditTargetAPIKey   = "api_key"
+	auditTargetSession  = "session"
+)
+
+// auditEvent describes an action of the signed-in admin or the API client of
+// the request. API requests with the admin token act as the token admin.
+func auditEvent(r *http.Request, action, targetType string, targetID int, target string) database.AuditEvent {
+	event := database.AuditEvent{
+		ActorType:  database.ActorAdmin,
+		ActorName:  services.TokenAdmin.Username,
+		Action:     action,
+		TargetType: targetType,
+		Target:     target,
+		IP:         middleware.ClientIP(r),
+	}
+	if targetID != 0 {
+		event.TargetID = &targetID
+	}
+
+	if admin := middleware.GetAdmin(r); admin != nil {
+		event.ActorName = admin.Username
+		if admin.ID != 0 {
+			event.ActorID = &admin.ID
+		}
+	} else if apiKey := middleware.GetAPIKey(r); apiKey != nil {
+		event.ActorType = database.ActorAPIKey
+		event.ActorID = &apiKey.ID
+		event.ActorName = apiKey.Name
+	}
+
+	return event
+}
+
+// adminAuditEvent describes an action of an admin who is not signed in yet,
+// such as signing in.
+func adminAuditEvent(r *http.Request, admin *database.Admin, action, targetType string, targetID int, target string) database.AuditEvent {
+	event := auditEvent(r, action, targetType, targetID, target)
+	event.ActorName = admin.Username
+	if admin.ID != 0 {
+		event.ActorID = &admin.ID
+	}
+	return event
+}
+
+// failedLoginEvent records a rejected password or admin token under the
+// username that was tried.
+func failedLoginEvent(r *http.Request, username string) database.AuditEvent {
+	event := auditEvent(r, "admin.login_failed", "", 0, "")
+	event.ActorType = database.ActorVisitor
+	event.ActorName = username
+	return event
+}
+
+// visitorAuditEvent describes an action of a share visitor, who is either a
+// signed-in reviewer or known by the name they entered.
+func visitorAuditEvent(r *http.Request, access *database.ShareAccess, action, targetType string, targetID int, target string) database.AuditEvent {
+	event := auditEvent(r, action, targetType, targetID, target)
+	event.ActorType = database.ActorVisitor
+	event.ActorID = nil
+	event.ActorName = middleware.VisitorName(r, access)
+
+	if access.Reviewer != nil {
+		event.ActorType = database.ActorReviewer
+		event.ActorID = &access.Reviewer.ID
+	}
+
+	return event
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"html/template"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+type AuthHandler struct {
+	templates           *template.Template
+	store               *sessions.CookieStore
+	adminService        *services.AdminService
+	adminSessionService *services.AdminSessionService
+	oidcService         *services.OIDCService
+	auditService        *services.AuditService
+	adminToken          string
+}
+
+// NewAuthHandler creates the admin login handler. oidcService is nil when
+// single sign-on is not configured.
+func NewAuthHandler(templates *template.Template, store *sessions.CookieStore, adminService *services.AdminService, adminSessionService *services.AdminSessionService, oidcService *services.OIDCService, auditService *services.AuditService, adminToken string) *AuthHandler {
+	return &AuthHandler{
+		templates:           templates,
+		store:               store,
+		adminService:        adminService,
+		adminSessionService: adminSessionService,
+		oidcService:         oidcService,
+		auditService:        auditService,
+		adminToken:          adminToken,
+	}
+}
+
+func (h *AuthHandler) LoginForm(w http.ResponseWriter, r *http.Request) {
+	// Skip the form when already signed in
+	if adminSession, _ := middleware.LoadAdmin(r, h.store, h.adminSessionService, h.adminService); adminSession != nil {
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		return
+	}
+
+	h.renderLogin(w, r, "", "", http.StatusOK)
+}
+
+func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+
+	// Without a username the password is checked against the admin token
+	var adminID *int
+	if username == "" {
+		if password == "" || subtle.ConstantTimeCompare([]byte(password), []byte(h.adminToken)) != 1 {
+			h.auditService.Record(r.Context(), failedLoginEvent(r, services.TokenAdmin.Username))
+			h.renderLogin(w, r, "Invalid token", username, http.StatusUnauthorized)
+			return
+		}
+	} else {
+		admin, err := h.adminService.Authenticate(r.Context(), username, password)
+		if err == services.ErrInvalidCredentials {
+			h.auditService.Record(r.Context(), failedLoginEvent(r, username))
+			h.renderLogin(w, r, "Invalid username or password", username, http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+
+		// Ask for the second factor before signing in
+		if admin.TOTPEnabledAt != nil {
+			h.startSecondFactor(w, r, admin.ID)
+			return
+		}
+
+		adminID = &admin.ID
+	}
+
+	h.startSession(w, r, adminID)
+}
+
+// startSession signs the admin in and redirects to the dashboard.
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, adminID *int) {
+	adminSession, sessionToken, err := h.adminSessionService.Create(r.Context(), adminID, middleware.ClientIP(r), r.UserAgent())
+	if err != nil {
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+
+	admin := &services.TokenAdmin
+	if adminID != nil {
+		if admin, err = h.adminService.GetByID(r.Context(), *adminID); err != nil {
+			http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+			return
+		}
+	}
+	h.auditService.Record(r.Context(), adminAuditEvent(r, admin, "admin.login", auditTargetSession, adminSession.ID, ""))
+
+	session, _ := h.store.Get(r, middleware.AdminSessionName)
+	options := *h.store.Options
+	options.MaxAge = int(services.AdminSessionTTL.Seconds())
+	session.Options = &options
+	session.Values["token"] = sessionToken
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	if adminSession := middleware.GetAdminSession(r); adminSession != nil {
+		if err := h.adminSessionService.Revoke(r.Context(), adminSession.AdminID, adminSession.ID); err != nil {
+			http.Error(w, "Failed to end session", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.logout", "", 0, ""))
+
+	session, _ := h.store.Get(r, middleware.AdminSessionName)
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Failed to clear session", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) Sessions(w http.ResponseWriter, r *http.Request) {
+	adminSessions, err := h.adminSessionService.List(r.Context(), middleware.GetAdminSession(r).AdminID)
+	if err != nil {
+		http.Error(w, "Failed to load sessions", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Sessions":  adminSessions,
+		"CurrentID": middleware.GetAdminSession(r).ID,
+	}
+
+	render(w, r, h.templates, "sessions", data)
+}
+
+func (h *AuthHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	current := middleware.GetAdminSession(r)
+	if err := h.adminSessionService.Revoke(r.Context(), current.AdminID, sessionID); err != nil {
+		http.Error(w, "Failed to revoke session", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "session.revoke", auditTargetSession, sessionID, ""))
+
+	if sessionID == current.ID {
+		http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	current := middleware.GetAdminSession(r)
+	if err := h.adminSessionService.RevokeOthers(r.Context(), current.AdminID, current.ID); err != nil {
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "session.revoke_others", "", 0, ""))
+
+	http.Redirect(w, r, "/admin/sessions", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) renderLogin(w http.ResponseWriter, r *http.Request, errorMessage, username string, status int) {
+	data := map[string]interface{}{
+		"Error":       errorMessage,
+		"Username":    username,
+		"OIDCEnabled": h.oidcService != nil,
+	}
+
+	w.WriteHeader(status)
+	render(w, r, h.templates, "login", data)
+}
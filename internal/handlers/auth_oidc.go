@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/romanzipp/feedback/internal/services"
+	"golang.org/x/oauth2"
+)
+
+// oidcSessionName is the short-lived cookie holding state, nonce and PKCE
+// verifier between the redirect to the provider and the callback.
+const oidcSessionName = "oidc-login"
+
+func (h *AuthHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	state, err := services.GenerateHash(32)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := services.GenerateHash(32)
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	session, _ := h.store.Get(r, oidcSessionName)
+	options := *h.store.Options
+	options.MaxAge = 10 * 60
+	session.Options = &options
+	session.Values["state"] = state
+	session.Values["nonce"] = nonce
+	session.Values["verifier"] = verifier
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, h.oidcService.AuthCodeURL(state, nonce, verifier), http.StatusFound)
+}
+
+func (h *AuthHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.store.Get(r, oidcSessionName)
+	state, _ := session.Values["state"].(string)
+	nonce, _ := session.Values["nonce"].(string)
+	verifier, _ := session.Values["verifier"].(string)
+
+	// The login attempt is single use
+	session.Options.MaxAge = -1
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Failed to clear session", http.StatusInternalServerError)
+		return
+	}
+
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		if desc := r.URL.Query().Get("error_description"); desc != "" {
+			msg = desc
+		}
+		h.renderLogin(w, r, "Single sign-on failed: "+msg, "", http.StatusUnauthorized)
+		return
+	}
+
+	if state == "" || r.URL.Query().Get("state") != state {
+		h.renderLogin(w, r, "Single sign-on expired, please try again", "", http.StatusBadRequest)
+		return
+	}
+
+	identity, err := h.oidcService.Exchange(r.Context(), r.URL.Query().Get("code"), nonce, verifier)
+	if err == services.ErrOIDCNoRole {
+		h.renderLogin(w, r, "Your account has no access to the admin panel", "", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("OIDC login failed: %v", err)
+		h.renderLogin(w, r, "Single sign-on failed", "", http.StatusUnauthorized)
+		return
+	}
+
+	admin, err := h.adminService.LoginOIDC(r.Context(), identity)
+	if err != nil {
+		http.Error(w, "Failed to sign in", http.StatusInternalServerError)
+		return
+	}
+
+	h.startSession(w, r, &admin.ID)
+}
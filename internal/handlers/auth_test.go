@@ -0,0 +1,133 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+func TestAdminRequiresSession(t *testing.T) {
+	app := newTestApp(t, nil)
+	visitor := app.newBrowser()
+
+	for _, path := range []string{"/admin", "/admin/sessions", "/admin/shares/new", "/admin/trash"} {
+		expectRedirect(t, visitor.get(path), "/admin/login")
+	}
+
+	// The token is no longer accepted in the path
+	if resp := visitor.get("/admin/" + testAdminToken + "/"); resp.StatusCode == http.StatusOK {
+		t.Errorf("token in path: status %d", resp.StatusCode)
+	}
+}
+
+func TestAdminLoginWithToken(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := app.newBrowser()
+
+	resp := admin.post("/admin/login", url.Values{"password": {"wrong"}})
+	expectStatus(t, resp, http.StatusUnauthorized)
+	if admin.cookie(middleware.AdminSessionName) != "" {
+		t.Fatal("session cookie set for a wrong token")
+	}
+
+	resp = admin.post("/admin/login", url.Values{"password": {testAdminToken}})
+	expectRedirect(t, resp, "/admin")
+
+	var sessionCookie *http.Cookie
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == middleware.AdminSessionName {
+			sessionCookie = cookie
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatal("no session cookie set")
+	}
+	if !sessionCookie.HttpOnly {
+		t.Error("session cookie readable by scripts")
+	}
+	if strings.Contains(sessionCookie.Value, testAdminToken) {
+		t.Error("session cookie contains the admin token")
+	}
+
+	expectStatus(t, admin.get("/admin"), http.StatusOK)
+	expectRedirect(t, admin.get("/admin/login"), "/admin")
+}
+
+func TestAdminLoginWithPassword(t *testing.T) {
+	app := newTestApp(t, nil)
+	if _, err := services.NewAdminService(app.db).Create(context.Background(), "editor", "correct horse", database.RoleEditor); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+
+	admin := app.newBrowser()
+	resp := admin.post("/admin/login", url.Values{"username": {"editor"}, "password": {"wrong password"}})
+	expectStatus(t, resp, http.StatusUnauthorized)
+
+	// The admin token does not work as password of an account
+	resp = admin.post("/admin/login", url.Values{"username": {"editor"}, "password": {testAdminToken}})
+	expectStatus(t, resp, http.StatusUnauthorized)
+
+	resp = admin.post("/admin/login", url.Values{"username": {"editor"}, "password": {"correct horse"}})
+	expectRedirect(t, resp, "/admin")
+	expectStatus(t, admin.get("/admin"), http.StatusOK)
+
+	// Editors are not owners
+	expectStatus(t, admin.get("/admin/admins"), http.StatusForbidden)
+}
+
+func TestAdminLogoutRevokesSession(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := app.newBrowser()
+	admin.loginWithToken()
+
+	sessionCookie := admin.cookie(middleware.AdminSessionName)
+	expectRedirect(t, admin.post("/admin/logout", nil), "/admin/login")
+	expectRedirect(t, admin.get("/admin"), "/admin/login")
+
+	// A copy of the cookie taken before the logout is no longer valid
+	replay := app.newBrowser()
+	replay.setCookie(middleware.AdminSessionName, sessionCookie)
+	expectRedirect(t, replay.get("/admin"), "/admin/login")
+}
+
+func TestAdminRevokeOtherSessions(t *testing.T) {
+	app := newTestApp(t, nil)
+	first := app.newBrowser()
+	first.loginWithToken()
+	second := app.newBrowser()
+	second.loginWithToken()
+
+	expectStatus(t, second.get("/admin"), http.StatusOK)
+	expectRedirect(t, first.post("/admin/sessions/revoke-others", nil), "/admin/sessions")
+
+	expectRedirect(t, second.get("/admin"), "/admin/login")
+	expectStatus(t, first.get("/admin"), http.StatusOK)
+}
+
+func TestAdminCannotRevokeSessionsOfOthers(t *testing.T) {
+	app := newTestApp(t, nil)
+	adminService := services.NewAdminService(app.db)
+	if _, err := adminService.Create(context.Background(), "editor", "correct horse", database.RoleEditor); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+
+	owner := app.newBrowser()
+	owner.loginWithToken()
+
+	editor := app.newBrowser()
+	expectRedirect(t, editor.post("/admin/login", url.Values{"username": {"editor"}, "password": {"correct horse"}}), "/admin")
+
+	var ownerSessionID int
+	if err := app.db.QueryRow("SELECT id FROM admin_sessions WHERE admin_id IS NULL").Scan(&ownerSessionID); err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+
+	editor.post("/admin/sessions/"+itoa(ownerSessionID)+"/revoke", nil)
+	expectStatus(t, owner.get("/admin"), http.StatusOK)
+}
@@ -0,0 +1,293 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// secondFactorSessionName is the cookie holding the token of the pending
+	// login until the authentication code is entered. The admin and the
+	// attempts are stored on the server.
+	secondFactorSessionName = "admin-2fa"
+
+	totpIssuer = "Feedback"
+)
+
+func (h *AuthHandler) startSecondFactor(w http.ResponseWriter, r *http.Request, adminID int) {
+	token, err := h.adminSessionService.StartPendingLogin(r.Context(), adminID)
+	if err != nil {
+		http.Error(w, "Failed to start sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	session, _ := h.store.Get(r, secondFactorSessionName)
+	options := *h.store.Options
+	options.MaxAge = int(services.PendingLoginTTL.Seconds())
+	session.Options = &options
+	session.Values = map[interface{}]interface{}{"token": token}
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	h.renderSecondFactor(w, r, "", http.StatusOK)
+}
+
+func (h *AuthHandler) SecondFactor(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	session, _ := h.store.Get(r, secondFactorSessionName)
+	token, _ := session.Values["token"].(string)
+	if token == "" {
+		h.renderLogin(w, r, "Your sign-in expired, please try again", "", http.StatusUnauthorized)
+		return
+	}
+
+	// The attempt is counted before the code is checked
+	adminID, remaining, err := h.adminSessionService.ClaimPendingLoginAttempt(r.Context(), token)
+	if err == sql.ErrNoRows {
+		h.clearSecondFactor(w, r)
+		h.renderLogin(w, r, "Your sign-in expired, please try again", "", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	err = h.adminService.VerifyTOTP(r.Context(), adminID, r.FormValue("code"))
+	if err == services.ErrInvalidTOTPCode {
+		if admin, err := h.adminService.GetByID(r.Context(), adminID); err == nil {
+			h.auditService.Record(r.Context(), adminAuditEvent(r, admin, "admin.2fa_failed", auditTargetAdmin, admin.ID, admin.Username))
+		}
+
+		// Start over with the password after too many wrong codes
+		if remaining == 0 {
+			_ = h.adminSessionService.EndPendingLogin(r.Context(), token)
+			h.clearSecondFactor(w, r)
+			h.renderLogin(w, r, "Too many invalid codes, please sign in again", "", http.StatusUnauthorized)
+			return
+		}
+
+		h.renderSecondFactor(w, r, "Invalid authentication code", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+
+	// Only one request can complete the login
+	err = h.adminSessionService.EndPendingLogin(r.Context(), token)
+	if err == sql.ErrNoRows {
+		h.clearSecondFactor(w, r)
+		h.renderLogin(w, r, "Your sign-in expired, please try again", "", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to complete sign-in", http.StatusInternalServerError)
+		return
+	}
+
+	h.clearSecondFactor(w, r)
+	h.startSession(w, r, &adminID)
+}
+
+// clearSecondFactor removes the cookie of the pending login.
+func (h *AuthHandler) clearSecondFactor(w http.ResponseWriter, r *http.Request) {
+	session, _ := h.store.Get(r, secondFactorSessionName)
+	session.Options.MaxAge = -1
+	_ = session.Save(r, w)
+}
+
+func (h *AuthHandler) Account(w http.ResponseWriter, r *http.Request) {
+	h.renderAccount(w, r, nil, "", http.StatusOK)
+}
+
+func (h *AuthHandler) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.passwordAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if _, err := h.adminService.BeginTOTP(r.Context(), admin.ID); err != nil {
+		h.renderAccount(w, r, nil, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/account", http.StatusSeeOther)
+}
+
+// TOTPQRCode renders the provisioning URI of a pending enrolment as PNG.
+func (h *AuthHandler) TOTPQRCode(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.passwordAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := h.adminService.PendingTOTPSecret(r.Context(), admin.ID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	png, err := qrcode.Encode(services.TOTPURI(totpIssuer, admin.Username, secret), qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	http.ServeContent(w, r, "totp.png", time.Time{}, bytes.NewReader(png))
+}
+
+func (h *AuthHandler) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.passwordAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	codes, err := h.adminService.EnableTOTP(r.Context(), admin.ID, r.FormValue("code"))
+	if err == services.ErrInvalidTOTPCode || err == sql.ErrNoRows {
+		h.renderAccount(w, r, nil, "Invalid authentication code", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.2fa_enable", auditTargetAdmin, admin.ID, admin.Username))
+
+	h.renderAccount(w, r, codes, "", http.StatusOK)
+}
+
+func (h *AuthHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.verifiedAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.adminService.DisableTOTP(r.Context(), admin.ID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.2fa_disable", auditTargetAdmin, admin.ID, admin.Username))
+
+	http.Redirect(w, r, "/admin/account", http.StatusSeeOther)
+}
+
+func (h *AuthHandler) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	admin, ok := h.verifiedAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	codes, err := h.adminService.RegenerateRecoveryCodes(r.Context(), admin.ID)
+	if err != nil {
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), auditEvent(r, "admin.recovery_codes", auditTargetAdmin, admin.ID, admin.Username))
+
+	h.renderAccount(w, r, codes, "", http.StatusOK)
+}
+
+// passwordAdmin returns the signed-in admin if they sign in with a password.
+// Token and single sign-on logins have no second factor here.
+func (h *AuthHandler) passwordAdmin(w http.ResponseWriter, r *http.Request) (*database.Admin, bool) {
+	admin := middleware.GetAdmin(r)
+	if !admin.HasPassword() {
+		http.Error(w, "Two-factor authentication is not available for this account", http.StatusBadRequest)
+		return nil, false
+	}
+	return admin, true
+}
+
+// verifiedAdmin additionally requires a current authentication code, so a
+// hijacked session cannot turn off or take over the second factor.
+func (h *AuthHandler) verifiedAdmin(w http.ResponseWriter, r *http.Request) (*database.Admin, bool) {
+	admin, ok := h.passwordAdmin(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return nil, false
+	}
+
+	err := h.adminService.VerifyTOTP(r.Context(), admin.ID, r.FormValue("code"))
+	if err == services.ErrInvalidTOTPCode || err == sql.ErrNoRows {
+		h.renderAccount(w, r, nil, "Invalid authentication code", http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return admin, true
+}
+
+func (h *AuthHandler) renderAccount(w http.ResponseWriter, r *http.Request, recoveryCodes []string, errorMessage string, status int) {
+	// Reload to reflect changes made by the current request
+	admin := middleware.GetAdmin(r)
+	if admin.HasPassword() {
+		var err error
+		if admin, err = h.adminService.GetByID(r.Context(), admin.ID); err != nil {
+			http.Error(w, "Failed to load account", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	data := map[string]interface{}{
+		"Account":       admin,
+		"RecoveryCodes": recoveryCodes,
+		"Error":         errorMessage,
+	}
+
+	if admin.HasPassword() {
+		if admin.TOTPEnabledAt != nil {
+			remaining, err := h.adminService.CountRecoveryCodes(r.Context(), admin.ID)
+			if err != nil {
+				http.Error(w, "Failed to load recovery codes", http.StatusInternalServerError)
+				return
+			}
+			data["RemainingCodes"] = remaining
+		} else if secret, err := h.adminService.PendingTOTPSecret(r.Context(), admin.ID); err == nil {
+			data["PendingSecret"] = secret
+		}
+	}
+
+	w.WriteHeader(status)
+	render(w, r, h.templates, "account", data)
+}
+
+func (h *AuthHandler) renderSecondFactor(w http.ResponseWriter, r *http.Request, errorMessage string, status int) {
+	data := map[string]interface{}{
+		"Error": errorMessage,
+	}
+
+	w.WriteHeader(status)
+	render(w, r, h.templates, "login_2fa", data)
+}
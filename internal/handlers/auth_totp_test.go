@@ -0,0 +1,214 @@
+package handlers_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+const secondFactorCookie = "admin-2fa"
+
+// totpAdmin is an editor with two-factor authentication enabled.
+type totpAdmin struct {
+	id            int
+	secret        string
+	recoveryCodes []string
+}
+
+func createTOTPAdmin(t *testing.T, app *testApp) *totpAdmin {
+	t.Helper()
+
+	adminService := services.NewAdminService(app.db)
+	admin, err := adminService.Create(context.Background(), "editor", "correct horse", database.RoleEditor)
+	if err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	secret, err := adminService.BeginTOTP(context.Background(), admin.ID)
+	if err != nil {
+		t.Fatalf("begin TOTP: %v", err)
+	}
+	recoveryCodes, err := adminService.EnableTOTP(context.Background(), admin.ID, totpCode(secret, time.Now(), 0))
+	if err != nil {
+		t.Fatalf("enable TOTP: %v", err)
+	}
+	return &totpAdmin{id: admin.ID, secret: secret, recoveryCodes: recoveryCodes}
+}
+
+// nextCode returns a code the server accepts but has not seen yet, the code
+// of the current step was used to enable two-factor authentication.
+func (a *totpAdmin) nextCode() string {
+	return totpCode(a.secret, time.Now(), 1)
+}
+
+// wrongCode returns a code that is not valid in any accepted step.
+func (a *totpAdmin) wrongCode() string {
+	valid := map[string]bool{}
+	for offset := int64(-2); offset <= 2; offset++ {
+		valid[totpCode(a.secret, time.Now(), offset)] = true
+	}
+	for i := 0; ; i++ {
+		if code := fmt.Sprintf("%06d", i); !valid[code] {
+			return code
+		}
+	}
+}
+
+// totpCode computes the RFC 6238 code of the step offset from now.
+func totpCode(secret string, now time.Time, offset int64) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		panic(err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(now.Unix()/30+offset))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	index := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[index:index+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// passwordStep signs in with the password and expects the code prompt.
+func passwordStep(t *testing.T, b *browser) {
+	t.Helper()
+
+	resp := b.post("/admin/login", url.Values{"username": {"editor"}, "password": {"correct horse"}})
+	expectStatus(t, resp, http.StatusOK)
+	if !strings.Contains(resp.body, "/admin/login/2fa") {
+		t.Fatal("no code prompt after the password")
+	}
+	if b.cookie(middleware.AdminSessionName) != "" {
+		t.Fatal("session started before the second factor")
+	}
+}
+
+func (a *testApp) countPendingLogins() int {
+	var count int
+	if err := a.db.QueryRow("SELECT COUNT(*) FROM pending_logins").Scan(&count); err != nil {
+		a.t.Fatalf("count pending logins: %v", err)
+	}
+	return count
+}
+
+func TestSecondFactorLogin(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := createTOTPAdmin(t, app)
+
+	b := app.newBrowser()
+	passwordStep(t, b)
+	expectRedirect(t, b.get("/admin"), "/admin/login")
+
+	expectStatus(t, b.post("/admin/login/2fa", url.Values{"code": {admin.wrongCode()}}), http.StatusUnauthorized)
+	expectRedirect(t, b.post("/admin/login/2fa", url.Values{"code": {admin.nextCode()}}), "/admin")
+	expectStatus(t, b.get("/admin"), http.StatusOK)
+
+	if count := app.countPendingLogins(); count != 0 {
+		t.Errorf("%d pending logins left after signing in", count)
+	}
+}
+
+func TestSecondFactorCookieReplayKeepsAttempts(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := createTOTPAdmin(t, app)
+
+	b := app.newBrowser()
+	passwordStep(t, b)
+	pending := b.cookie(secondFactorCookie)
+
+	// Restoring the cookie before every guess must not reset the attempts
+	for i := 0; i < services.PendingLoginMaxAttempts; i++ {
+		b.setCookie(secondFactorCookie, pending)
+		expectStatus(t, b.post("/admin/login/2fa", url.Values{"code": {admin.wrongCode()}}), http.StatusUnauthorized)
+	}
+	if count := app.countPendingLogins(); count != 0 {
+		t.Errorf("%d pending logins left after the attempts ran out", count)
+	}
+
+	b.setCookie(secondFactorCookie, pending)
+	resp := b.post("/admin/login/2fa", url.Values{"code": {admin.nextCode()}})
+	expectStatus(t, resp, http.StatusUnauthorized)
+	expectRedirect(t, b.get("/admin"), "/admin/login")
+}
+
+func TestSecondFactorCompletesOnce(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := createTOTPAdmin(t, app)
+
+	b := app.newBrowser()
+	passwordStep(t, b)
+	pending := b.cookie(secondFactorCookie)
+	expectRedirect(t, b.post("/admin/login/2fa", url.Values{"code": {admin.nextCode()}}), "/admin")
+
+	// A copy of the cookie cannot complete another login, even with a
+	// recovery code
+	other := app.newBrowser()
+	other.setCookie(secondFactorCookie, pending)
+	expectStatus(t, other.post("/admin/login/2fa", url.Values{"code": {admin.recoveryCodes[0]}}), http.StatusUnauthorized)
+	expectRedirect(t, other.get("/admin"), "/admin/login")
+}
+
+func TestSecondFactorExpires(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := createTOTPAdmin(t, app)
+
+	b := app.newBrowser()
+	passwordStep(t, b)
+	if _, err := app.db.Exec("UPDATE pending_logins SET expires_at = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatalf("expire pending login: %v", err)
+	}
+
+	expectStatus(t, b.post("/admin/login/2fa", url.Values{"code": {admin.nextCode()}}), http.StatusUnauthorized)
+	expectRedirect(t, b.get("/admin"), "/admin/login")
+}
+
+func TestSecondFactorWithoutPasswordStep(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := createTOTPAdmin(t, app)
+
+	b := app.newBrowser()
+	expectStatus(t, b.post("/admin/login/2fa", url.Values{"code": {admin.nextCode()}}), http.StatusUnauthorized)
+	expectRedirect(t, b.get("/admin"), "/admin/login")
+}
+
+func TestSecondFactorRecoveryCode(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := createTOTPAdmin(t, app)
+
+	b := app.newBrowser()
+	passwordStep(t, b)
+	expectRedirect(t, b.post("/admin/login/2fa", url.Values{"code": {admin.recoveryCodes[0]}}), "/admin")
+
+	// Recovery codes are single use
+	other := app.newBrowser()
+	passwordStep(t, other)
+	expectStatus(t, other.post("/admin/login/2fa", url.Values{"code": {admin.recoveryCodes[0]}}), http.StatusUnauthorized)
+	expectRedirect(t, other.post("/admin/login/2fa", url.Values{"code": {admin.recoveryCodes[1]}}), "/admin")
+}
+
+func TestOwnerResetsSecondFactor(t *testing.T) {
+	app := newTestApp(t, nil)
+	admin := createTOTPAdmin(t, app)
+
+	owner := app.newBrowser()
+	owner.loginWithToken()
+	expectRedirect(t, owner.post("/admin/admins/"+itoa(admin.id)+"/2fa/reset", nil), "/admin/admins")
+
+	b := app.newBrowser()
+	resp := b.post("/admin/login", url.Values{"username": {"editor"}, "password": {"correct horse"}})
+	expectRedirect(t, resp, "/admin")
+}
@@ -3,38 +3,99 @@ package handlers
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/romanzipp/feedback/internal/database"
 	"github.com/romanzipp/feedback/internal/middleware"
 	"github.com/romanzipp/feedback/internal/services"
-	"golang.org/x/time/rate"
 )
 
 type CommentHandler struct {
-	fileService *services.FileService
-	limiter     *rate.Limiter
+	shareService        *services.ShareService
+	fileService         *services.FileService
+	subscriptionService *services.SubscriptionService
+	reviewerService     *services.ReviewerService
+	auditService        *services.AuditService
+	store               *sessions.CookieStore
 }
 
-func NewCommentHandler(fileService *services.FileService) *CommentHandler {
+func NewCommentHandler(shareService *services.ShareService, fileService *services.FileService, subscriptionService *services.SubscriptionService, reviewerService *services.ReviewerService, auditService *services.AuditService, store *sessions.CookieStore) *CommentHandler {
 	return &CommentHandler{
-		fileService: fileService,
-		limiter:     rate.NewLimiter(1, 5), // 1 request per second, burst of 5
+		shareService:        shareService,
+		fileService:         fileService,
+		subscriptionService: subscriptionService,
+		reviewerService:     reviewerService,
+		auditService:        auditService,
+		store:               store,
 	}
 }
 
-func (h *CommentHandler) Create(w http.ResponseWriter, r *http.Request) {
-	// Rate limiting
-	if !h.limiter.Allow() {
-		http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+// List returns the comments of a file written before the comment given by
+// the before parameter, so the share page can load earlier comments.
+func (h *CommentHandler) List(w http.ResponseWriter, r *http.Request) {
+	file, err := h.fileService.GetByHash(r.Context(), chi.URLParam(r, "hash"))
+	if err != nil {
+		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 
-	username := middleware.GetUsername(r)
-	if username == "" {
-		http.Error(w, "Username not set", http.StatusUnauthorized)
+	access, err := h.shareService.Resolve(r.Context(), r.URL.Query().Get("access"))
+	if err != nil || access.Share.ID != file.ShareID {
+		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
+	middleware.ApplyReviewer(r, h.store, h.reviewerService, access)
+	share := access.Share
 
+	if share.IsExpired() {
+		http.Error(w, "Share has expired", http.StatusGone)
+		return
+	}
+
+	if !access.CanView() || !middleware.ShareUnlocked(r, h.store, share) {
+		http.Error(w, "Share is locked", http.StatusForbidden)
+		return
+	}
+
+	before, err := strconv.Atoi(r.URL.Query().Get("before"))
+	if err != nil {
+		http.Error(w, "Invalid before parameter", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultPageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxPageLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// One more than requested tells whether even earlier comments exist
+	comments, err := h.fileService.CommentsBefore(r.Context(), file.ID, before, limit+1)
+	if err != nil {
+		http.Error(w, "Failed to load comments", http.StatusInternalServerError)
+		return
+	}
+	hasMore := len(comments) > limit
+	if hasMore {
+		comments = comments[1:]
+	}
+	if comments == nil {
+		comments = []database.Comment{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"comments": comments,
+		"has_more": hasMore,
+	})
+}
+
+func (h *CommentHandler) Create(w http.ResponseWriter, r *http.Request) {
 	fileHash := chi.URLParam(r, "hash")
 	if fileHash == "" {
 		http.Error(w, "Invalid file hash", http.StatusBadRequest)
@@ -42,7 +103,7 @@ func (h *CommentHandler) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get file by hash to obtain ID
-	file, err := h.fileService.GetByHash(fileHash)
+	file, err := h.fileService.GetByHash(r.Context(), fileHash)
 	if err != nil {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
@@ -53,18 +114,57 @@ func (h *CommentHandler) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The permission comes from the link the share page was opened with, or
+	// from the invitation of a signed-in reviewer
+	access, err := h.shareService.Resolve(r.Context(), r.FormValue("access"))
+	if err != nil || access.Share.ID != file.ShareID {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	middleware.ApplyReviewer(r, h.store, h.reviewerService, access)
+	share := access.Share
+
+	username := middleware.VisitorName(r, access)
+	if username == "" {
+		http.Error(w, "Username not set", http.StatusUnauthorized)
+		return
+	}
+
+	if share.IsExpired() {
+		http.Error(w, "Share has expired", http.StatusGone)
+		return
+	}
+
+	if !middleware.ShareUnlocked(r, h.store, share) {
+		http.Error(w, "Share is locked", http.StatusForbidden)
+		return
+	}
+
+	if !access.CanComment() {
+		http.Error(w, "This link does not allow comments", http.StatusForbidden)
+		return
+	}
+
 	content := r.FormValue("content")
 	if content == "" {
 		http.Error(w, "Content is required", http.StatusBadRequest)
 		return
 	}
 
-	comment, err := h.fileService.AddComment(file.ID, username, content)
+	var reviewerID *int
+	if access.Reviewer != nil {
+		reviewerID = &access.Reviewer.ID
+	}
+
+	comment, err := h.fileService.AddComment(r.Context(), file.ID, username, content, reviewerID)
 	if err != nil {
 		http.Error(w, "Failed to add comment", http.StatusInternalServerError)
 		return
 	}
 
+	h.auditService.Record(r.Context(), visitorAuditEvent(r, access, "comment.create", auditTargetComment, comment.ID, file.Filename))
+	h.subscriptionService.NotifyComment(r.Context(), share, file, comment, middleware.GetEmail(r))
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(comment)
 }
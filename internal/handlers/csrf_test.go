@@ -0,0 +1,240 @@
+package handlers_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/romanzipp/feedback/internal/config"
+	"github.com/romanzipp/feedback/internal/middleware"
+)
+
+// postWithToken posts a form with the CSRF token in the X-CSRF-Token header,
+// as comments.js does.
+func (b *browser) postWithToken(path string, form url.Values, token string) *testResponse {
+	b.t.Helper()
+
+	r, err := http.NewRequest(http.MethodPost, b.app.url+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		b.t.Fatalf("POST %s: %v", path, err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set(middleware.CSRFHeaderName, token)
+	return b.do(r)
+}
+
+// postUpload posts a file as multipart form like upload.js does, with the
+// CSRF token in the form field and, unless empty, in the X-CSRF-Token header.
+func (b *browser) postUpload(path string, content []byte, token string) *testResponse {
+	b.t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField(middleware.CSRFFieldName, b.csrfToken())
+	part, _ := writer.CreateFormFile("files", "upload.txt")
+	part.Write(content)
+	writer.Close()
+
+	r, err := http.NewRequest(http.MethodPost, b.app.url+path, &body)
+	if err != nil {
+		b.t.Fatalf("POST %s: %v", path, err)
+	}
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	if token != "" {
+		r.Header.Set(middleware.CSRFHeaderName, token)
+	}
+	return b.do(r)
+}
+
+func TestCSRFRejectsMissingToken(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+	file := app.upload(share, "notes.txt", []byte("notes"))
+
+	admin := app.newBrowser()
+	admin.loginWithToken()
+	visitor := app.newBrowser()
+	visitor.get("/share/" + share.Hash)
+
+	for name, post := range map[string]func() *testResponse{
+		"admin login": func() *testResponse {
+			return app.newBrowser().postRaw("/admin/login", url.Values{"password": {testAdminToken}})
+		},
+		"share delete": func() *testResponse { return admin.postRaw("/admin/shares/"+itoa(share.ID)+"/delete", nil) },
+		"logout":       func() *testResponse { return admin.postRaw("/admin/logout", nil) },
+		"visitor name": func() *testResponse {
+			return visitor.postRaw("/share/"+share.Hash+"/name", url.Values{"username": {"mallory"}})
+		},
+		"comment": func() *testResponse {
+			return visitor.postRaw("/api/files/"+file.Hash+"/comments", url.Values{"access": {share.Hash}, "content": {"spam"}})
+		},
+	} {
+		if resp := post(); resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s without token: status %d, want 403", name, resp.StatusCode)
+		}
+	}
+
+	// Nothing was changed
+	expectStatus(t, admin.get("/admin"), http.StatusOK)
+	expectStatus(t, visitor.get("/share/"+share.Hash), http.StatusOK)
+	var comments int
+	app.db.QueryRow("SELECT COUNT(*) FROM comments").Scan(&comments)
+	if comments != 0 {
+		t.Errorf("%d comments created without token", comments)
+	}
+}
+
+func TestCSRFRejectsTokenOfOtherBrowser(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+
+	admin := app.newBrowser()
+	admin.loginWithToken()
+
+	// The attacker's own valid token does not match the victim's cookie
+	attacker := app.newBrowser()
+	resp := admin.postRaw("/admin/shares/"+itoa(share.ID)+"/delete", url.Values{middleware.CSRFFieldName: {attacker.csrfToken()}})
+	expectStatus(t, resp, http.StatusForbidden)
+
+	var deleted bool
+	app.db.QueryRow("SELECT deleted_at IS NOT NULL FROM shares WHERE id = ?", share.ID).Scan(&deleted)
+	if deleted {
+		t.Error("share deleted with a foreign token")
+	}
+
+	expectRedirect(t, admin.post("/admin/shares/"+itoa(share.ID)+"/delete", nil), "/admin")
+}
+
+func TestCSRFRejectsUnsignedCookie(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	// A cookie the server did not sign is replaced instead of being trusted
+	b := app.newBrowser()
+	b.setCookie(middleware.CSRFCookieName, "forged")
+	resp := b.postRaw("/admin/login", url.Values{"password": {testAdminToken}, middleware.CSRFFieldName: {"forged"}})
+	expectStatus(t, resp, http.StatusForbidden)
+
+	if token := b.cookie(middleware.CSRFCookieName); token == "forged" || token == "" {
+		t.Errorf("CSRF cookie %q not replaced", token)
+	}
+}
+
+func TestCSRFHeaderToken(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+	file := app.upload(share, "notes.txt", []byte("notes"))
+
+	visitor := app.newBrowser()
+	visitor.get("/share/" + share.Hash)
+	expectRedirect(t, visitor.post("/share/"+share.Hash+"/name", url.Values{"username": {"alice"}}), "/share/"+share.Hash)
+
+	form := url.Values{"access": {share.Hash}, "content": {"Looks good"}}
+	expectStatus(t, visitor.postWithToken("/api/files/"+file.Hash+"/comments", form, "wrong"), http.StatusForbidden)
+	expectStatus(t, visitor.postWithToken("/api/files/"+file.Hash+"/comments", form, visitor.csrfToken()), http.StatusOK)
+}
+
+func TestCSRFMultipartRequiresHeader(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+
+	admin := app.newBrowser()
+	admin.loginWithToken()
+	path := "/admin/shares/" + itoa(share.ID) + "/upload"
+
+	// The form field of a multipart body is not read, it would parse the
+	// upload before the admin is authenticated
+	expectStatus(t, admin.postUpload(path, []byte("notes"), ""), http.StatusForbidden)
+	var files int
+	app.db.QueryRow("SELECT COUNT(*) FROM files").Scan(&files)
+	if files != 0 {
+		t.Errorf("%d files uploaded without the CSRF header", files)
+	}
+
+	expectRedirect(t, admin.postUpload(path, []byte("notes"), admin.csrfToken()), "/admin/shares/"+itoa(share.ID))
+}
+
+func TestCSRFLimitsFormSize(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	// A token after more than a megabyte of form data is not looked for
+	b := app.newBrowser()
+	form := url.Values{"padding": {strings.Repeat("x", 2<<20)}, middleware.CSRFFieldName: {b.csrfToken()}}
+	expectStatus(t, b.postRaw("/admin/login", form), http.StatusForbidden)
+}
+
+func TestUploadSizeLimit(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+	tooLarge := bytes.Repeat([]byte("x"), 11<<20) // MaxUploadSize is 10 MB
+
+	admin := app.newBrowser()
+	admin.loginWithToken()
+	resp := admin.postUpload("/admin/shares/"+itoa(share.ID)+"/upload", tooLarge, admin.csrfToken())
+	expectStatus(t, resp, http.StatusRequestEntityTooLarge)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("files", "large.txt")
+	part.Write(tooLarge)
+	writer.Close()
+	r := httptest.NewRequest("POST", "/api/v1/shares/"+itoa(share.ID)+"/files", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	if w := app.do(r); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("API upload: status %d, want 413", w.Code)
+	}
+
+	var files int
+	app.db.QueryRow("SELECT COUNT(*) FROM files").Scan(&files)
+	if files != 0 {
+		t.Errorf("%d oversized files saved", files)
+	}
+}
+
+func TestCSRFCookieAttributes(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	resp := app.newBrowser().get("/admin/login")
+	cookie := findCookie(resp.Cookies(), middleware.CSRFCookieName)
+	if cookie == nil {
+		t.Fatal("no CSRF cookie set")
+	}
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode || cookie.Path != "/" {
+		t.Errorf("CSRF cookie HttpOnly=%v SameSite=%v Path=%q", cookie.HttpOnly, cookie.SameSite, cookie.Path)
+	}
+	if !strings.Contains(resp.body, cookie.Value) {
+		t.Error("CSRF token not embedded in the page")
+	}
+
+	// Secure cookies cannot be planted by other hosts
+	secure := newTestApp(t, func(cfg *config.Config) { cfg.SecureCookies = true })
+	w := secure.do(newRequest("GET", "/admin/login", ""))
+	cookie = findCookie(w.Result().Cookies(), "__Host-"+middleware.CSRFCookieName)
+	if cookie == nil || !cookie.Secure || cookie.Domain != "" {
+		t.Errorf("secure CSRF cookie %+v, want Secure __Host- cookie without domain", cookie)
+	}
+}
+
+func TestOneClickUnsubscribeWithoutToken(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	// Mail clients post without a CSRF token, an unknown token is not found
+	// instead of forbidden
+	resp := app.newBrowser().postRaw("/subscriptions/unsubscribe/unknown", nil)
+	if resp.StatusCode == http.StatusForbidden {
+		t.Errorf("one-click unsubscribe rejected by the CSRF check")
+	}
+}
+
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
@@ -0,0 +1,4 @@
+package handlers
+
+// APIOperations exposes the documented API routes to the external tests.
+var APIOperations = apiOperations
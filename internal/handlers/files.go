@@ -1,20 +1,42 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"mime"
 	"net/http"
 	"os"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
 	"github.com/romanzipp/feedback/internal/services"
 )
 
 type FileHandler struct {
-	fileService *services.FileService
+	shareService        *services.ShareService
+	fileService         *services.FileService
+	adminService        *services.AdminService
+	adminSessionService *services.AdminSessionService
+	reviewerService     *services.ReviewerService
+	urlSigner           *services.URLSigner
+	store               *sessions.CookieStore
+	protectFiles        bool
 }
 
-func NewFileHandler(fileService *services.FileService) *FileHandler {
+// NewFileHandler creates the download handler. With protectFiles, files are
+// only served to visitors who opened the share, admins and signed URLs.
+func NewFileHandler(shareService *services.ShareService, fileService *services.FileService, adminService *services.AdminService, adminSessionService *services.AdminSessionService, reviewerService *services.ReviewerService, urlSigner *services.URLSigner, store *sessions.CookieStore, protectFiles bool) *FileHandler {
 	return &FileHandler{
-		fileService: fileService,
+		shareService:        shareService,
+		fileService:         fileService,
+		adminService:        adminService,
+		adminSessionService: adminSessionService,
+		reviewerService:     reviewerService,
+		urlSigner:           urlSigner,
+		store:               store,
+		protectFiles:        protectFiles,
 	}
 }
 
@@ -25,12 +47,46 @@ func (h *FileHandler) Download(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := h.fileService.GetByHash(fileHash)
+	file, err := h.fileService.GetByHash(r.Context(), fileHash)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
 
+	share, err := h.shareService.GetByID(r.Context(), file.ShareID)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	expires := r.URL.Query().Get("expires")
+	signed := h.urlSigner.VerifyFile(file.Hash, expires, r.URL.Query().Get("signature"))
+	admin := h.adminCanView(r, share)
+
+	// Admins can still open files of expired and protected shares
+	if share.IsExpired() && !admin {
+		http.Error(w, "Share has expired", http.StatusGone)
+		return
+	}
+
+	// Signed URLs grant access on their own until they expire
+	if !signed && !admin {
+		if status, message := h.checkVisitor(r, share); status != 0 {
+			http.Error(w, message, status)
+			return
+		}
+	}
+
+	// Nobody downloads files before they passed the malware scan
+	if file.Quarantined() {
+		http.Error(w, "File was quarantined because malware was found", http.StatusForbidden)
+		return
+	}
+	if file.Blocked() {
+		http.Error(w, "File is being scanned for malware", http.StatusForbidden)
+		return
+	}
+
 	// Open file
 	f, err := os.Open(file.StoragePath)
 	if err != nil {
@@ -41,10 +97,91 @@ func (h *FileHandler) Download(w http.ResponseWriter, r *http.Request) {
 
 	// Set headers
 	w.Header().Set("Content-Type", file.MimeType)
-	w.Header().Set("Content-Disposition", "inline; filename=\""+file.Filename+"\"")
-	// Cache for 1 year since file hash is immutable
-	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	// The filename is chosen by the uploader, FormatMediaType quotes and
+	// encodes it
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(contentDisposition(file.MimeType), map[string]string{"filename": file.Filename}))
+	// Cache for 1 year since file hash is immutable. Files of password
+	// protected and invite-only shares are revalidated on every request and
+	// not stored by shared caches, so revoked access takes effect.
+	switch {
+	case signed:
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(h.urlSigner.ExpiresIn(expires).Seconds())))
+	case h.protectFiles, share.HasPassword(), share.InviteOnly:
+		w.Header().Set("Cache-Control", "private, no-cache")
+	default:
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	}
 
 	// Serve file
 	http.ServeContent(w, r, file.Filename, file.UploadedAt, f)
 }
+
+// checkVisitor returns the status and message if a visitor may not download
+// files of the share. With protected files, the visitor must have opened the
+// share with a link that is still valid.
+func (h *FileHandler) checkVisitor(r *http.Request, share *database.Share) (int, string) {
+	access := &database.ShareAccess{Share: share, Permission: database.PermissionView}
+	if h.protectFiles {
+		hash := middleware.SharedAccessHash(r, h.store, share.ID)
+		if hash == "" {
+			return http.StatusForbidden, "Open the share to access its files"
+		}
+
+		var err error
+		access, err = h.shareService.Resolve(r.Context(), hash)
+		if err != nil || access.Share.ID != share.ID {
+			return http.StatusForbidden, "Open the share to access its files"
+		}
+	}
+
+	// Files of invite-only shares are limited to signed-in reviewers
+	middleware.ApplyReviewer(r, h.store, h.reviewerService, access)
+	if !access.CanView() {
+		return http.StatusForbidden, "Share is invite only"
+	}
+
+	if !middleware.ShareUnlocked(r, h.store, share) {
+		return http.StatusForbidden, "Share is locked"
+	}
+
+	return 0, ""
+}
+
+// adminCanView lets signed-in admins open files of protected shares they manage.
+func (h *FileHandler) adminCanView(r *http.Request, share *database.Share) bool {
+	_, admin := middleware.LoadAdmin(r, h.store, h.adminSessionService, h.adminService)
+	return admin != nil && admin.CanViewShare(*share)
+}
+
+// inlineTypes are the file types browsers display without running content of
+// the file, such as scripts in SVG or HTML.
+var inlineTypes = map[string]bool{
+	"image/avif":      true,
+	"image/gif":       true,
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// contentDisposition returns inline for file types that are safe to preview
+// in the browser, and attachment for all others so they are downloaded.
+func contentDisposition(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err == nil && inlineTypes[mediaType] {
+		return "inline"
+	}
+	return "attachment"
+}
+
+// multipartMemory is how much of an upload is kept in memory, the rest is
+// spooled to temporary files. The request size is limited separately by
+// MAX_UPLOAD_SIZE.
+const multipartMemory = 32 << 20
+
+// tooLarge reports whether reading the request body failed because it
+// exceeded the http.MaxBytesReader limit.
+func tooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
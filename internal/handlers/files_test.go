@@ -0,0 +1,115 @@
+package handlers_test
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/romanzipp/feedback/internal/config"
+)
+
+func TestDownloadCacheControl(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	public := app.createShare(nil)
+	publicFile := app.upload(public, "notes.txt", []byte("public"))
+
+	protected := app.createShare(map[string]interface{}{"password": "share password"})
+	protectedFile := app.upload(protected, "notes.txt", []byte("protected"))
+
+	visitor := app.newBrowser()
+	resp := visitor.get("/files/" + publicFile.Hash)
+	expectStatus(t, resp, http.StatusOK)
+	if cache := resp.Header.Get("Cache-Control"); cache != "public, max-age=31536000, immutable" {
+		t.Errorf("public share: Cache-Control %q", cache)
+	}
+
+	expectStatus(t, visitor.get("/files/"+protectedFile.Hash), http.StatusForbidden)
+	visitor.get("/share/" + protected.Hash)
+	expectRedirect(t, visitor.post("/share/"+protected.Hash+"/unlock", url.Values{"password": {"share password"}}), "/share/"+protected.Hash)
+
+	resp = visitor.get("/files/" + protectedFile.Hash)
+	expectStatus(t, resp, http.StatusOK)
+	if cache := resp.Header.Get("Cache-Control"); cache != "private, no-cache" {
+		t.Errorf("password protected share: Cache-Control %q, want private, no-cache", cache)
+	}
+
+	// Admins see files of invite-only shares
+	inviteOnly := app.createShare(map[string]interface{}{"invite_only": true})
+	inviteOnlyFile := app.upload(inviteOnly, "notes.txt", []byte("invite only"))
+
+	expectStatus(t, visitor.get("/files/"+inviteOnlyFile.Hash), http.StatusForbidden)
+	admin := app.newBrowser()
+	admin.loginWithToken()
+	resp = admin.get("/files/" + inviteOnlyFile.Hash)
+	expectStatus(t, resp, http.StatusOK)
+	if cache := resp.Header.Get("Cache-Control"); cache != "private, no-cache" {
+		t.Errorf("invite-only share: Cache-Control %q, want private, no-cache", cache)
+	}
+}
+
+func TestDownloadCacheControlProtectFiles(t *testing.T) {
+	app := newTestApp(t, func(cfg *config.Config) { cfg.ProtectFiles = true })
+
+	share := app.createShare(nil)
+	file := app.upload(share, "notes.txt", []byte("notes"))
+
+	visitor := app.newBrowser()
+	expectStatus(t, visitor.get("/files/"+file.Hash), http.StatusForbidden)
+	visitor.get("/share/" + share.Hash)
+
+	resp := visitor.get("/files/" + file.Hash)
+	expectStatus(t, resp, http.StatusOK)
+	if cache := resp.Header.Get("Cache-Control"); cache != "private, no-cache" {
+		t.Errorf("protected files: Cache-Control %q, want private, no-cache", cache)
+	}
+}
+
+func TestDownloadContentDisposition(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+
+	for _, filename := range []string{
+		"notes.txt",
+		`quote".txt`,
+		"semi;colon.txt",
+		"Übersicht – März.txt",
+	} {
+		file := app.upload(share, filename, []byte("content"))
+
+		resp := app.newBrowser().get("/files/" + file.Hash)
+		expectStatus(t, resp, http.StatusOK)
+
+		disposition, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+		if err != nil {
+			t.Errorf("%s: invalid Content-Disposition %q: %v", filename, resp.Header.Get("Content-Disposition"), err)
+			continue
+		}
+		if disposition != "attachment" || params["filename"] != file.Filename {
+			t.Errorf("%s: Content-Disposition %q, want attachment with filename %q", filename, resp.Header.Get("Content-Disposition"), file.Filename)
+		}
+	}
+
+	// Images and PDFs are previewed, content that can run scripts is not
+	for contentType, want := range map[string]string{
+		"image/png":                "inline",
+		"image/JPEG":               "inline",
+		"image/webp":               "inline",
+		"application/pdf":          "inline",
+		"image/svg+xml":            "attachment",
+		"text/html; charset=utf-8": "attachment",
+		"application/xhtml+xml":    "attachment",
+		"text/plain":               "attachment",
+		"application/octet-stream": "attachment",
+		"invalid type":             "attachment",
+	} {
+		file := app.uploadAs(share, "preview", contentType, []byte("content"))
+
+		resp := app.newBrowser().get("/files/" + file.Hash)
+		expectStatus(t, resp, http.StatusOK)
+		if disposition, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); disposition != want {
+			t.Errorf("%s: Content-Disposition %q, want %s", contentType, resp.Header.Get("Content-Disposition"), want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package handlers_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogMasksTokens(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(io.Discard) })
+
+	for _, path := range []string{
+		"/review/s3cr3t",
+		"/review/s3cr3t%2Fescaped",
+		"/subscriptions/confirm/s3cr3t",
+		"/subscriptions/unsubscribe/s3cr3t",
+	} {
+		app.do(newRequest("GET", path, ""))
+	}
+	app.do(newRequest("POST", "/subscriptions/unsubscribe/s3cr3t", ""))
+
+	logged := out.String()
+	if strings.Contains(logged, "s3cr3t") {
+		t.Errorf("request log contains the token:\n%s", logged)
+	}
+	for _, want := range []string{
+		"GET /review/[redacted] ",
+		"GET /subscriptions/confirm/[redacted] ",
+		"POST /subscriptions/unsubscribe/[redacted] ",
+	} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("request log misses %q:\n%s", want, logged)
+		}
+	}
+}
@@ -0,0 +1,377 @@
+package handlers_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/config"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+)
+
+const (
+	testOIDCClientID     = "feedback"
+	testOIDCClientSecret = "client-secret"
+)
+
+// mockIssuer is an OpenID provider serving discovery, JWKS, authorization and
+// token endpoints. Every authorization is granted to an account with the
+// configured claims.
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu             sync.Mutex
+	claims         map[string]interface{}
+	nonce          string // replaces the nonce of the ID token if set
+	authorizations map[string]mockAuthorization
+	tokenRequests  int
+}
+
+type mockAuthorization struct {
+	nonce     string
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	m := &mockIssuer{
+		t:              t,
+		key:            key,
+		authorizations: map[string]mockAuthorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /jwks", m.jwks)
+	mux.HandleFunc("GET /authorize", m.authorize)
+	mux.HandleFunc("POST /token", m.token)
+	mux.HandleFunc("GET /userinfo", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"sub": m.claims["sub"]})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+// configure enables single sign-on with the issuer, mapping the "admins"
+// group to owners and "staff" to editors.
+func (m *mockIssuer) configure(cfg *config.Config) {
+	cfg.OIDCIssuer = m.server.URL
+	cfg.OIDCClientID = testOIDCClientID
+	cfg.OIDCClientSecret = testOIDCClientSecret
+	cfg.OIDCRedirectURL = "http://feedback.test/admin/oidc/callback"
+	cfg.OIDCScopes = []string{"openid", "profile", "groups"}
+	cfg.OIDCGroupsClaim = "groups"
+	cfg.OIDCRoleMapping = map[string]string{"admins": database.RoleOwner, "staff": database.RoleEditor}
+}
+
+// setAccount sets the claims of the account authorizations are granted to.
+func (m *mockIssuer) setAccount(subject, username string, groups ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claims = map[string]interface{}{"sub": subject, "preferred_username": username, "groups": groups}
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                m.server.URL,
+		"authorization_endpoint":                m.server.URL + "/authorize",
+		"token_endpoint":                        m.server.URL + "/token",
+		"jwks_uri":                              m.server.URL + "/jwks",
+		"userinfo_endpoint":                     m.server.URL + "/userinfo",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]interface{}{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+// authorize grants the request at once and redirects back with a code.
+func (m *mockIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != testOIDCClientID || query.Get("response_type") != "code" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(randomBytes(m.t, 16))
+	m.mu.Lock()
+	m.authorizations[code] = mockAuthorization{
+		nonce:     query.Get("nonce"),
+		challenge: query.Get("code_challenge"),
+		claims:    m.claims,
+	}
+	m.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token redeems a code once, if the PKCE verifier matches the challenge.
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tokenRequests++
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if clientID != testOIDCClientID || clientSecret != testOIDCClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	authorization, ok := m.authorizations[r.PostFormValue("code")]
+	delete(m.authorizations, r.PostFormValue("code"))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(challenge[:]) != authorization.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	nonce := authorization.nonce
+	if m.nonce != "" {
+		nonce = m.nonce
+	}
+	claims := map[string]interface{}{
+		"iss":   m.server.URL,
+		"aud":   testOIDCClientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": nonce,
+	}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     m.sign(claims),
+	})
+}
+
+// sign returns the claims as JWT signed with RS256.
+func (m *mockIssuer) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		m.t.Fatalf("encode claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, digest[:])
+	if err != nil {
+		m.t.Fatalf("sign token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// startLogin starts single sign-on in the browser and returns the callback
+// path the provider redirects to after granting access.
+func (m *mockIssuer) startLogin(b *browser) string {
+	m.t.Helper()
+
+	resp := b.get("/admin/oidc/login")
+	if resp.StatusCode != http.StatusFound {
+		m.t.Fatalf("start login: status %d", resp.StatusCode)
+	}
+
+	providerResp := b.do(mustRequest(m.t, http.MethodGet, resp.Header.Get("Location")))
+	if providerResp.StatusCode != http.StatusFound {
+		m.t.Fatalf("authorize: status %d: %s", providerResp.StatusCode, providerResp.body)
+	}
+
+	callback, err := url.Parse(providerResp.Header.Get("Location"))
+	if err != nil {
+		m.t.Fatalf("parse callback: %v", err)
+	}
+	return callback.RequestURI()
+}
+
+func TestOIDCLoginMapsGroupsToRole(t *testing.T) {
+	issuer := newMockIssuer(t)
+	app := newTestApp(t, issuer.configure)
+
+	issuer.setAccount("user-1", "alice", "staff", "other")
+	editor := app.newBrowser()
+	expectRedirect(t, editor.get(issuer.startLogin(editor)), "/admin")
+	expectStatus(t, editor.get("/admin"), http.StatusOK)
+	expectStatus(t, editor.get("/admin/admins"), http.StatusForbidden)
+
+	// The most privileged mapped group wins
+	issuer.setAccount("user-2", "bob", "staff", "admins")
+	owner := app.newBrowser()
+	expectRedirect(t, owner.get(issuer.startLogin(owner)), "/admin")
+	expectStatus(t, owner.get("/admin/admins"), http.StatusOK)
+
+	var username, role string
+	if err := app.db.QueryRow("SELECT username, role FROM admins WHERE oidc_subject = ?", "user-1").Scan(&username, &role); err != nil {
+		t.Fatalf("load admin: %v", err)
+	}
+	if username != "alice" || role != database.RoleEditor {
+		t.Errorf("admin %q with role %q, want alice with role %q", username, role, database.RoleEditor)
+	}
+}
+
+func TestOIDCLoginWithoutMappedGroup(t *testing.T) {
+	issuer := newMockIssuer(t)
+	app := newTestApp(t, issuer.configure)
+
+	issuer.setAccount("user-1", "mallory", "other")
+	visitor := app.newBrowser()
+	expectStatus(t, visitor.get(issuer.startLogin(visitor)), http.StatusForbidden)
+	expectRedirect(t, visitor.get("/admin"), "/admin/login")
+}
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	app := newTestApp(t, issuer.configure)
+	issuer.setAccount("user-1", "alice", "admins")
+
+	visitor := app.newBrowser()
+	callback, _ := url.Parse(issuer.startLogin(visitor))
+	query := callback.Query()
+	query.Set("state", "forged")
+	callback.RawQuery = query.Encode()
+
+	expectStatus(t, visitor.get(callback.RequestURI()), http.StatusBadRequest)
+	if issuer.tokenRequests != 0 {
+		t.Error("code redeemed despite the state mismatch")
+	}
+	expectRedirect(t, visitor.get("/admin"), "/admin/login")
+
+	// A callback without a started login is rejected as well
+	other := app.newBrowser()
+	expectStatus(t, other.get("/admin/oidc/callback?code=x&state="), http.StatusBadRequest)
+}
+
+func TestOIDCCallbackIsSingleUse(t *testing.T) {
+	issuer := newMockIssuer(t)
+	app := newTestApp(t, issuer.configure)
+	issuer.setAccount("user-1", "alice", "admins")
+
+	admin := app.newBrowser()
+	callback := issuer.startLogin(admin)
+	expectRedirect(t, admin.get(callback), "/admin")
+
+	// The login cookie was cleared, so the same callback fails the state check
+	expectStatus(t, admin.get(callback), http.StatusBadRequest)
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	app := newTestApp(t, issuer.configure)
+	issuer.setAccount("user-1", "alice", "admins")
+	issuer.nonce = "replayed-nonce"
+
+	visitor := app.newBrowser()
+	expectStatus(t, visitor.get(issuer.startLogin(visitor)), http.StatusUnauthorized)
+	expectRedirect(t, visitor.get("/admin"), "/admin/login")
+}
+
+func TestOIDCCodeBoundToVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	app := newTestApp(t, issuer.configure)
+	issuer.setAccount("user-1", "alice", "admins")
+
+	// The victim's code is injected into the attacker's own login, which
+	// carries a valid state but another PKCE verifier
+	victim := app.newBrowser()
+	victimCallback, _ := url.Parse(issuer.startLogin(victim))
+
+	attacker := app.newBrowser()
+	attackerCallback, _ := url.Parse(issuer.startLogin(attacker))
+	query := attackerCallback.Query()
+	query.Set("code", victimCallback.Query().Get("code"))
+	attackerCallback.RawQuery = query.Encode()
+
+	expectStatus(t, attacker.get(attackerCallback.RequestURI()), http.StatusUnauthorized)
+	expectRedirect(t, attacker.get("/admin"), "/admin/login")
+}
+
+func TestOIDCProviderError(t *testing.T) {
+	issuer := newMockIssuer(t)
+	app := newTestApp(t, issuer.configure)
+
+	visitor := app.newBrowser()
+	visitor.get("/admin/oidc/login")
+	resp := visitor.get("/admin/oidc/callback?error=access_denied")
+	expectStatus(t, resp, http.StatusUnauthorized)
+	if visitor.cookie(middleware.AdminSessionName) != "" {
+		t.Error("session cookie set after a provider error")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("random bytes: %v", err)
+	}
+	return b
+}
+
+func mustRequest(t *testing.T, method, target string) *http.Request {
+	r, err := http.NewRequest(method, target, nil)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, target, err)
+	}
+	return r
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+// APIBasePath is the prefix all JSON API routes are mounted under.
+const APIBasePath = "/api/v1"
+
+type apiOperation struct {
+	Method      string
+	Path        string
+	OperationID string
+	Summary     string
+	Tag         string
+	Public      bool
+	Scope       string
+	Paginated   bool
+	Body        interface{} // request type, or multipartBody for uploads
+	Status      int
+	Response    interface{} // nil for responses without body
+	List        bool
+}
+
+type multipartBody struct{}
+
+// apiOperations describes every route registered under APIBasePath. The
+// OpenAPI document is generated from it, and the tests check it against the
+// routes and scopes of the router.
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/openapi.json", OperationID: "getOpenAPI", Summary: "OpenAPI document", Tag: "Meta", Public: true, Status: http.StatusOK},
+	{Method: "GET", Path: "/shares", OperationID: "listShares", Scope: services.ScopeSharesRead, Summary: "List shares", Tag: "Shares", Paginated: true, Status: http.StatusOK, Response: apiShare{}, List: true},
+	{Method: "POST", Path: "/shares", OperationID: "createShare", Scope: services.ScopeSharesWrite, Summary: "Create a share", Tag: "Shares", Body: shareRequest{}, Status: http.StatusCreated, Response: apiShare{}},
+	{Method: "GET", Path: "/shares/{id}", OperationID: "getShare", Scope: services.ScopeSharesRead, Summary: "Get a share", Tag: "Shares", Status: http.StatusOK, Response: apiShare{}},
+	{Method: "PATCH", Path: "/shares/{id}", OperationID: "updateShare", Scope: services.ScopeSharesWrite, Summary: "Update a share", Tag: "Shares", Body: shareRequest{}, Status: http.StatusOK, Response: apiShare{}},
+	{Method: "DELETE", Path: "/shares/{id}", OperationID: "deleteShare", Scope: services.ScopeContentDelete, Summary: "Move a share to the trash", Tag: "Shares", Status: http.StatusNoContent},
+	{Method: "GET", Path: "/shares/{id}/files", OperationID: "listFiles", Scope: services.ScopeSharesRead, Summary: "List files of a share", Tag: "Files", Paginated: true, Status: http.StatusOK, Response: apiFile{}, List: true},
+	{Method: "POST", Path: "/shares/{id}/files", OperationID: "uploadFiles", Scope: services.ScopeFilesUpload, Summary: "Upload files to a share", Tag: "Files", Body: multipartBody{}, Status: http.StatusCreated, Response: apiFile{}, List: true},
+	{Method: "GET", Path: "/files/{id}", OperationID: "getFile", Scope: services.ScopeSharesRead, Summary: "Get a file", Tag: "Files", Status: http.StatusOK, Response: apiFile{}},
+	{Method: "POST", Path: "/files/{id}/signed-url", OperationID: "signFileURL", Scope: services.ScopeSharesRead, Summary: "Create a short-lived download URL for a file", Tag: "Files", Status: http.StatusCreated, Response: apiSignedURL{}},
+	{Method: "DELETE", Path: "/files/{id}", OperationID: "deleteFile", Scope: services.ScopeContentDelete, Summary: "Move a file to the trash", Tag: "Files", Status: http.StatusNoContent},
+	{Method: "GET", Path: "/files/{id}/comments", OperationID: "listComments", Scope: services.ScopeSharesRead, Summary: "List comments of a file", Tag: "Comments", Paginated: true, Status: http.StatusOK, Response: apiComment{}, List: true},
+	{Method: "POST", Path: "/files/{id}/comments", OperationID: "createComment", Scope: services.ScopeCommentsModerate, Summary: "Add a comment to a file", Tag: "Comments", Body: commentRequest{}, Status: http.StatusCreated, Response: apiComment{}},
+	{Method: "DELETE", Path: "/comments/{id}", OperationID: "deleteComment", Scope: services.ScopeCommentsModerate, Summary: "Move a comment to the trash", Tag: "Comments", Status: http.StatusNoContent},
+}
+
+type OpenAPIHandler struct {
+	document []byte
+}
+
+func NewOpenAPIHandler() *OpenAPIHandler {
+	document, err := json.MarshalIndent(OpenAPIDocument(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return &OpenAPIHandler{document: document}
+}
+
+func (h *OpenAPIHandler) Spec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.document)
+}
+
+// OpenAPIDocument builds the OpenAPI 3 document for the JSON API.
+func OpenAPIDocument() map[string]interface{} {
+	schemas := map[string]interface{}{
+		"Error": map[string]interface{}{
+			"type":     "object",
+			"required": []string{"error"},
+			"properties": map[string]interface{}{
+				"error": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"status", "code", "message"},
+					"properties": map[string]interface{}{
+						"status":  map[string]interface{}{"type": "integer"},
+						"code":    map[string]interface{}{"type": "string"},
+						"message": map[string]interface{}{"type": "string"},
+					},
+				},
+			},
+		},
+		"Pagination": schemaFor(reflect.TypeOf(apiPagination{})),
+	}
+
+	paths := map[string]interface{}{}
+	for _, op := range apiOperations {
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+		item[strings.ToLower(op.Method)] = operationObject(op, schemas)
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "Feedback API",
+			"version": "1.0.0",
+		},
+		"servers": []interface{}{
+			map[string]interface{}{"url": APIBasePath},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{
+					"type":        "http",
+					"scheme":      "bearer",
+					"description": "An API key created in the admin panel, or the admin token.",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"bearerAuth": []string{}},
+		},
+	}
+}
+
+func operationObject(op apiOperation, schemas map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{
+		"operationId": op.OperationID,
+		"summary":     op.Summary,
+		"tags":        []string{op.Tag},
+	}
+
+	var params []interface{}
+	for _, segment := range strings.Split(op.Path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "integer"},
+			})
+		}
+	}
+	if op.Paginated {
+		params = append(params,
+			map[string]interface{}{"name": "limit", "in": "query", "schema": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": maxPageLimit, "default": defaultPageLimit}},
+			map[string]interface{}{"name": "offset", "in": "query", "schema": map[string]interface{}{"type": "integer", "minimum": 0, "default": 0}},
+		)
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+
+	switch body := op.Body.(type) {
+	case nil:
+	case multipartBody:
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"multipart/form-data": map[string]interface{}{
+					"schema": map[string]interface{}{
+						"type":     "object",
+						"required": []string{"files"},
+						"properties": map[string]interface{}{
+							"files": map[string]interface{}{
+								"type":  "array",
+								"items": map[string]interface{}{"type": "string", "format": "binary"},
+							},
+						},
+					},
+				},
+			},
+		}
+	default:
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": schemaRef(reflect.TypeOf(body), schemas)},
+			},
+		}
+	}
+
+	success := map[string]interface{}{"description": http.StatusText(op.Status)}
+	if op.Response != nil {
+		data := schemaRef(reflect.TypeOf(op.Response), schemas)
+		if op.List {
+			data = map[string]interface{}{"type": "array", "items": data}
+		}
+		properties := map[string]interface{}{"data": data}
+		required := []string{"data"}
+		if op.Paginated {
+			properties["pagination"] = map[string]interface{}{"$ref": "#/components/schemas/Pagination"}
+			required = append(required, "pagination")
+		}
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{
+					"type":       "object",
+					"required":   required,
+					"properties": properties,
+				},
+			},
+		}
+	} else if op.OperationID == "getOpenAPI" {
+		success["content"] = map[string]interface{}{
+			"application/json": map[string]interface{}{"schema": map[string]interface{}{"type": "object"}},
+		}
+	}
+
+	responses := map[string]interface{}{
+		fmt.Sprintf("%d", op.Status): success,
+	}
+	if !op.Public {
+		responses["401"] = errorResponse("Missing or invalid bearer token")
+		responses["403"] = errorResponse("API key lacks the required scope")
+		result["description"] = "Requires the `" + op.Scope + "` scope when called with an API key."
+		result["x-required-scope"] = op.Scope
+		result["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+	} else {
+		result["security"] = []interface{}{}
+	}
+	if strings.Contains(op.Path, "{") {
+		responses["404"] = errorResponse("Resource not found")
+	}
+	if op.Body != nil || op.Paginated {
+		responses["400"] = errorResponse("Malformed request")
+	}
+	if op.Body != nil {
+		responses["422"] = errorResponse("Validation failed")
+	}
+	if _, ok := op.Body.(multipartBody); ok {
+		responses["413"] = errorResponse("Request body exceeds MAX_UPLOAD_SIZE")
+	}
+	result["responses"] = responses
+
+	return result
+}
+
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content": map[string]interface{}{
+			"application/json": map[string]interface{}{
+				"schema": map[string]interface{}{"$ref": "#/components/schemas/Error"},
+			},
+		},
+	}
+}
+
+// schemaRef registers the schema for t in schemas and returns a reference to it.
+func schemaRef(t reflect.Type, schemas map[string]interface{}) map[string]interface{} {
+	name := strings.TrimPrefix(t.Name(), "api")
+	name = strings.ToUpper(name[:1]) + name[1:]
+	if _, ok := schemas[name]; !ok {
+		schemas[name] = schemaFor(t)
+	}
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// schemaFor derives a JSON schema from a struct's json tags.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Ptr:
+		schema := schemaFor(t.Elem())
+		schema["nullable"] = true
+		return schema
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() == reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag := field.Tag.Get("json")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			parts := strings.Split(tag, ",")
+			properties[parts[0]] = schemaFor(field.Type)
+			if field.Type.Kind() != reflect.Ptr && !strings.Contains(tag, "omitempty") {
+				required = append(required, parts[0])
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
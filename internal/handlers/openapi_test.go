@@ -0,0 +1,113 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/romanzipp/feedback/internal/handlers"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+// TestOpenAPIRoutes checks that every API route of the router is documented
+// and every documented operation has a route.
+func TestOpenAPIRoutes(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	documented := map[string]bool{}
+	for _, op := range handlers.APIOperations {
+		documented[op.Method+" "+handlers.APIBasePath+op.Path] = true
+	}
+
+	registered := map[string]bool{}
+	err := chi.Walk(app.server.Router, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		if strings.HasPrefix(route, handlers.APIBasePath+"/") {
+			registered[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk routes: %v", err)
+	}
+
+	for route := range registered {
+		if !documented[route] {
+			t.Errorf("missing from OpenAPI document: %s", route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			t.Errorf("documented but not registered: %s", route)
+		}
+	}
+}
+
+// TestOpenAPIScopes checks that the router requires the documented scope of
+// every operation: a key lacking only that scope is rejected, a key with only
+// that scope gets past the scope check.
+func TestOpenAPIScopes(t *testing.T) {
+	app := newTestApp(t, nil)
+	apiKeys := services.NewAPIKeyService(app.db)
+
+	keyWith := func(scopes []string) string {
+		t.Helper()
+		_, key, err := apiKeys.Create(context.Background(), "test", scopes, nil)
+		if err != nil {
+			t.Fatalf("create API key: %v", err)
+		}
+		return key
+	}
+
+	for _, op := range handlers.APIOperations {
+		path := handlers.APIBasePath + strings.ReplaceAll(op.Path, "{id}", "1")
+
+		if op.Public {
+			if op.Scope != "" {
+				t.Errorf("%s %s: public operation with scope %s", op.Method, op.Path, op.Scope)
+			}
+			if w := app.do(newRequest(op.Method, path, "")); w.Code == http.StatusUnauthorized {
+				t.Errorf("%s %s: public operation requires authentication", op.Method, op.Path)
+			}
+			continue
+		}
+
+		if w := app.do(newRequest(op.Method, path, "")); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without token: status %d, want 401", op.Method, op.Path, w.Code)
+		}
+
+		var others []string
+		for _, scope := range services.APIScopes {
+			if scope.Name != op.Scope {
+				others = append(others, scope.Name)
+			}
+		}
+
+		r := newRequest(op.Method, path, "")
+		r.Header.Set("Authorization", "Bearer "+keyWith(others))
+		w := app.do(r)
+		if code := apiErrorCode(w.Body.Bytes()); w.Code != http.StatusForbidden || code != "insufficient_scope" {
+			t.Errorf("%s %s without %s: status %d (%s), want 403 insufficient_scope", op.Method, op.Path, op.Scope, w.Code, code)
+		}
+
+		r = newRequest(op.Method, path, "")
+		r.Header.Set("Authorization", "Bearer "+keyWith([]string{op.Scope}))
+		w = app.do(r)
+		if w.Code == http.StatusForbidden || w.Code == http.StatusUnauthorized {
+			t.Errorf("%s %s with %s: status %d", op.Method, op.Path, op.Scope, w.Code)
+		}
+	}
+}
+
+// apiErrorCode returns the code of a JSON API error response.
+func apiErrorCode(body []byte) string {
+	var response struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &response)
+	return response.Error.Code
+}
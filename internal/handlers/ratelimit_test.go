@@ -0,0 +1,113 @@
+package handlers_test
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/config"
+)
+
+// trustLocalProxy lets browsers of the test appear as different clients with
+// X-Forwarded-For.
+func trustLocalProxy(cfg *config.Config) {
+	cfg.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32"), netip.MustParsePrefix("::1/128")}
+}
+
+func TestUnlockRateLimitPerClientAndShare(t *testing.T) {
+	app := newTestApp(t, func(cfg *config.Config) {
+		trustLocalProxy(cfg)
+		cfg.RateLimitUnlock = config.RateLimit{Requests: 3, Period: time.Hour}
+	})
+	share := app.createShare(map[string]interface{}{"password": "share password"})
+	other := app.createShare(map[string]interface{}{"password": "share password"})
+
+	attacker := app.newBrowser()
+	attacker.forwardedFor = "203.0.113.1"
+	for i := 0; i < 3; i++ {
+		resp := attacker.post("/share/"+share.Hash+"/unlock", url.Values{"password": {"guess"}})
+		expectStatus(t, resp, http.StatusUnauthorized)
+		if resp.Header.Get("RateLimit-Limit") != "3" {
+			t.Errorf("RateLimit-Limit %q, want 3", resp.Header.Get("RateLimit-Limit"))
+		}
+	}
+
+	resp := attacker.post("/share/"+share.Hash+"/unlock", url.Values{"password": {"share password"}})
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("no Retry-After header")
+	}
+
+	// Dropping the cookies does not reset the quota
+	fresh := app.newBrowser()
+	fresh.forwardedFor = attacker.forwardedFor
+	expectStatus(t, fresh.post("/share/"+share.Hash+"/unlock", url.Values{"password": {"guess"}}), http.StatusTooManyRequests)
+
+	// Other shares and other clients are counted separately
+	expectStatus(t, attacker.post("/share/"+other.Hash+"/unlock", url.Values{"password": {"guess"}}), http.StatusUnauthorized)
+
+	visitor := app.newBrowser()
+	visitor.forwardedFor = "203.0.113.2"
+	expectRedirect(t, visitor.post("/share/"+share.Hash+"/unlock", url.Values{"password": {"share password"}}), "/share/"+share.Hash)
+}
+
+func TestSubscribeRateLimitIgnoresCookies(t *testing.T) {
+	app := newTestApp(t, func(cfg *config.Config) {
+		trustLocalProxy(cfg)
+		cfg.RateLimitSubscribe = config.RateLimit{Requests: 2, Period: time.Hour}
+	})
+	share := app.createShare(nil)
+
+	// Every request comes from a new browser session with a new CSRF token
+	subscribe := func(ip string) *testResponse {
+		b := app.newBrowser()
+		b.forwardedFor = ip
+		b.get("/share/" + share.Hash)
+		return b.post("/share/"+share.Hash+"/subscribe", url.Values{"email": {"visitor@example.com"}})
+	}
+
+	for i := 0; i < 2; i++ {
+		if resp := subscribe("203.0.113.1"); resp.StatusCode == http.StatusTooManyRequests {
+			t.Fatalf("request %d rate limited", i+1)
+		}
+	}
+	expectStatus(t, subscribe("203.0.113.1"), http.StatusTooManyRequests)
+
+	if resp := subscribe("203.0.113.2"); resp.StatusCode == http.StatusTooManyRequests {
+		t.Error("other client rate limited")
+	}
+}
+
+func TestCommentRateLimitIgnoresCookies(t *testing.T) {
+	app := newTestApp(t, func(cfg *config.Config) {
+		trustLocalProxy(cfg)
+		cfg.RateLimitComments = config.RateLimit{Requests: 2, Period: time.Hour}
+	})
+	share := app.createShare(nil)
+	file := app.upload(share, "notes.txt", []byte("notes"))
+
+	comment := func() *testResponse {
+		b := app.newBrowser()
+		b.forwardedFor = "203.0.113.1"
+		b.get("/share/" + share.Hash)
+		b.post("/share/"+share.Hash+"/name", url.Values{"username": {"spammer"}})
+		return b.post("/api/files/"+file.Hash+"/comments", url.Values{"access": {share.Hash}, "content": {"spam"}})
+	}
+
+	expectStatus(t, comment(), http.StatusOK)
+	expectStatus(t, comment(), http.StatusOK)
+	expectStatus(t, comment(), http.StatusTooManyRequests)
+}
+
+func TestLoginRateLimit(t *testing.T) {
+	app := newTestApp(t, func(cfg *config.Config) {
+		cfg.RateLimitLogin = config.RateLimit{Requests: 2, Period: time.Hour}
+	})
+
+	b := app.newBrowser()
+	expectStatus(t, b.post("/admin/login", url.Values{"password": {"wrong"}}), http.StatusUnauthorized)
+	expectStatus(t, b.post("/admin/login", url.Values{"password": {"wrong"}}), http.StatusUnauthorized)
+	expectStatus(t, b.post("/admin/login", url.Values{"password": {testAdminToken}}), http.StatusTooManyRequests)
+}
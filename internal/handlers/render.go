@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/romanzipp/feedback/internal/middleware"
+)
+
+// render executes a template and adds the data shared by all pages.
+func render(w http.ResponseWriter, r *http.Request, templates *template.Template, name string, data map[string]interface{}) {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+
+	if admin := middleware.GetAdmin(r); admin != nil {
+		data["CurrentAdmin"] = admin
+	}
+
+	// Every form must submit the token, see middleware.CSRF
+	data["CSRFToken"] = middleware.CSRFToken(r)
+
+	// Script tags must carry the nonce, see middleware.SecurityHeaders
+	data["CSPNonce"] = middleware.CSPNonce(r)
+
+	if err := templates.ExecuteTemplate(w, name, data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -12,71 +12,95 @@ import (
 	"github.com/romanzipp/feedback/internal/services"
 )
 
+// shareCommentsPerFile is the number of most recent comments shown per file
+// when a share page is opened.
+const shareCommentsPerFile = 10
+
 type ShareHandler struct {
-	templates    *template.Template
-	shareService *services.ShareService
-	fileService  *services.FileService
-	store        *sessions.CookieStore
+	templates           *template.Template
+	shareService        *services.ShareService
+	fileService         *services.FileService
+	subscriptionService *services.SubscriptionService
+	reviewerService     *services.ReviewerService
+	auditService        *services.AuditService
+	store               *sessions.CookieStore
 }
 
-func NewShareHandler(templates *template.Template, shareService *services.ShareService, fileService *services.FileService, store *sessions.CookieStore) *ShareHandler {
+func NewShareHandler(templates *template.Template, shareService *services.ShareService, fileService *services.FileService, subscriptionService *services.SubscriptionService, reviewerService *services.ReviewerService, auditService *services.AuditService, store *sessions.CookieStore) *ShareHandler {
 	return &ShareHandler{
-		templates:    templates,
-		shareService: shareService,
-		fileService:  fileService,
-		store:        store,
+		templates:           templates,
+		shareService:        shareService,
+		fileService:         fileService,
+		subscriptionService: subscriptionService,
+		reviewerService:     reviewerService,
+		auditService:        auditService,
+		store:               store,
 	}
 }
 
 func (h *ShareHandler) View(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
-	username := middleware.GetUsername(r)
 
-	share, err := h.shareService.GetByHash(hash)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			http.NotFound(w, r)
-			return
-		}
-		http.Error(w, "Failed to load share", http.StatusInternalServerError)
+	access, ok := h.resolve(w, r, hash)
+	if !ok {
 		return
 	}
+	share := access.Share
 
-	files, err := h.fileService.GetByShareID(share.ID)
-	if err != nil {
-		http.Error(w, "Failed to load files", http.StatusInternalServerError)
+	if share.IsExpired() {
+		h.renderExpired(w, r, share)
 		return
 	}
 
-	// Load comments for each file
-	filesWithComments := make([]database.FileWithComments, 0, len(files))
-	for _, file := range files {
-		comments, err := h.fileService.GetComments(file.ID)
-		if err != nil {
-			http.Error(w, "Failed to load comments", http.StatusInternalServerError)
-			return
-		}
-		filesWithComments = append(filesWithComments, database.FileWithComments{
-			File:     file,
-			Comments: comments,
-		})
+	if !access.CanView() {
+		h.renderInviteOnly(w, r)
+		return
+	}
+
+	if !middleware.ShareUnlocked(r, h.store, share) {
+		h.renderUnlock(w, r, hash, "", http.StatusUnauthorized)
+		return
+	}
+
+	if err := middleware.RememberShareAccess(w, r, h.store, access); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	// Earlier comments are loaded by the page when they are expanded
+	files, err := h.fileService.ListWithComments(r.Context(), share.ID, shareCommentsPerFile)
+	if err != nil {
+		http.Error(w, "Failed to load files", http.StatusInternalServerError)
+		return
 	}
 
 	data := map[string]interface{}{
 		"Share":    share,
-		"Files":    filesWithComments,
-		"Username": username,
+		"Access":   access,
+		"Files":    files,
+		"Username": middleware.VisitorName(r, access),
+		"Email":    middleware.GetEmail(r),
 		"Hash":     hash,
 	}
 
-	if err := h.templates.ExecuteTemplate(w, "share", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	render(w, r, h.templates, "share", data)
 }
 
 func (h *ShareHandler) SetUsername(w http.ResponseWriter, r *http.Request) {
 	hash := chi.URLParam(r, "hash")
 
+	access, ok := h.resolve(w, r, hash)
+	if !ok {
+		return
+	}
+	share := access.Share
+
+	// Reviewers keep the verified name of their invitation
+	if share.IsExpired() || !access.CanView() || access.Reviewer != nil || !middleware.ShareUnlocked(r, h.store, share) {
+		http.Redirect(w, r, "/share/"+hash, http.StatusSeeOther)
+		return
+	}
+
 	if err := r.ParseForm(); err != nil {
 		http.Error(w, "Invalid form data", http.StatusBadRequest)
 		return
@@ -88,12 +112,193 @@ func (h *ShareHandler) SetUsername(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Email is optional and only needed for notifications
+	email := r.FormValue("email")
+	if email != "" {
+		var err error
+		email, err = services.NormalizeEmail(email)
+		if err != nil {
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+	}
+
 	session, _ := h.store.Get(r, "user-session")
 	session.Values["username"] = username
+	if email != "" {
+		session.Values["email"] = email
+	}
 	if err := session.Save(r, w); err != nil {
 		http.Error(w, "Failed to save session", http.StatusInternalServerError)
 		return
 	}
 
+	if email != "" && r.FormValue("subscribe") != "" {
+		if _, err := h.subscriptionService.Subscribe(r.Context(), access, nil, email); err != nil {
+			http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/share/"+hash, http.StatusSeeOther)
+}
+
+func (h *ShareHandler) Unlock(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	access, ok := h.resolve(w, r, hash)
+	if !ok {
+		return
+	}
+	share := access.Share
+
+	if share.IsExpired() || !access.CanView() {
+		http.Redirect(w, r, "/share/"+hash, http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	if !h.shareService.CheckPassword(share, r.FormValue("password")) {
+		h.renderUnlock(w, r, hash, "Wrong password", http.StatusUnauthorized)
+		return
+	}
+
+	if err := middleware.UnlockShare(w, r, h.store, share); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/share/"+hash, http.StatusSeeOther)
+}
+
+// Review signs the visitor in with the magic link of a reviewer invitation and
+// opens the share.
+func (h *ShareHandler) Review(w http.ResponseWriter, r *http.Request) {
+	reviewer, err := h.reviewerService.Authenticate(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "This invitation link is invalid or has been revoked", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to load invitation", http.StatusInternalServerError)
+		return
+	}
+
+	share, err := h.shareService.GetByID(r.Context(), reviewer.ShareID)
+	if err != nil {
+		// The share may have been moved to the trash
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to load share", http.StatusInternalServerError)
+		return
+	}
+
+	if err := middleware.SignInReviewer(w, r, h.store, reviewer); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/share/"+share.Hash, http.StatusSeeOther)
+}
+
+// resolve looks up the share of a hash with the permission of the visitor.
+func (h *ShareHandler) resolve(w http.ResponseWriter, r *http.Request, hash string) (*database.ShareAccess, bool) {
+	access, err := h.shareService.Resolve(r.Context(), hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return nil, false
+		}
+		http.Error(w, "Failed to load share", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	middleware.ApplyReviewer(r, h.store, h.reviewerService, access)
+	return access, true
+}
+
+func (h *ShareHandler) renderUnlock(w http.ResponseWriter, r *http.Request, hash, errorMessage string, status int) {
+	// The share name is not shown, it may be confidential as well
+	data := map[string]interface{}{
+		"Hash":  hash,
+		"Error": errorMessage,
+	}
+
+	w.WriteHeader(status)
+	render(w, r, h.templates, "share_unlock", data)
+}
+
+func (h *ShareHandler) renderInviteOnly(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	render(w, r, h.templates, "share_invite_only", nil)
+}
+
+func (h *ShareHandler) renderExpired(w http.ResponseWriter, r *http.Request, share *database.Share) {
+	data := map[string]interface{}{
+		"ExpiresAt": share.ExpiresAt,
+	}
+
+	w.WriteHeader(http.StatusGone)
+	render(w, r, h.templates, "share_expired", data)
+}
+
+// ApproveFile approves a file of the share, or revokes the approval if the
+// form has a revoke field. It requires a link with approve permission.
+func (h *ShareHandler) ApproveFile(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	access, ok := h.resolve(w, r, hash)
+	if !ok {
+		return
+	}
+	share := access.Share
+
+	if share.IsExpired() || !middleware.ShareUnlocked(r, h.store, share) {
+		http.Redirect(w, r, "/share/"+hash, http.StatusSeeOther)
+		return
+	}
+
+	if !access.CanApprove() {
+		http.Error(w, "This link does not allow approving files", http.StatusForbidden)
+		return
+	}
+
+	username := middleware.VisitorName(r, access)
+	if username == "" {
+		http.Error(w, "Username not set", http.StatusUnauthorized)
+		return
+	}
+
+	file, err := h.fileService.GetByHash(r.Context(), chi.URLParam(r, "fileHash"))
+	if err != nil || file.ShareID != share.ID {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	action := "file.approve"
+	if r.FormValue("revoke") != "" {
+		action = "file.approval_revoke"
+		err = h.fileService.RevokeApproval(r.Context(), file.ID)
+	} else {
+		err = h.fileService.Approve(r.Context(), file.ID, username)
+	}
+	if err != nil {
+		http.Error(w, "Failed to update approval", http.StatusInternalServerError)
+		return
+	}
+
+	h.auditService.Record(r.Context(), visitorAuditEvent(r, access, action, auditTargetFile, file.ID, file.Filename))
+
 	http.Redirect(w, r, "/share/"+hash, http.StatusSeeOther)
 }
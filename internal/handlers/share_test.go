@@ -0,0 +1,90 @@
+package handlers_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestReviewLinkOfTrashedShare(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+
+	const token = "reviewer-token"
+	sum := sha256.Sum256([]byte(token))
+	if _, err := app.db.Exec(
+		"INSERT INTO reviewers (share_id, name, email, permission, token_hash) VALUES (?, 'Reviewer', 'reviewer@example.com', 'comment', ?)",
+		share.ID, hex.EncodeToString(sum[:]),
+	); err != nil {
+		t.Fatalf("create reviewer: %v", err)
+	}
+
+	expectRedirect(t, app.newBrowser().get("/review/"+token), "/share/"+share.Hash)
+
+	// The link stops working while the share is in the trash
+	app.api("DELETE", "/api/v1/shares/"+itoa(share.ID), nil, nil)
+	expectStatus(t, app.newBrowser().get("/review/"+token), http.StatusNotFound)
+}
+
+func TestRevokingLinkCancelsSubscriptions(t *testing.T) {
+	app := newTestApp(t, nil)
+	share := app.createShare(nil)
+
+	admin := app.newBrowser()
+	admin.loginWithToken()
+	expectRedirect(t, admin.post("/admin/shares/"+itoa(share.ID)+"/links", url.Values{"label": {"Client"}, "permission": {"view"}}), "/admin/shares/"+itoa(share.ID))
+	var linkID int
+	var linkHash string
+	if err := app.db.QueryRow("SELECT id, hash FROM share_links WHERE share_id = ?", share.ID).Scan(&linkID, &linkHash); err != nil {
+		t.Fatalf("load link: %v", err)
+	}
+
+	visitor := app.newBrowser()
+	visitor.get("/share/" + linkHash)
+	expectStatus(t, visitor.post("/share/"+linkHash+"/subscribe", url.Values{"email": {"visitor@example.com"}}), http.StatusOK)
+	countSubscriptions := func() int {
+		var n int
+		app.db.QueryRow("SELECT COUNT(*) FROM subscriptions WHERE share_id = ?", share.ID).Scan(&n)
+		return n
+	}
+	if n := countSubscriptions(); n != 1 {
+		t.Fatalf("%d subscriptions, want 1", n)
+	}
+
+	expectRedirect(t, admin.post("/admin/shares/"+itoa(share.ID)+"/links/"+itoa(linkID)+"/revoke", nil), "/admin/shares/"+itoa(share.ID))
+	if n := countSubscriptions(); n != 0 {
+		t.Errorf("%d subscriptions left after revoking their link", n)
+	}
+}
+
+func TestCreateShareWithProtection(t *testing.T) {
+	app := newTestApp(t, nil)
+
+	admin := app.newBrowser()
+	admin.loginWithToken()
+	resp := admin.post("/admin/shares", url.Values{"name": {"Form"}, "password": {"correct horse battery"}, "expires_at": {"2099-01-01"}})
+	expectStatus(t, resp, http.StatusSeeOther)
+	fromAPI := app.createShare(map[string]interface{}{"password": "correct horse battery", "expires_at": "2099-01-01T00:00:00Z", "invite_only": true})
+
+	// The options are stored with the share, not in later statements
+	for name, query := range map[string]string{
+		"form": "SELECT hash, password_hash IS NOT NULL, expires_at IS NOT NULL, invite_only FROM shares WHERE name = 'Form'",
+		"api":  "SELECT hash, password_hash IS NOT NULL, expires_at IS NOT NULL, invite_only FROM shares WHERE id = " + itoa(fromAPI.ID),
+	} {
+		var hash string
+		var password, expiry, inviteOnly bool
+		if err := app.db.QueryRow(query).Scan(&hash, &password, &expiry, &inviteOnly); err != nil {
+			t.Fatalf("%s: load share: %v", name, err)
+		}
+		if !password || !expiry || inviteOnly != (name == "api") {
+			t.Errorf("%s: password %v, expiry %v, invite only %v", name, password, expiry, inviteOnly)
+		}
+		want := http.StatusUnauthorized
+		if inviteOnly {
+			want = http.StatusForbidden
+		}
+		expectStatus(t, app.newBrowser().get("/share/"+hash), want)
+	}
+}
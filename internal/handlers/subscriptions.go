@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"html/template"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+type SubscriptionHandler struct {
+	templates           *template.Template
+	shareService        *services.ShareService
+	fileService         *services.FileService
+	subscriptionService *services.SubscriptionService
+	reviewerService     *services.ReviewerService
+	store               *sessions.CookieStore
+}
+
+func NewSubscriptionHandler(templates *template.Template, shareService *services.ShareService, fileService *services.FileService, subscriptionService *services.SubscriptionService, reviewerService *services.ReviewerService, store *sessions.CookieStore) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		templates:           templates,
+		shareService:        shareService,
+		fileService:         fileService,
+		subscriptionService: subscriptionService,
+		reviewerService:     reviewerService,
+		store:               store,
+	}
+}
+
+func (h *SubscriptionHandler) Subscribe(w http.ResponseWriter, r *http.Request) {
+	hash := chi.URLParam(r, "hash")
+
+	access, err := h.shareService.Resolve(r.Context(), hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to load share", http.StatusInternalServerError)
+		return
+	}
+	middleware.ApplyReviewer(r, h.store, h.reviewerService, access)
+	share := access.Share
+
+	if share.IsExpired() || !access.CanView() || !middleware.ShareUnlocked(r, h.store, share) {
+		http.Redirect(w, r, "/share/"+hash, http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	// Optional file hash narrows the subscription to a single file
+	var file *database.File
+	if fileHash := r.FormValue("file"); fileHash != "" {
+		file, err = h.fileService.GetByHash(r.Context(), fileHash)
+		if err != nil || file.ShareID != share.ID {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	email, err := services.NormalizeEmail(r.FormValue("email"))
+	if err != nil {
+		http.Error(w, "A valid email is required", http.StatusBadRequest)
+		return
+	}
+
+	sub, err := h.subscriptionService.Subscribe(r.Context(), access, file, email)
+	if err != nil {
+		http.Error(w, "Failed to subscribe", http.StatusInternalServerError)
+		return
+	}
+
+	// Remember the address so it can be prefilled and skipped for own comments
+	session, _ := h.store.Get(r, "user-session")
+	session.Values["email"] = email
+	if err := session.Save(r, w); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
+	message := "We sent a confirmation link to " + email + ". Open it to start receiving notifications."
+	if sub.ConfirmedAt != nil {
+		message = "You are already subscribed with " + email + "."
+	}
+
+	h.render(w, r, "Check your inbox", message, "/share/"+hash, "")
+}
+
+func (h *SubscriptionHandler) Confirm(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.subscriptionService.Confirm(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, "Failed to confirm subscription", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "Subscription confirmed", "You will now receive notifications at "+sub.Email+".", h.shareURL(r.Context(), sub), "")
+}
+
+func (h *SubscriptionHandler) UnsubscribeForm(w http.ResponseWriter, r *http.Request) {
+	token := chi.URLParam(r, "token")
+
+	sub, err := h.subscriptionService.GetByUnsubscribeToken(r.Context(), token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			h.render(w, r, "Unsubscribed", "This subscription no longer exists.", "", "")
+			return
+		}
+		http.Error(w, "Failed to load subscription", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "Unsubscribe", "Stop sending notifications to "+sub.Email+"?", "", token)
+}
+
+// Unsubscribe also serves RFC 8058 one-click requests sent by mail clients.
+func (h *SubscriptionHandler) Unsubscribe(w http.ResponseWriter, r *http.Request) {
+	if err := h.subscriptionService.Unsubscribe(r.Context(), chi.URLParam(r, "token")); err != nil && err != sql.ErrNoRows {
+		http.Error(w, "Failed to unsubscribe", http.StatusInternalServerError)
+		return
+	}
+
+	h.render(w, r, "Unsubscribed", "You will no longer receive these notifications.", "", "")
+}
+
+func (h *SubscriptionHandler) shareURL(ctx context.Context, sub *database.Subscription) string {
+	if sub.AccessHash != nil {
+		return "/share/" + *sub.AccessHash
+	}
+
+	share, err := h.shareService.GetByID(ctx, sub.ShareID)
+	if err != nil {
+		return ""
+	}
+	return "/share/" + share.Hash
+}
+
+func (h *SubscriptionHandler) render(w http.ResponseWriter, r *http.Request, title, message, backURL, unsubscribeToken string) {
+	data := map[string]interface{}{
+		"Title":            title,
+		"Message":          message,
+		"BackURL":          backURL,
+		"UnsubscribeToken": unsubscribeToken,
+	}
+
+	render(w, r, h.templates, "subscription", data)
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+const apiKeyKey contextKey = "api_key"
+
+// APIAuth authenticates API requests with an "Authorization: Bearer" header
+// carrying either an API key or the admin token, which grants every scope.
+func APIAuth(adminToken string, apiKeyService *services.APIKeyService) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := BearerToken(r)
+			if !ok {
+				writeUnauthorized(w)
+				return
+			}
+
+			if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			apiKey, err := apiKeyService.Authenticate(r.Context(), token)
+			if err != nil {
+				writeUnauthorized(w)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), apiKeyKey, apiKey)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequireScope rejects API key requests lacking the given scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if apiKey := GetAPIKey(r); apiKey != nil && !apiKey.HasScope(scope) {
+				WriteAPIError(w, http.StatusForbidden, "insufficient_scope", "API key lacks the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// GetAPIKey returns the API key of the request, or nil when the admin token was used.
+func GetAPIKey(r *http.Request) *database.APIKey {
+	apiKey, _ := r.Context().Value(apiKeyKey).(*database.APIKey)
+	return apiKey
+}
+
+func BearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[7:])
+	return token, token != ""
+}
+
+func writeUnauthorized(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	WriteAPIError(w, http.StatusUnauthorized, "unauthorized", "Missing or invalid bearer token")
+}
+
+// WriteAPIError writes the error object shared by all JSON API responses.
+func WriteAPIError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"status":  status,
+			"code":    code,
+			"message": message,
+		},
+	})
+}
@@ -1,20 +1,93 @@
 package middleware
 
 import (
+	"context"
+	"net"
 	"net/http"
 
-	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/services"
 )
 
-func AdminAuth(adminToken string) func(http.Handler) http.Handler {
+// AdminSessionName is the cookie holding the admin session token.
+const AdminSessionName = "admin-session"
+
+const (
+	adminSessionKey contextKey = "admin_session"
+	adminKey        contextKey = "admin"
+)
+
+// AdminAuth requires a valid admin session and redirects to the login page otherwise.
+func AdminAuth(store *sessions.CookieStore, adminSessionService *services.AdminSessionService, adminService *services.AdminService) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			token := chi.URLParam(r, "token")
-			if token != adminToken {
-				http.NotFound(w, r)
+			adminSession, admin := LoadAdmin(r, store, adminSessionService, adminService)
+			if adminSession == nil {
+				http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
 				return
 			}
-			next.ServeHTTP(w, r)
+
+			ctx := context.WithValue(r.Context(), adminSessionKey, adminSession)
+			ctx = context.WithValue(ctx, adminKey, admin)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// RequireOwner rejects admins without the owner role.
+func RequireOwner(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if admin := GetAdmin(r); admin == nil || !admin.IsOwner() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LoadAdmin returns the admin session referenced by the request cookie and
+// the signed-in admin, or nils if there is no valid session.
+func LoadAdmin(r *http.Request, store *sessions.CookieStore, adminSessionService *services.AdminSessionService, adminService *services.AdminService) (*database.AdminSession, *database.Admin) {
+	session, _ := store.Get(r, AdminSessionName)
+	token, _ := session.Values["token"].(string)
+	if token == "" {
+		return nil, nil
+	}
+
+	adminSession, err := adminSessionService.Authenticate(r.Context(), token)
+	if err != nil {
+		return nil, nil
+	}
+
+	if adminSession.AdminID == nil {
+		admin := services.TokenAdmin
+		return adminSession, &admin
+	}
+
+	admin, err := adminService.GetByID(r.Context(), *adminSession.AdminID)
+	if err != nil {
+		return nil, nil
+	}
+	return adminSession, admin
+}
+
+func GetAdminSession(r *http.Request) *database.AdminSession {
+	adminSession, _ := r.Context().Value(adminSessionKey).(*database.AdminSession)
+	return adminSession
+}
+
+// GetAdmin returns the signed-in admin, or nil outside the admin panel.
+func GetAdmin(r *http.Request) *database.Admin {
+	admin, _ := r.Context().Value(adminKey).(*database.Admin)
+	return admin
+}
+
+// ClientIP returns the address of the client that sent the request.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
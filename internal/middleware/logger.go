@@ -3,9 +3,17 @@ package middleware
 import (
 	"log"
 	"net/http"
+	"strings"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
+// secretURLParams are route parameters carrying credentials, such as the
+// tokens of review, confirmation and unsubscribe links. They are masked in the
+// request log.
+var secretURLParams = map[string]bool{"token": true}
+
 type responseWriter struct {
 	http.ResponseWriter
 	status int
@@ -23,6 +31,27 @@ func Logger(next http.Handler) http.Handler {
 		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
 		next.ServeHTTP(rw, r)
 
-		log.Printf("%s %s %d %v", r.Method, r.URL.Path, rw.status, time.Since(start))
+		log.Printf("%s %s %d %v", r.Method, logPath(r), rw.status, time.Since(start))
 	})
 }
+
+// logPath returns the request path with the values of secret route parameters
+// replaced. The parameters are known once the router matched the request,
+// which matches the escaped path if there is one.
+func logPath(r *http.Request) string {
+	path := r.URL.Path
+	if r.URL.RawPath != "" {
+		path = r.URL.RawPath
+	}
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return path
+	}
+
+	for i, key := range rctx.URLParams.Keys {
+		if secretURLParams[key] && rctx.URLParams.Values[i] != "" {
+			path = strings.Replace(path, "/"+rctx.URLParams.Values[i], "/[redacted]", 1)
+		}
+	}
+	return path
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies replaces the remote address of requests from trusted proxies
+// with the client address from the X-Forwarded-For or X-Real-IP header. The
+// X-Forwarded-For chain is read from the right and trusted proxies in it are
+// skipped, so clients cannot spoof their address by sending the header themselves.
+func TrustedProxies(proxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if len(proxies) == 0 {
+			return next
+		}
+
+		trusted := func(addr string) bool {
+			ip, err := netip.ParseAddr(strings.TrimSpace(addr))
+			if err != nil {
+				return false
+			}
+			for _, prefix := range proxies {
+				if prefix.Contains(ip.Unmap()) {
+					return true
+				}
+			}
+			return false
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, port, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil || !trusted(host) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			client := ""
+			if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+				chain := strings.Split(strings.Join(forwarded, ","), ",")
+				for i := len(chain) - 1; i >= 0; i-- {
+					addr := strings.TrimSpace(chain[i])
+					if _, err := netip.ParseAddr(addr); err != nil {
+						break
+					}
+					client = addr
+					if !trusted(addr) {
+						break
+					}
+				}
+			} else if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+				if _, err := netip.ParseAddr(realIP); err == nil {
+					client = realIP
+				}
+			}
+
+			if client != "" {
+				r.RemoteAddr = net.JoinHostPort(client, port)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter keeps a token bucket per client key. Once the capacity is
+// reached, the least recently used keys are evicted, which resets them.
+type RateLimiter struct {
+	limit    rate.Limit
+	burst    int
+	capacity int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type rateLimiterEntry struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+// NewRateLimiter allows each client the given number of requests per period,
+// all of which may be used at once. It returns nil, which disables limiting,
+// if requests is zero.
+func NewRateLimiter(requests int, period time.Duration, capacity int) *RateLimiter {
+	if requests <= 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		limit:    rate.Limit(float64(requests) / period.Seconds()),
+		burst:    requests,
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+// rateLimitResult describes the quota of a client after a request.
+type rateLimitResult struct {
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+// allow takes a token from the buckets of all keys, or from none of them if
+// one is empty, so a client is limited by whichever of its keys is exhausted.
+func (l *RateLimiter) allow(keys []string, now time.Time) rateLimitResult {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limiters := make([]*rate.Limiter, 0, len(keys))
+	result := rateLimitResult{allowed: true}
+	for _, key := range keys {
+		limiter := l.get(key)
+		limiters = append(limiters, limiter)
+
+		if tokens := limiter.TokensAt(now); tokens < 1 {
+			result.allowed = false
+			if wait := l.duration(1 - tokens); wait > result.retryAfter {
+				result.retryAfter = wait
+			}
+		}
+	}
+
+	result.remaining = l.burst
+	for _, limiter := range limiters {
+		if result.allowed {
+			limiter.AllowN(now, 1)
+		}
+
+		tokens := limiter.TokensAt(now)
+		if remaining := int(math.Max(0, math.Floor(tokens))); remaining < result.remaining {
+			result.remaining = remaining
+		}
+		if reset := l.duration(float64(l.burst) - tokens); reset > result.reset {
+			result.reset = reset
+		}
+	}
+
+	return result
+}
+
+// get returns the limiter of a key and marks it as recently used.
+func (l *RateLimiter) get(key string) *rate.Limiter {
+	if element, ok := l.entries[key]; ok {
+		l.order.MoveToFront(element)
+		return element.Value.(*rateLimiterEntry).limiter
+	}
+
+	if l.order.Len() >= l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.entries, oldest.Value.(*rateLimiterEntry).key)
+	}
+
+	limiter := rate.NewLimiter(l.limit, l.burst)
+	l.entries[key] = l.order.PushFront(&rateLimiterEntry{key: key, limiter: limiter})
+	return limiter
+}
+
+// duration returns the time it takes to refill the given number of tokens.
+func (l *RateLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / float64(l.limit) * float64(time.Second))
+}
+
+// RateLimit rejects requests of clients exceeding the limiter's policy with
+// 429 Too Many Requests. A nil limiter lets every request through.
+func RateLimit(limiter *RateLimiter) func(http.Handler) http.Handler {
+	return rateLimit(limiter, clientKeys, rejectRateLimited)
+}
+
+// ShareRateLimit is RateLimit counting the requests of a client separately
+// for every share, identified by the hash URL parameter.
+func ShareRateLimit(limiter *RateLimiter) func(http.Handler) http.Handler {
+	return rateLimit(limiter, func(r *http.Request) []string {
+		return []string{"ip:" + ClientIP(r) + ":share:" + chi.URLParam(r, "hash")}
+	}, rejectRateLimited)
+}
+
+// APIRateLimit is RateLimit with a JSON error response.
+func APIRateLimit(limiter *RateLimiter) func(http.Handler) http.Handler {
+	return rateLimit(limiter, clientKeys, func(w http.ResponseWriter) {
+		WriteAPIError(w, http.StatusTooManyRequests, "rate_limited", "Rate limit exceeded, please try again later")
+	})
+}
+
+func rejectRateLimited(w http.ResponseWriter) {
+	http.Error(w, "Rate limit exceeded, please try again later", http.StatusTooManyRequests)
+}
+
+// clientKeys identifies clients by IP address and, for API requests, by
+// their key. Cookies are not used since clients can drop them at will.
+func clientKeys(r *http.Request) []string {
+	keys := []string{"ip:" + ClientIP(r)}
+	if apiKey := GetAPIKey(r); apiKey != nil {
+		keys = append(keys, "api-key:"+strconv.Itoa(apiKey.ID))
+	}
+	return keys
+}
+
+// rateLimit limits the clients identified by keys and sets the RateLimit-*
+// headers.
+func rateLimit(limiter *RateLimiter, keys func(r *http.Request) []string, reject func(w http.ResponseWriter)) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			result := limiter.allow(keys(r), time.Now())
+
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(limiter.burst))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.reset)))
+
+			if !result.allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.retryAfter)))
+				reject(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
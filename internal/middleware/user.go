@@ -2,28 +2,39 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/sessions"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/services"
 )
 
 type contextKey string
 
-const usernameKey contextKey = "username"
+const (
+	usernameKey contextKey = "username"
+	emailKey    contextKey = "email"
+)
 
 func UserSession(store *sessions.CookieStore) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			session, _ := store.Get(r, "user-session")
+			ctx := r.Context()
 
 			username, ok := session.Values["username"].(string)
 			if ok && username != "" {
-				ctx := context.WithValue(r.Context(), usernameKey, username)
-				next.ServeHTTP(w, r.WithContext(ctx))
-				return
+				ctx = context.WithValue(ctx, usernameKey, username)
+			}
+
+			email, ok := session.Values["email"].(string)
+			if ok && email != "" {
+				ctx = context.WithValue(ctx, emailKey, email)
 			}
 
-			next.ServeHTTP(w, r)
+			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
@@ -32,3 +43,90 @@ func GetUsername(r *http.Request) string {
 	username, _ := r.Context().Value(usernameKey).(string)
 	return username
 }
+
+func GetEmail(r *http.Request) string {
+	email, _ := r.Context().Value(emailKey).(string)
+	return email
+}
+
+// ShareUnlocked reports whether the visitor may access the share, which is
+// always the case for shares without a password.
+func ShareUnlocked(r *http.Request, store *sessions.CookieStore, share *database.Share) bool {
+	if !share.HasPassword() {
+		return true
+	}
+
+	session, _ := store.Get(r, "user-session")
+	fingerprint, _ := session.Values["unlocked:"+share.Hash].(string)
+	return fingerprint != "" && subtle.ConstantTimeCompare([]byte(fingerprint), []byte(services.UnlockFingerprint(share))) == 1
+}
+
+// UnlockShare remembers in the user session that the visitor entered the
+// password of the share.
+func UnlockShare(w http.ResponseWriter, r *http.Request, store *sessions.CookieStore, share *database.Share) error {
+	session, _ := store.Get(r, "user-session")
+	session.Values["unlocked:"+share.Hash] = services.UnlockFingerprint(share)
+	return session.Save(r, w)
+}
+
+// SignInReviewer binds the user session to the reviewer of a magic link.
+// Visitors can be signed in as one reviewer per share.
+func SignInReviewer(w http.ResponseWriter, r *http.Request, store *sessions.CookieStore, reviewer *database.Reviewer) error {
+	session, _ := store.Get(r, "user-session")
+	session.Values[reviewerSessionKey(reviewer.ShareID)] = reviewer.ID
+	return session.Save(r, w)
+}
+
+// ApplyReviewer sets the signed-in reviewer of the share on the access, whose
+// invitation then grants the permission. Without a reviewer, invite-only
+// shares grant no permission. Revoked reviewers are signed out on their next request.
+func ApplyReviewer(r *http.Request, store *sessions.CookieStore, reviewerService *services.ReviewerService, access *database.ShareAccess) {
+	session, _ := store.Get(r, "user-session")
+	if id, ok := session.Values[reviewerSessionKey(access.Share.ID)].(int); ok {
+		reviewer, err := reviewerService.GetByID(r.Context(), id)
+		if err == nil && reviewer.ShareID == access.Share.ID && reviewer.RevokedAt == nil {
+			access.Reviewer = reviewer
+			access.Permission = reviewer.Permission
+			return
+		}
+	}
+
+	if access.Share.InviteOnly {
+		access.Permission = ""
+	}
+}
+
+// VisitorName returns the name comments and approvals are made under, which
+// is the verified name for reviewers.
+func VisitorName(r *http.Request, access *database.ShareAccess) string {
+	if access.Reviewer != nil {
+		return access.Reviewer.Name
+	}
+	return GetUsername(r)
+}
+
+func reviewerSessionKey(shareID int) string {
+	return "reviewer:" + strconv.Itoa(shareID)
+}
+
+// RememberShareAccess stores the hash the visitor opened the share with, which
+// authorizes file downloads when files are protected.
+func RememberShareAccess(w http.ResponseWriter, r *http.Request, store *sessions.CookieStore, access *database.ShareAccess) error {
+	session, _ := store.Get(r, "user-session")
+	if session.Values[shareAccessSessionKey(access.Share.ID)] == access.Hash {
+		return nil
+	}
+	session.Values[shareAccessSessionKey(access.Share.ID)] = access.Hash
+	return session.Save(r, w)
+}
+
+// SharedAccessHash returns the hash the visitor last opened the share with.
+func SharedAccessHash(r *http.Request, store *sessions.CookieStore, shareID int) string {
+	session, _ := store.Get(r, "user-session")
+	hash, _ := session.Values[shareAccessSessionKey(shareID)].(string)
+	return hash
+}
+
+func shareAccessSessionKey(shareID int) string {
+	return "access:" + strconv.Itoa(shareID)
+}
@@ -0,0 +1,183 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const commentColumns = "c.id, c.file_id, c.reviewer_id, c.username, c.content, c.created_at, c.deleted_at"
+
+// commentFields returns the scan destinations of commentColumns.
+func commentFields(c *database.Comment) []interface{} {
+	return []interface{}{&c.ID, &c.FileID, &c.ReviewerID, &c.Username, &c.Content, &c.CreatedAt, &c.DeletedAt}
+}
+
+// CommentRepository stores the comments on files.
+type CommentRepository struct {
+	stmts *Statements
+}
+
+func NewCommentRepository(stmts *Statements) *CommentRepository {
+	return &CommentRepository{stmts: stmts}
+}
+
+// Create stores a comment and returns its id. reviewerID is nil for comments
+// of visitors who were not invited.
+func (r *CommentRepository) Create(ctx context.Context, fileID int, username, content string, reviewerID *int) (int, error) {
+	return r.stmts.insert(ctx,
+		"INSERT INTO comments (file_id, reviewer_id, username, content) VALUES (?, ?, ?, ?)",
+		fileID, reviewerID, username, content,
+	)
+}
+
+// GetByID returns a comment that is not in the trash.
+func (r *CommentRepository) GetByID(ctx context.Context, id int) (*database.Comment, error) {
+	return r.getBy(ctx, "c.id = ? AND c.deleted_at IS NULL", id)
+}
+
+// GetDeleted returns a comment from the trash.
+func (r *CommentRepository) GetDeleted(ctx context.Context, id int) (*database.Comment, error) {
+	return r.getBy(ctx, "c.id = ? AND c.deleted_at IS NOT NULL", id)
+}
+
+func (r *CommentRepository) getBy(ctx context.Context, where string, id int) (*database.Comment, error) {
+	comment := &database.Comment{}
+	err := r.stmts.queryRow(ctx,
+		"SELECT "+commentColumns+" FROM comments c WHERE "+where,
+		[]interface{}{id}, commentFields(comment)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return comment, nil
+}
+
+// ListByFileID returns the comments of a file that are not in the trash,
+// oldest first.
+func (r *CommentRepository) ListByFileID(ctx context.Context, fileID int) ([]database.Comment, error) {
+	return r.listByFileID(ctx, fileID, "")
+}
+
+// ListPage returns a window of a file's comments ordered like ListByFileID.
+func (r *CommentRepository) ListPage(ctx context.Context, fileID, limit, offset int) ([]database.Comment, error) {
+	return r.listByFileID(ctx, fileID, "LIMIT ? OFFSET ?", limit, offset)
+}
+
+func (r *CommentRepository) CountByFileID(ctx context.Context, fileID int) (int, error) {
+	var count int
+	err := r.stmts.queryRow(ctx, "SELECT COUNT(*) FROM comments WHERE file_id = ? AND deleted_at IS NULL", []interface{}{fileID}, &count)
+	return count, err
+}
+
+func (r *CommentRepository) listByFileID(ctx context.Context, fileID int, suffix string, args ...interface{}) ([]database.Comment, error) {
+	var comments []database.Comment
+	err := r.stmts.query(ctx,
+		"SELECT "+commentColumns+" FROM comments c WHERE c.file_id = ? AND c.deleted_at IS NULL ORDER BY c.created_at ASC, c.id ASC "+suffix,
+		append([]interface{}{fileID}, args...), func(rows *sql.Rows) error {
+			var c database.Comment
+			if err := rows.Scan(commentFields(&c)...); err != nil {
+				return err
+			}
+			comments = append(comments, c)
+			return nil
+		},
+	)
+	return comments, err
+}
+
+// ListBefore returns up to limit comments of a file that were written before
+// the given comment, ordered like ListByFileID.
+func (r *CommentRepository) ListBefore(ctx context.Context, fileID, beforeID, limit int) ([]database.Comment, error) {
+	var comments []database.Comment
+	err := r.stmts.query(ctx, `
+		SELECT `+commentColumns+` FROM comments c
+		WHERE c.file_id = ? AND c.deleted_at IS NULL
+			AND (c.created_at, c.id) < (SELECT created_at, id FROM comments WHERE id = ?)
+		ORDER BY c.created_at DESC, c.id DESC
+		LIMIT ?`, []interface{}{fileID, beforeID, limit}, func(rows *sql.Rows) error {
+		var c database.Comment
+		if err := rows.Scan(commentFields(&c)...); err != nil {
+			return err
+		}
+		comments = append(comments, c)
+		return nil
+	})
+
+	// Selected newest first to apply the limit
+	for i, j := 0, len(comments)-1; i < j; i, j = i+1, j-1 {
+		comments[i], comments[j] = comments[j], comments[i]
+	}
+	return comments, err
+}
+
+// ListRecentByShareID returns the most recent comments of every file of a
+// share in a single query, at most perFile per file and ordered like
+// ListByFileID, together with the number of comments per file.
+func (r *CommentRepository) ListRecentByShareID(ctx context.Context, shareID, perFile int) (map[int][]database.Comment, map[int]int, error) {
+	comments := make(map[int][]database.Comment)
+	counts := make(map[int]int)
+	err := r.stmts.query(ctx, `
+		SELECT `+commentColumns+`, c.total FROM (
+			SELECT c.*,
+				ROW_NUMBER() OVER (PARTITION BY c.file_id ORDER BY c.created_at DESC, c.id DESC) AS position,
+				COUNT(*) OVER (PARTITION BY c.file_id) AS total
+			FROM comments c
+			WHERE c.file_id IN (SELECT id FROM files WHERE share_id = ? AND deleted_at IS NULL)
+				AND c.deleted_at IS NULL
+		) c
+		WHERE c.position <= ?
+		ORDER BY c.file_id, c.created_at ASC, c.id ASC`, []interface{}{shareID, perFile}, func(rows *sql.Rows) error {
+		var c database.Comment
+		var total int
+		if err := rows.Scan(append(commentFields(&c), &total)...); err != nil {
+			return err
+		}
+		comments[c.FileID] = append(comments[c.FileID], c)
+		counts[c.FileID] = total
+		return nil
+	})
+	return comments, counts, err
+}
+
+// ListDeleted returns the comments in the trash whose file and share are not
+// deleted.
+func (r *CommentRepository) ListDeleted(ctx context.Context) ([]database.TrashedComment, error) {
+	var comments []database.TrashedComment
+	err := r.stmts.query(ctx, `
+		SELECT `+commentColumns+`, f.filename, `+shareColumns+`
+		FROM comments c
+		JOIN files f ON f.id = c.file_id
+		JOIN shares s ON s.id = f.share_id
+		WHERE c.deleted_at IS NOT NULL AND f.deleted_at IS NULL AND s.deleted_at IS NULL
+		ORDER BY c.deleted_at DESC, c.id DESC`, nil, func(rows *sql.Rows) error {
+		var c database.TrashedComment
+		dest := append(commentFields(&c.Comment), &c.Filename)
+		if err := rows.Scan(append(dest, shareFields(&c.Share)...)...); err != nil {
+			return err
+		}
+		comments = append(comments, c)
+		return nil
+	})
+	return comments, err
+}
+
+// SetDeleted moves the comment to the trash or restores it. sql.ErrNoRows is
+// returned if it is not in the expected state.
+func (r *CommentRepository) SetDeleted(ctx context.Context, id int, deleted bool) error {
+	return r.stmts.setDeleted(ctx, "comments", id, deleted)
+}
+
+// DeleteTrashed permanently removes a comment from the trash. sql.ErrNoRows
+// is returned if it is not in the trash.
+func (r *CommentRepository) DeleteTrashed(ctx context.Context, id int) error {
+	return r.stmts.execOne(ctx, "DELETE FROM comments WHERE id = ? AND deleted_at IS NOT NULL", id)
+}
+
+// DeleteTrashedBefore permanently removes the comments moved to the trash
+// before the given time and returns how many were removed.
+func (r *CommentRepository) DeleteTrashedBefore(ctx context.Context, before time.Time) (int, error) {
+	return r.stmts.exec(ctx, "DELETE FROM comments WHERE deleted_at IS NOT NULL AND deleted_at < ?", before.UTC())
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const fileColumns = "f.id, f.share_id, f.hash, f.filename, f.storage_path, f.mime_type, f.size_bytes, f.uploaded_at, f.approved_at, f.approved_by, f.scan_status, f.scan_signature, f.scanned_at, f.original_path, f.deleted_at"
+
+// fileFields returns the scan destinations of fileColumns.
+func fileFields(f *database.File) []interface{} {
+	return []interface{}{&f.ID, &f.ShareID, &f.Hash, &f.Filename, &f.StoragePath, &f.MimeType, &f.SizeBytes, &f.UploadedAt, &f.ApprovedAt, &f.ApprovedBy, &f.ScanStatus, &f.ScanSignature, &f.ScannedAt, &f.OriginalPath, &f.DeletedAt}
+}
+
+// FileRepository stores the uploaded files of shares.
+type FileRepository struct {
+	stmts *Statements
+}
+
+func NewFileRepository(stmts *Statements) *FileRepository {
+	return &FileRepository{stmts: stmts}
+}
+
+// Create stores a new file and returns its id. Only the fields known at
+// upload time are stored.
+func (r *FileRepository) Create(ctx context.Context, f *database.File) (int, error) {
+	return r.stmts.insert(ctx,
+		"INSERT INTO files (share_id, hash, filename, storage_path, mime_type, size_bytes, scan_status, original_path) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		f.ShareID, f.Hash, f.Filename, f.StoragePath, f.MimeType, f.SizeBytes, f.ScanStatus, f.OriginalPath,
+	)
+}
+
+// GetByID returns a file that is not in the trash.
+func (r *FileRepository) GetByID(ctx context.Context, id int) (*database.File, error) {
+	return r.getBy(ctx, "f.id = ? AND f.deleted_at IS NULL", id)
+}
+
+func (r *FileRepository) GetByHash(ctx context.Context, hash string) (*database.File, error) {
+	return r.getBy(ctx, "f.hash = ? AND f.deleted_at IS NULL", hash)
+}
+
+// GetDeleted returns a file from the trash.
+func (r *FileRepository) GetDeleted(ctx context.Context, id int) (*database.File, error) {
+	return r.getBy(ctx, "f.id = ? AND f.deleted_at IS NOT NULL", id)
+}
+
+func (r *FileRepository) getBy(ctx context.Context, where string, value interface{}) (*database.File, error) {
+	file := &database.File{}
+	err := r.stmts.queryRow(ctx,
+		"SELECT "+fileColumns+" FROM files f WHERE "+where,
+		[]interface{}{value}, fileFields(file)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// ListByShareID returns the files of a share that are not in the trash,
+// newest first.
+func (r *FileRepository) ListByShareID(ctx context.Context, shareID int) ([]database.File, error) {
+	return r.listByShareID(ctx, shareID, "")
+}
+
+// ListPage returns a window of a share's files ordered like ListByShareID.
+func (r *FileRepository) ListPage(ctx context.Context, shareID, limit, offset int) ([]database.File, error) {
+	return r.listByShareID(ctx, shareID, "LIMIT ? OFFSET ?", limit, offset)
+}
+
+func (r *FileRepository) CountByShareID(ctx context.Context, shareID int) (int, error) {
+	var count int
+	err := r.stmts.queryRow(ctx, "SELECT COUNT(*) FROM files WHERE share_id = ? AND deleted_at IS NULL", []interface{}{shareID}, &count)
+	return count, err
+}
+
+func (r *FileRepository) listByShareID(ctx context.Context, shareID int, suffix string, args ...interface{}) ([]database.File, error) {
+	return r.listFiles(ctx,
+		"SELECT "+fileColumns+" FROM files f WHERE f.share_id = ? AND f.deleted_at IS NULL ORDER BY f.uploaded_at DESC, f.id DESC "+suffix,
+		append([]interface{}{shareID}, args...)...,
+	)
+}
+
+// ListAllByShareID returns all files of a share, including those in the
+// trash.
+func (r *FileRepository) ListAllByShareID(ctx context.Context, shareID int) ([]database.File, error) {
+	return r.listFiles(ctx, "SELECT "+fileColumns+" FROM files f WHERE f.share_id = ?", shareID)
+}
+
+// ListDeletedBefore returns the files moved to the trash before the given
+// time, regardless of whether their share is deleted.
+func (r *FileRepository) ListDeletedBefore(ctx context.Context, before time.Time) ([]database.File, error) {
+	return r.listFiles(ctx,
+		"SELECT "+fileColumns+" FROM files f WHERE f.deleted_at IS NOT NULL AND f.deleted_at < ? ORDER BY f.deleted_at ASC",
+		before.UTC(),
+	)
+}
+
+func (r *FileRepository) listFiles(ctx context.Context, query string, args ...interface{}) ([]database.File, error) {
+	var files []database.File
+	err := r.stmts.query(ctx, query, args, func(rows *sql.Rows) error {
+		var f database.File
+		if err := rows.Scan(fileFields(&f)...); err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// ListDeleted returns the files in the trash whose share is not deleted as
+// well.
+func (r *FileRepository) ListDeleted(ctx context.Context) ([]database.TrashedFile, error) {
+	var files []database.TrashedFile
+	err := r.stmts.query(ctx, `
+		SELECT `+fileColumns+`, `+shareColumns+`
+		FROM files f
+		JOIN shares s ON s.id = f.share_id
+		WHERE f.deleted_at IS NOT NULL AND s.deleted_at IS NULL
+		ORDER BY f.deleted_at DESC, f.id DESC`, nil, func(rows *sql.Rows) error {
+		var f database.TrashedFile
+		if err := rows.Scan(append(fileFields(&f.File), shareFields(&f.Share)...)...); err != nil {
+			return err
+		}
+		files = append(files, f)
+		return nil
+	})
+	return files, err
+}
+
+// MarkClean records that the malware scan found nothing.
+func (r *FileRepository) MarkClean(ctx context.Context, id int) error {
+	_, err := r.stmts.exec(ctx,
+		"UPDATE files SET scan_status = ?, scan_signature = NULL, scanned_at = ? WHERE id = ?",
+		database.ScanStatusClean, time.Now().UTC(), id,
+	)
+	return err
+}
+
+// MarkInfected records the malware found in the file and the new locations
+// of the file and its original in quarantine.
+func (r *FileRepository) MarkInfected(ctx context.Context, id int, signature, storagePath string, originalPath *string) error {
+	_, err := r.stmts.exec(ctx,
+		"UPDATE files SET scan_status = ?, scan_signature = ?, scanned_at = ?, storage_path = ?, original_path = ? WHERE id = ?",
+		database.ScanStatusInfected, signature, time.Now().UTC(), storagePath, originalPath, id,
+	)
+	return err
+}
+
+// Approve marks the file as approved by the given visitor.
+func (r *FileRepository) Approve(ctx context.Context, id int, username string) error {
+	_, err := r.stmts.exec(ctx, "UPDATE files SET approved_at = ?, approved_by = ? WHERE id = ?", time.Now().UTC(), username, id)
+	return err
+}
+
+func (r *FileRepository) RevokeApproval(ctx context.Context, id int) error {
+	_, err := r.stmts.exec(ctx, "UPDATE files SET approved_at = NULL, approved_by = NULL WHERE id = ?", id)
+	return err
+}
+
+// SetDeleted moves the file to the trash or restores it. sql.ErrNoRows is
+// returned if it is not in the expected state.
+func (r *FileRepository) SetDeleted(ctx context.Context, id int, deleted bool) error {
+	return r.stmts.setDeleted(ctx, "files", id, deleted)
+}
+
+// Delete permanently removes the file with its comments. sql.ErrNoRows is
+// returned for unknown files.
+func (r *FileRepository) Delete(ctx context.Context, id int) error {
+	return r.stmts.execOne(ctx, "DELETE FROM files WHERE id = ?", id)
+}
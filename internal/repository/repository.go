@@ -0,0 +1,169 @@
+// Package repository runs the queries of shares, files and comments as
+// prepared statements. Every method takes the context of the request it
+// serves, so its queries are cancelled when the client goes away, and each
+// query is bounded by the query timeout.
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+// Statements prepares each query on first use and reuses the statement for
+// later calls. It is shared by the repositories.
+type Statements struct {
+	db      *database.DB
+	timeout time.Duration
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+}
+
+// NewStatements creates the statement cache. Queries taking longer than
+// timeout are cancelled, zero disables the timeout.
+func NewStatements(db *database.DB, timeout time.Duration) *Statements {
+	return &Statements{
+		db:      db,
+		timeout: timeout,
+		stmts:   make(map[string]*sql.Stmt),
+	}
+}
+
+// Close releases all prepared statements.
+func (s *Statements) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+	for query, stmt := range s.stmts {
+		if err := stmt.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.stmts, query)
+	}
+	return firstErr
+}
+
+func (s *Statements) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stmt, ok := s.stmts[query]; ok {
+		return stmt, nil
+	}
+	stmt, err := s.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	s.stmts[query] = stmt
+	return stmt, nil
+}
+
+func (s *Statements) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
+
+// exec runs a statement and returns the number of affected rows.
+func (s *Statements) exec(ctx context.Context, query string, args ...interface{}) (int, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := result.RowsAffected()
+	return int(rows), err
+}
+
+// execOne runs a statement that must affect a row, sql.ErrNoRows is returned
+// otherwise.
+func (s *Statements) execOne(ctx context.Context, query string, args ...interface{}) error {
+	rows, err := s.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// insert runs an INSERT statement and returns the id of the new row, using
+// RETURNING on PostgreSQL which has no last insert id.
+func (s *Statements) insert(ctx context.Context, query string, args ...interface{}) (int, error) {
+	if s.db.Dialect == database.Postgres {
+		var id int
+		err := s.queryRow(ctx, query+" RETURNING id", args, &id)
+		return id, err
+	}
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	result, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// queryRow scans the single row of a query into dest.
+func (s *Statements) queryRow(ctx context.Context, query string, args []interface{}, dest ...interface{}) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return err
+	}
+	return stmt.QueryRowContext(ctx, args...).Scan(dest...)
+}
+
+// query calls scan for every row of a query.
+func (s *Statements) query(ctx context.Context, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stmt, err := s.prepare(ctx, query)
+	if err != nil {
+		return err
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// setDeleted moves a row of a table with a deleted_at column to the trash or
+// restores it. sql.ErrNoRows is returned if it is not in the expected state.
+func (s *Statements) setDeleted(ctx context.Context, table string, id int, deleted bool) error {
+	if !deleted {
+		return s.execOne(ctx, "UPDATE "+table+" SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	}
+	return s.execOne(ctx, "UPDATE "+table+" SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", time.Now().UTC(), id)
+}
@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const shareColumns = "s.id, s.hash, s.name, s.description, s.owner_id, s.password_hash, s.expires_at, s.invite_only, s.created_at, s.updated_at, s.deleted_at"
+
+// shareFields returns the scan destinations of shareColumns.
+func shareFields(s *database.Share) []interface{} {
+	return []interface{}{&s.ID, &s.Hash, &s.Name, &s.Description, &s.OwnerID, &s.PasswordHash, &s.ExpiresAt, &s.InviteOnly, &s.CreatedAt, &s.UpdatedAt, &s.DeletedAt}
+}
+
+const shareLinkColumns = "id, share_id, hash, label, permission, revoked_at, created_at"
+
+// shareLinkFields returns the scan destinations of shareLinkColumns.
+func shareLinkFields(l *database.ShareLink) []interface{} {
+	return []interface{}{&l.ID, &l.ShareID, &l.Hash, &l.Label, &l.Permission, &l.RevokedAt, &l.CreatedAt}
+}
+
+// ShareRepository stores shares and their access links.
+type ShareRepository struct {
+	stmts *Statements
+}
+
+func NewShareRepository(stmts *Statements) *ShareRepository {
+	return &ShareRepository{stmts: stmts}
+}
+
+// Create stores a new share and returns its id.
+func (r *ShareRepository) Create(ctx context.Context, hash, name, description string, ownerID *int, passwordHash *string, expiresAt *time.Time, inviteOnly bool) (int, error) {
+	return r.stmts.insert(ctx,
+		"INSERT INTO shares (hash, name, description, owner_id, password_hash, expires_at, invite_only) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		hash, name, description, ownerID, passwordHash, expiresAt, inviteOnly,
+	)
+}
+
+// HashTaken reports whether a share or a share link uses the hash.
+func (r *ShareRepository) HashTaken(ctx context.Context, hash string) (bool, error) {
+	var exists bool
+	err := r.stmts.queryRow(ctx,
+		"SELECT EXISTS(SELECT 1 FROM shares WHERE hash = ?) OR EXISTS(SELECT 1 FROM share_links WHERE hash = ?)",
+		[]interface{}{hash, hash}, &exists,
+	)
+	return exists, err
+}
+
+// GetByID returns a share that is not in the trash.
+func (r *ShareRepository) GetByID(ctx context.Context, id int) (*database.Share, error) {
+	return r.getBy(ctx, "s.id = ? AND s.deleted_at IS NULL", id)
+}
+
+func (r *ShareRepository) GetByHash(ctx context.Context, hash string) (*database.Share, error) {
+	return r.getBy(ctx, "s.hash = ? AND s.deleted_at IS NULL", hash)
+}
+
+// GetDeleted returns a share from the trash.
+func (r *ShareRepository) GetDeleted(ctx context.Context, id int) (*database.Share, error) {
+	return r.getBy(ctx, "s.id = ? AND s.deleted_at IS NOT NULL", id)
+}
+
+func (r *ShareRepository) getBy(ctx context.Context, where string, value interface{}) (*database.Share, error) {
+	share := &database.Share{}
+	err := r.stmts.queryRow(ctx,
+		"SELECT "+shareColumns+" FROM shares s WHERE "+where,
+		[]interface{}{value}, shareFields(share)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return share, nil
+}
+
+// List returns the shares that are not in the trash, newest first.
+func (r *ShareRepository) List(ctx context.Context) ([]database.ShareWithStats, error) {
+	return r.list(ctx, "s.deleted_at IS NULL", "")
+}
+
+// ListOwnedBy returns the shares assigned to an admin.
+func (r *ShareRepository) ListOwnedBy(ctx context.Context, adminID int) ([]database.ShareWithStats, error) {
+	return r.list(ctx, "s.deleted_at IS NULL AND s.owner_id = ?", "", adminID)
+}
+
+// ListPage returns a window of shares ordered like List.
+func (r *ShareRepository) ListPage(ctx context.Context, limit, offset int) ([]database.ShareWithStats, error) {
+	return r.list(ctx, "s.deleted_at IS NULL", "LIMIT ? OFFSET ?", limit, offset)
+}
+
+// ListDeleted returns the shares in the trash.
+func (r *ShareRepository) ListDeleted(ctx context.Context) ([]database.ShareWithStats, error) {
+	return r.list(ctx, "s.deleted_at IS NOT NULL", "")
+}
+
+func (r *ShareRepository) Count(ctx context.Context) (int, error) {
+	var count int
+	err := r.stmts.queryRow(ctx, "SELECT COUNT(*) FROM shares WHERE deleted_at IS NULL", nil, &count)
+	return count, err
+}
+
+func (r *ShareRepository) list(ctx context.Context, where, suffix string, args ...interface{}) ([]database.ShareWithStats, error) {
+	var shares []database.ShareWithStats
+	err := r.stmts.query(ctx, `
+		SELECT
+			`+shareColumns+`,
+			COUNT(DISTINCT f.id) as file_count,
+			COUNT(DISTINCT c.id) as comment_count
+		FROM shares s
+		LEFT JOIN files f ON s.id = f.share_id AND f.deleted_at IS NULL
+		LEFT JOIN comments c ON f.id = c.file_id AND c.deleted_at IS NULL
+		WHERE `+where+`
+		GROUP BY s.id
+		ORDER BY s.created_at DESC, s.id DESC
+		`+suffix, args, func(rows *sql.Rows) error {
+		var s database.ShareWithStats
+		if err := rows.Scan(append(shareFields(&s.Share), &s.FileCount, &s.CommentCount)...); err != nil {
+			return err
+		}
+		shares = append(shares, s)
+		return nil
+	})
+	return shares, err
+}
+
+// ListExpiredBefore returns the shares that expired before the given time,
+// including shares in the trash.
+func (r *ShareRepository) ListExpiredBefore(ctx context.Context, before time.Time) ([]database.Share, error) {
+	return r.listShares(ctx, "s.expires_at IS NOT NULL AND s.expires_at < ? ORDER BY s.expires_at ASC", before.UTC())
+}
+
+// ListDeletedBefore returns the shares moved to the trash before the given time.
+func (r *ShareRepository) ListDeletedBefore(ctx context.Context, before time.Time) ([]database.Share, error) {
+	return r.listShares(ctx, "s.deleted_at IS NOT NULL AND s.deleted_at < ? ORDER BY s.deleted_at ASC", before.UTC())
+}
+
+func (r *ShareRepository) listShares(ctx context.Context, where string, args ...interface{}) ([]database.Share, error) {
+	var shares []database.Share
+	err := r.stmts.query(ctx, "SELECT "+shareColumns+" FROM shares s WHERE "+where, args, func(rows *sql.Rows) error {
+		var share database.Share
+		if err := rows.Scan(shareFields(&share)...); err != nil {
+			return err
+		}
+		shares = append(shares, share)
+		return nil
+	})
+	return shares, err
+}
+
+// Update changes the name and description of a share. sql.ErrNoRows is
+// returned for unknown shares.
+func (r *ShareRepository) Update(ctx context.Context, id int, name, description string) error {
+	return r.stmts.execOne(ctx,
+		"UPDATE shares SET name = ?, description = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		name, description, id,
+	)
+}
+
+// SetOwner assigns the share to an admin, or removes the owner if ownerID is nil.
+func (r *ShareRepository) SetOwner(ctx context.Context, id int, ownerID *int) error {
+	_, err := r.stmts.exec(ctx, "UPDATE shares SET owner_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", ownerID, id)
+	return err
+}
+
+// SetPasswordHash stores the password hash of a share, nil removes the
+// protection.
+func (r *ShareRepository) SetPasswordHash(ctx context.Context, id int, passwordHash *string) error {
+	_, err := r.stmts.exec(ctx, "UPDATE shares SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", passwordHash, id)
+	return err
+}
+
+// SetExpiry sets the time after which the share is no longer accessible, nil
+// removes the expiry.
+func (r *ShareRepository) SetExpiry(ctx context.Context, id int, expiresAt *time.Time) error {
+	_, err := r.stmts.exec(ctx, "UPDATE shares SET expires_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", expiresAt, id)
+	return err
+}
+
+func (r *ShareRepository) SetInviteOnly(ctx context.Context, id int, inviteOnly bool) error {
+	_, err := r.stmts.exec(ctx, "UPDATE shares SET invite_only = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", inviteOnly, id)
+	return err
+}
+
+// SetDeleted moves the share to the trash or restores it. sql.ErrNoRows is
+// returned if it is not in the expected state.
+func (r *ShareRepository) SetDeleted(ctx context.Context, id int, deleted bool) error {
+	return r.stmts.setDeleted(ctx, "shares", id, deleted)
+}
+
+// Delete permanently removes the share, its files and comments are removed
+// by the foreign keys. sql.ErrNoRows is returned for unknown shares.
+func (r *ShareRepository) Delete(ctx context.Context, id int) error {
+	return r.stmts.execOne(ctx, "DELETE FROM shares WHERE id = ?", id)
+}
+
+// CreateLink stores an access link of a share and returns its id.
+func (r *ShareRepository) CreateLink(ctx context.Context, shareID int, hash, label, permission string) (int, error) {
+	return r.stmts.insert(ctx,
+		"INSERT INTO share_links (share_id, hash, label, permission) VALUES (?, ?, ?, ?)",
+		shareID, hash, label, permission,
+	)
+}
+
+func (r *ShareRepository) GetLinkByID(ctx context.Context, id int) (*database.ShareLink, error) {
+	return r.getLinkBy(ctx, "id", id)
+}
+
+func (r *ShareRepository) GetLinkByHash(ctx context.Context, hash string) (*database.ShareLink, error) {
+	return r.getLinkBy(ctx, "hash", hash)
+}
+
+func (r *ShareRepository) getLinkBy(ctx context.Context, column string, value interface{}) (*database.ShareLink, error) {
+	link := &database.ShareLink{}
+	err := r.stmts.queryRow(ctx,
+		"SELECT "+shareLinkColumns+" FROM share_links WHERE "+column+" = ?",
+		[]interface{}{value}, shareLinkFields(link)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// ListLinks returns the access links of a share including revoked ones.
+func (r *ShareRepository) ListLinks(ctx context.Context, shareID int) ([]database.ShareLink, error) {
+	var links []database.ShareLink
+	err := r.stmts.query(ctx,
+		"SELECT "+shareLinkColumns+" FROM share_links WHERE share_id = ? ORDER BY created_at ASC, id ASC",
+		[]interface{}{shareID}, func(rows *sql.Rows) error {
+			var l database.ShareLink
+			if err := rows.Scan(shareLinkFields(&l)...); err != nil {
+				return err
+			}
+			links = append(links, l)
+			return nil
+		},
+	)
+	return links, err
+}
+
+// RevokeLink disables an access link of the share. sql.ErrNoRows is returned
+// for unknown and already revoked links.
+func (r *ShareRepository) RevokeLink(ctx context.Context, shareID, linkID int) error {
+	return r.stmts.execOne(ctx,
+		"UPDATE share_links SET revoked_at = ? WHERE id = ? AND share_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), linkID, shareID,
+	)
+}
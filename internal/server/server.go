@@ -0,0 +1,284 @@
+// Package server wires the services, handlers and routes of the application
+// into a single HTTP handler.
+package server
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"path/filepath"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/gorilla/sessions"
+	"github.com/romanzipp/feedback/internal/config"
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/handlers"
+	"github.com/romanzipp/feedback/internal/middleware"
+	"github.com/romanzipp/feedback/internal/repository"
+	"github.com/romanzipp/feedback/internal/services"
+)
+
+// rateLimitClients is the number of clients tracked per rate limit before the
+// least recently seen are forgotten.
+const rateLimitClients = 10000
+
+// Server holds the router together with the services the background jobs
+// need.
+type Server struct {
+	Router chi.Router
+
+	Shares *services.ShareService
+	Files  *services.FileService
+	Trash  *services.TrashService
+
+	stmts *repository.Statements
+}
+
+// New creates the services and handlers and registers all routes. Templates
+// and static files are loaded from webDir.
+func New(cfg *config.Config, db *database.DB, webDir string) (*Server, error) {
+	// Malware scanning of uploads is optional
+	var scanner services.Scanner
+	if cfg.ClamdAddress != "" {
+		var err error
+		scanner, err = services.NewClamdScanner(cfg.ClamdAddress, cfg.ClamdTimeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Share, file and comment queries are prepared once and reused
+	stmts := repository.NewStatements(db, cfg.QueryTimeout)
+
+	// Initialize services
+	shareService := services.NewShareService(repository.NewShareRepository(stmts))
+	fileService := services.NewFileService(repository.NewFileRepository(stmts), repository.NewCommentRepository(stmts), cfg.DataDir, scanner, cfg.StripMetadata, cfg.KeepOriginals)
+	mailService := services.NewMailService(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.SMTPFrom)
+	reviewerService := services.NewReviewerService(db, mailService, cfg.BaseURL)
+	subscriptionService := services.NewSubscriptionService(db, shareService, reviewerService, mailService, cfg.BaseURL)
+	urlSigner := services.NewURLSigner(cfg.SessionSecret, cfg.SignedURLTTL)
+	apiKeyService := services.NewAPIKeyService(db)
+	adminService := services.NewAdminService(db)
+	adminSessionService := services.NewAdminSessionService(db)
+	auditService := services.NewAuditService(db)
+	trashService := services.NewTrashService(shareService, fileService, cfg.TrashRetention)
+
+	// Single sign-on is optional
+	var oidcService *services.OIDCService
+	if cfg.OIDCIssuer != "" {
+		var err error
+		oidcService, err = services.NewOIDCService(context.Background(), services.OIDCOptions{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+			GroupsClaim:  cfg.OIDCGroupsClaim,
+			RoleMapping:  cfg.OIDCRoleMapping,
+		})
+		if err != nil {
+			stmts.Close()
+			return nil, err
+		}
+	}
+
+	// Initialize session store
+	store := sessions.NewCookieStore([]byte(cfg.SessionSecret))
+	store.Options = &sessions.Options{
+		Path:     "/",
+		MaxAge:   86400 * 30, // 30 days
+		HttpOnly: true,
+		Secure:   cfg.SecureCookies,
+		SameSite: http.SameSiteLaxMode,
+	}
+
+	// Load templates
+	funcMap := template.FuncMap{
+		"hasPrefix": func(s, prefix string) bool {
+			return len(s) >= len(prefix) && s[:len(prefix)] == prefix
+		},
+	}
+	templates := filepath.Join(webDir, "templates")
+
+	// Admin templates
+	adminTmpl := template.Must(template.New("").Funcs(funcMap).ParseGlob(filepath.Join(templates, "layouts/*.html")))
+	adminTmpl = template.Must(adminTmpl.ParseGlob(filepath.Join(templates, "admin/*.html")))
+
+	// Public templates
+	publicTmpl := template.Must(template.New("").Funcs(funcMap).ParseGlob(filepath.Join(templates, "layouts/*.html")))
+	publicTmpl = template.Must(publicTmpl.ParseGlob(filepath.Join(templates, "public/*.html")))
+
+	// Initialize handlers
+	adminHandler := handlers.NewAdminHandler(adminTmpl, shareService, fileService, subscriptionService, reviewerService, apiKeyService, adminService, adminSessionService, auditService, trashService, cfg.MaxUploadSize)
+	shareHandler := handlers.NewShareHandler(publicTmpl, shareService, fileService, subscriptionService, reviewerService, auditService, store)
+	fileHandler := handlers.NewFileHandler(shareService, fileService, adminService, adminSessionService, reviewerService, urlSigner, store, cfg.ProtectFiles)
+	commentHandler := handlers.NewCommentHandler(shareService, fileService, subscriptionService, reviewerService, auditService, store)
+	subscriptionHandler := handlers.NewSubscriptionHandler(publicTmpl, shareService, fileService, subscriptionService, reviewerService, store)
+	apiHandler := handlers.NewAPIHandler(shareService, fileService, subscriptionService, urlSigner, auditService, cfg.MaxUploadSize)
+	openAPIHandler := handlers.NewOpenAPIHandler()
+	authHandler := handlers.NewAuthHandler(adminTmpl, store, adminService, adminSessionService, oidcService, auditService, cfg.AdminToken)
+
+	// Setup router
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(middleware.TrustedProxies(cfg.TrustedProxies))
+	r.Use(middleware.Logger)
+	r.Use(middleware.SecurityHeaders)
+	r.Use(middleware.HSTS(cfg.HSTSMaxAge))
+
+	// Per-client rate limits, each keeping at most rateLimitClients clients
+	commentLimiter := middleware.NewRateLimiter(cfg.RateLimitComments.Requests, cfg.RateLimitComments.Period, rateLimitClients)
+	uploadLimiter := middleware.NewRateLimiter(cfg.RateLimitUploads.Requests, cfg.RateLimitUploads.Period, rateLimitClients)
+	subscribeLimiter := middleware.NewRateLimiter(cfg.RateLimitSubscribe.Requests, cfg.RateLimitSubscribe.Period, rateLimitClients)
+	loginLimiter := middleware.NewRateLimiter(cfg.RateLimitLogin.Requests, cfg.RateLimitLogin.Period, rateLimitClients)
+	unlockLimiter := middleware.NewRateLimiter(cfg.RateLimitUnlock.Requests, cfg.RateLimitUnlock.Period, rateLimitClients)
+
+	// Static files
+	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(http.Dir(filepath.Join(webDir, "static")))))
+
+	// Health check
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	})
+
+	// Public routes
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.UserSession(store))
+		r.Use(middleware.CSRF(cfg.SessionSecret, cfg.SecureCookies))
+
+		r.Get("/share/{hash}", shareHandler.View)
+		r.With(middleware.ShareRateLimit(unlockLimiter)).Post("/share/{hash}/unlock", shareHandler.Unlock)
+		r.Post("/share/{hash}/name", shareHandler.SetUsername)
+		r.With(middleware.RateLimit(subscribeLimiter)).Post("/share/{hash}/subscribe", subscriptionHandler.Subscribe)
+		r.Post("/share/{hash}/files/{fileHash}/approve", shareHandler.ApproveFile)
+		r.Get("/review/{token}", shareHandler.Review)
+		r.Get("/api/files/{hash}/comments", commentHandler.List)
+		r.With(middleware.RateLimit(commentLimiter)).Post("/api/files/{hash}/comments", commentHandler.Create)
+	})
+
+	// Subscription confirmation and unsubscribe links sent by email
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.CSRF(cfg.SessionSecret, cfg.SecureCookies))
+
+		r.Get("/subscriptions/confirm/{token}", subscriptionHandler.Confirm)
+		r.Get("/subscriptions/unsubscribe/{token}", subscriptionHandler.UnsubscribeForm)
+	})
+
+	// One-click unsubscribe (RFC 8058) is posted by mail clients without a CSRF
+	// token; the secret token in the URL authorizes it
+	r.Post("/subscriptions/unsubscribe/{token}", subscriptionHandler.Unsubscribe)
+
+	// File download (no auth needed if you have the hash, unless the share is
+	// protected or PROTECT_FILES is set)
+	r.With(middleware.FileSecurityHeaders).Get("/files/{hash}", fileHandler.Download)
+
+	// JSON API, authenticated with bearer tokens instead of cookies so it needs
+	// no CSRF protection
+	r.Route(handlers.APIBasePath, func(r chi.Router) {
+		r.NotFound(apiHandler.NotFound)
+		r.MethodNotAllowed(apiHandler.MethodNotAllowed)
+
+		r.Get("/openapi.json", openAPIHandler.Spec)
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.APIAuth(cfg.AdminToken, apiKeyService))
+
+			r.With(middleware.RequireScope(services.ScopeSharesRead)).Get("/shares", apiHandler.ListShares)
+			r.With(middleware.RequireScope(services.ScopeSharesWrite)).Post("/shares", apiHandler.CreateShare)
+			r.With(middleware.RequireScope(services.ScopeSharesRead)).Get("/shares/{id}", apiHandler.GetShare)
+			r.With(middleware.RequireScope(services.ScopeSharesWrite)).Patch("/shares/{id}", apiHandler.UpdateShare)
+			r.With(middleware.RequireScope(services.ScopeContentDelete)).Delete("/shares/{id}", apiHandler.DeleteShare)
+			r.With(middleware.RequireScope(services.ScopeSharesRead)).Get("/shares/{id}/files", apiHandler.ListFiles)
+			r.With(middleware.RequireScope(services.ScopeFilesUpload), middleware.APIRateLimit(uploadLimiter)).Post("/shares/{id}/files", apiHandler.UploadFiles)
+			r.With(middleware.RequireScope(services.ScopeSharesRead)).Get("/files/{id}", apiHandler.GetFile)
+			r.With(middleware.RequireScope(services.ScopeSharesRead)).Post("/files/{id}/signed-url", apiHandler.SignFileURL)
+			r.With(middleware.RequireScope(services.ScopeContentDelete)).Delete("/files/{id}", apiHandler.DeleteFile)
+			r.With(middleware.RequireScope(services.ScopeSharesRead)).Get("/files/{id}/comments", apiHandler.ListComments)
+			r.With(middleware.RequireScope(services.ScopeCommentsModerate)).Post("/files/{id}/comments", apiHandler.CreateComment)
+			r.With(middleware.RequireScope(services.ScopeCommentsModerate)).Delete("/comments/{id}", apiHandler.DeleteComment)
+		})
+	})
+
+	// Admin routes
+	r.Route("/admin", func(r chi.Router) {
+		r.Use(middleware.CSRF(cfg.SessionSecret, cfg.SecureCookies))
+
+		r.Get("/login", authHandler.LoginForm)
+		r.With(middleware.RateLimit(loginLimiter)).Post("/login", authHandler.Login)
+		r.With(middleware.RateLimit(loginLimiter)).Post("/login/2fa", authHandler.SecondFactor)
+		if oidcService != nil {
+			r.Get("/oidc/login", authHandler.OIDCLogin)
+			r.Get("/oidc/callback", authHandler.OIDCCallback)
+		}
+
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.AdminAuth(store, adminSessionService, adminService))
+
+			r.Get("/", adminHandler.Dashboard)
+			r.Post("/logout", authHandler.Logout)
+			r.Get("/sessions", authHandler.Sessions)
+			r.Post("/sessions/revoke-others", authHandler.RevokeOtherSessions)
+			r.Post("/sessions/{id}/revoke", authHandler.RevokeSession)
+			r.Get("/account", authHandler.Account)
+			r.Post("/account/2fa/setup", authHandler.SetupTOTP)
+			r.Get("/account/2fa/qr.png", authHandler.TOTPQRCode)
+			r.Post("/account/2fa/enable", authHandler.EnableTOTP)
+			r.Post("/account/2fa/disable", authHandler.DisableTOTP)
+			r.Post("/account/2fa/recovery-codes", authHandler.RegenerateRecoveryCodes)
+			r.Get("/shares/new", adminHandler.NewShareForm)
+			r.Post("/shares", adminHandler.CreateShare)
+			r.Get("/shares/{id}", adminHandler.ShareDetail)
+			r.With(middleware.RateLimit(uploadLimiter)).Post("/shares/{id}/upload", adminHandler.UploadFile)
+			r.Post("/shares/{id}/password", adminHandler.SetSharePassword)
+			r.Post("/shares/{id}/expiry", adminHandler.SetShareExpiry)
+			r.Post("/shares/{id}/links", adminHandler.CreateShareLink)
+			r.Post("/shares/{id}/links/{linkID}/revoke", adminHandler.RevokeShareLink)
+			r.Post("/shares/{id}/invite-only", adminHandler.SetShareInviteOnly)
+			r.Post("/shares/{id}/reviewers", adminHandler.InviteReviewer)
+			r.Post("/shares/{id}/reviewers/{reviewerID}/revoke", adminHandler.RevokeReviewer)
+			r.Post("/shares/{id}/delete", adminHandler.DeleteShare)
+			r.Post("/files/{id}/delete", adminHandler.DeleteFile)
+			r.Post("/files/{id}/scan", adminHandler.ScanFile)
+			r.Get("/trash", adminHandler.Trash)
+			r.Post("/trash/shares/{id}/restore", adminHandler.RestoreShare)
+			r.Post("/trash/shares/{id}/purge", adminHandler.PurgeShare)
+			r.Post("/trash/files/{id}/restore", adminHandler.RestoreFile)
+			r.Post("/trash/files/{id}/purge", adminHandler.PurgeFile)
+			r.Post("/trash/comments/{id}/restore", adminHandler.RestoreComment)
+			r.Post("/trash/comments/{id}/purge", adminHandler.PurgeComment)
+
+			// Owner only
+			r.Group(func(r chi.Router) {
+				r.Use(middleware.RequireOwner)
+
+				r.Post("/shares/{id}/owner", adminHandler.SetShareOwner)
+				r.Get("/admins", adminHandler.Admins)
+				r.Post("/admins", adminHandler.CreateAdmin)
+				r.Post("/admins/{id}/role", adminHandler.UpdateAdminRole)
+				r.Post("/admins/{id}/password", adminHandler.ResetAdminPassword)
+				r.Post("/admins/{id}/2fa/reset", adminHandler.ResetAdminTOTP)
+				r.Post("/admins/{id}/delete", adminHandler.DeleteAdmin)
+				r.Get("/api-keys", adminHandler.APIKeys)
+				r.Post("/api-keys", adminHandler.CreateAPIKey)
+				r.Post("/api-keys/{id}/delete", adminHandler.DeleteAPIKey)
+				r.Get("/audit", adminHandler.AuditLog)
+				r.Get("/audit.csv", adminHandler.ExportAuditLog)
+			})
+		})
+	})
+
+	return &Server{
+		Router: r,
+		Shares: shareService,
+		Files:  fileService,
+		Trash:  trashService,
+		stmts:  stmts,
+	}, nil
+}
+
+// Close releases the prepared statements.
+func (s *Server) Close() error {
+	return s.stmts.Close()
+}
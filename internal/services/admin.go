@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const minPasswordLength = 8
+
+// ErrInvalidCredentials is returned for unknown usernames and wrong passwords alike.
+var ErrInvalidCredentials = fmt.Errorf("invalid username or password")
+
+// dummyPasswordHash is compared for unknown usernames so they take as long as wrong passwords.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+// TokenAdmin is the identity of sessions signed in with the admin token.
+var TokenAdmin = database.Admin{ID: 0, Username: "admin token", Role: database.RoleOwner}
+
+// AdminRoles lists the assignable roles with a human readable description.
+var AdminRoles = []struct {
+	Name        string
+	Description string
+}{
+	{database.RoleOwner, "Full access to all shares, admins and API keys"},
+	{database.RoleEditor, "Create shares and manage own shares"},
+	{database.RoleViewer, "Read-only access to assigned shares"},
+}
+
+type AdminService struct {
+	db *database.DB
+}
+
+func NewAdminService(db *database.DB) *AdminService {
+	return &AdminService{db: db}
+}
+
+func (s *AdminService) Create(ctx context.Context, username, password, role string) (*database.Admin, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if !validRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM admins WHERE username = ?)", username).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, fmt.Errorf("username %q is already taken", username)
+	}
+
+	id, err := s.db.InsertContext(ctx,
+		"INSERT INTO admins (username, password_hash, role) VALUES (?, ?, ?)",
+		username, passwordHash, role,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByID(ctx, id)
+}
+
+// Authenticate checks a username and password pair.
+func (s *AdminService) Authenticate(ctx context.Context, username, password string) (*database.Admin, error) {
+	var id int
+	var passwordHash string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, password_hash FROM admins WHERE username = ?",
+		strings.TrimSpace(username),
+	).Scan(&id, &passwordHash)
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return s.GetByID(ctx, id)
+}
+
+// LoginOIDC returns the admin linked to the identity, creating the account on
+// first login. The role is synced from the identity on every login.
+func (s *AdminService) LoginOIDC(ctx context.Context, identity *OIDCIdentity) (*database.Admin, error) {
+	if !validRole(identity.Role) {
+		return nil, fmt.Errorf("unknown role %q", identity.Role)
+	}
+
+	admin, err := s.getBy(ctx, "oidc_subject", identity.Subject)
+	if err == nil {
+		if admin.Role != identity.Role {
+			if err := s.SetRole(ctx, admin.ID, identity.Role); err != nil {
+				return nil, err
+			}
+			admin.Role = identity.Role
+		}
+		return admin, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	username, err := s.freeUsername(ctx, identity.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	// SSO accounts have no password and cannot sign in with the login form
+	id, err := s.db.InsertContext(ctx,
+		"INSERT INTO admins (username, password_hash, role, oidc_subject) VALUES (?, '', ?, ?)",
+		username, identity.Role, identity.Subject,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetByID(ctx, id)
+}
+
+// freeUsername appends a counter to the username until it is not taken.
+func (s *AdminService) freeUsername(ctx context.Context, username string) (string, error) {
+	candidate := username
+	for i := 2; ; i++ {
+		var exists bool
+		if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM admins WHERE username = ?)", candidate).Scan(&exists); err != nil {
+			return "", err
+		}
+		if !exists {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", username, i)
+	}
+}
+
+func (s *AdminService) GetByID(ctx context.Context, id int) (*database.Admin, error) {
+	return s.getBy(ctx, "id", id)
+}
+
+func (s *AdminService) getBy(ctx context.Context, column string, value interface{}) (*database.Admin, error) {
+	admin := &database.Admin{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, username, role, oidc_subject, totp_enabled_at, created_at FROM admins WHERE "+column+" = ?",
+		value,
+	).Scan(&admin.ID, &admin.Username, &admin.Role, &admin.OIDCSubject, &admin.TOTPEnabledAt, &admin.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return admin, nil
+}
+
+func (s *AdminService) List(ctx context.Context) ([]database.Admin, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, username, role, oidc_subject, totp_enabled_at, created_at FROM admins ORDER BY username ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var admins []database.Admin
+	for rows.Next() {
+		var a database.Admin
+		if err := rows.Scan(&a.ID, &a.Username, &a.Role, &a.OIDCSubject, &a.TOTPEnabledAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		admins = append(admins, a)
+	}
+
+	return admins, nil
+}
+
+func (s *AdminService) SetRole(ctx context.Context, id int, role string) error {
+	if !validRole(role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	_, err := s.db.ExecContext(ctx, "UPDATE admins SET role = ? WHERE id = ?", role, id)
+	return err
+}
+
+func (s *AdminService) SetPassword(ctx context.Context, id int, password string) error {
+	passwordHash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE admins SET password_hash = ? WHERE id = ?", passwordHash, id)
+	return err
+}
+
+// Delete removes an admin. Their sessions end and their shares lose the owner.
+func (s *AdminService) Delete(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM admins WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("admin not found")
+	}
+
+	return nil
+}
+
+// ValidatePassword checks the password policy for admin and share passwords.
+func ValidatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	if err := ValidatePassword(password); err != nil {
+		return "", err
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func validRole(role string) bool {
+	for _, r := range AdminRoles {
+		if r.Name == role {
+			return true
+		}
+	}
+	return false
+}
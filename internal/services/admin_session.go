@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const (
+	AdminSessionTTL = 7 * 24 * time.Hour
+
+	// Avoid a write on every request by only refreshing last_seen_at periodically
+	adminSessionTouchInterval = time.Minute
+
+	// A pending login must be completed with the authentication code within
+	// PendingLoginTTL and PendingLoginMaxAttempts tries
+	PendingLoginTTL         = 5 * time.Minute
+	PendingLoginMaxAttempts = 5
+)
+
+type AdminSessionService struct {
+	db *database.DB
+}
+
+func NewAdminSessionService(db *database.DB) *AdminSessionService {
+	return &AdminSessionService{db: db}
+}
+
+// Create starts a new session and returns the plaintext token for the cookie.
+// adminID is nil for sessions signed in with the admin token.
+func (s *AdminSessionService) Create(ctx context.Context, adminID *int, ip, userAgent string) (*database.AdminSession, string, error) {
+	token, err := GenerateHash(48)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now().UTC()
+	id, err := s.db.InsertContext(ctx,
+		"INSERT INTO admin_sessions (admin_id, token_hash, ip, user_agent, created_at, last_seen_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		adminID, hashToken(token), ip, userAgent, now, now, now.Add(AdminSessionTTL),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := s.getBy(ctx, "id", id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return session, token, nil
+}
+
+// Authenticate resolves a session token, rejecting unknown, revoked and expired sessions.
+func (s *AdminSessionService) Authenticate(ctx context.Context, token string) (*database.AdminSession, error) {
+	session, err := s.getBy(ctx, "token_hash", hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if now.After(session.ExpiresAt) {
+		return nil, sql.ErrNoRows
+	}
+
+	if now.Sub(session.LastSeenAt) > adminSessionTouchInterval {
+		if _, err := s.db.ExecContext(ctx, "UPDATE admin_sessions SET last_seen_at = ? WHERE id = ?", now, session.ID); err != nil {
+			return nil, err
+		}
+		session.LastSeenAt = now
+	}
+
+	return session, nil
+}
+
+// List returns the active sessions of an admin, most recently used first.
+func (s *AdminSessionService) List(ctx context.Context, adminID *int) ([]database.AdminSession, error) {
+	where, args := adminFilter(adminID)
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT id, admin_id, ip, user_agent, created_at, last_seen_at, expires_at FROM admin_sessions WHERE "+where+" AND expires_at > ? ORDER BY last_seen_at DESC",
+		append(args, time.Now().UTC())...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []database.AdminSession
+	for rows.Next() {
+		var session database.AdminSession
+		if err := rows.Scan(&session.ID, &session.AdminID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, nil
+}
+
+// Revoke ends one of the admin's sessions.
+func (s *AdminSessionService) Revoke(ctx context.Context, adminID *int, id int) error {
+	where, args := adminFilter(adminID)
+	result, err := s.db.ExecContext(ctx, "DELETE FROM admin_sessions WHERE id = ? AND "+where, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("session not found")
+	}
+
+	return nil
+}
+
+// RevokeOthers ends every session of the admin except the given one.
+func (s *AdminSessionService) RevokeOthers(ctx context.Context, adminID *int, id int) error {
+	where, args := adminFilter(adminID)
+	_, err := s.db.ExecContext(ctx, "DELETE FROM admin_sessions WHERE id != ? AND "+where, append([]interface{}{id}, args...)...)
+	return err
+}
+
+// RevokeAll ends every session of the admin.
+func (s *AdminSessionService) RevokeAll(ctx context.Context, adminID *int) error {
+	where, args := adminFilter(adminID)
+	_, err := s.db.ExecContext(ctx, "DELETE FROM admin_sessions WHERE "+where, args...)
+	return err
+}
+
+// StartPendingLogin records that the admin passed the password check and
+// returns the plaintext token identifying the login until the second factor
+// is entered.
+func (s *AdminSessionService) StartPendingLogin(ctx context.Context, adminID int) (string, error) {
+	token, err := GenerateHash(48)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, "DELETE FROM pending_logins WHERE expires_at < ?", now); err != nil {
+		return "", err
+	}
+
+	_, err = s.db.InsertContext(ctx,
+		"INSERT INTO pending_logins (admin_id, token_hash, created_at, expires_at) VALUES (?, ?, ?, ?)",
+		adminID, hashToken(token), now, now.Add(PendingLoginTTL),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ClaimPendingLoginAttempt counts an attempt to enter the second factor before
+// it is checked, so concurrent guesses are counted as well. It returns the
+// admin of the login and the attempts left after this one. sql.ErrNoRows is
+// returned for unknown and expired logins and once the attempts are used up,
+// in which case the login is removed.
+func (s *AdminSessionService) ClaimPendingLoginAttempt(ctx context.Context, token string) (int, int, error) {
+	tokenHash := hashToken(token)
+	now := time.Now().UTC()
+
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE pending_logins SET attempts = attempts + 1 WHERE token_hash = ? AND expires_at > ? AND attempts < ?",
+		tokenHash, now, PendingLoginMaxAttempts,
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, 0, err
+	}
+	if rows == 0 {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM pending_logins WHERE token_hash = ?", tokenHash); err != nil {
+			return 0, 0, err
+		}
+		return 0, 0, sql.ErrNoRows
+	}
+
+	var adminID, attempts int
+	err = s.db.QueryRowContext(ctx, "SELECT admin_id, attempts FROM pending_logins WHERE token_hash = ?", tokenHash).Scan(&adminID, &attempts)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return adminID, PendingLoginMaxAttempts - attempts, nil
+}
+
+// EndPendingLogin removes a pending login. sql.ErrNoRows is returned if it
+// was already removed, so a login completes only once.
+func (s *AdminSessionService) EndPendingLogin(ctx context.Context, token string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM pending_logins WHERE token_hash = ?", hashToken(token))
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// adminFilter matches the sessions of an admin, or the admin token sessions
+// if adminID is nil.
+func adminFilter(adminID *int) (string, []interface{}) {
+	if adminID == nil {
+		return "admin_id IS NULL", nil
+	}
+	return "admin_id = ?", []interface{}{*adminID}
+}
+
+func (s *AdminSessionService) getBy(ctx context.Context, column string, value interface{}) (*database.AdminSession, error) {
+	session := &database.AdminSession{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, admin_id, ip, user_agent, created_at, last_seen_at, expires_at FROM admin_sessions WHERE "+column+" = ?",
+		value,
+	).Scan(&session.ID, &session.AdminID, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+const (
+	recoveryCodeCount    = 10
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// ErrInvalidTOTPCode is returned for wrong, reused and expired codes.
+var ErrInvalidTOTPCode = fmt.Errorf("invalid authentication code")
+
+// BeginTOTP creates a new secret for the admin. Two-factor authentication is
+// only enabled once a code generated from it is confirmed with EnableTOTP.
+func (s *AdminService) BeginTOTP(ctx context.Context, adminID int) (string, error) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE admins SET totp_secret = ?, totp_last_step = 0 WHERE id = ? AND totp_enabled_at IS NULL",
+		secret, adminID,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rows == 0 {
+		return "", fmt.Errorf("two-factor authentication is already enabled")
+	}
+
+	return secret, nil
+}
+
+// PendingTOTPSecret returns the secret of an enrolment that was not confirmed yet.
+func (s *AdminService) PendingTOTPSecret(ctx context.Context, adminID int) (string, error) {
+	var secret sql.NullString
+	err := s.db.QueryRowContext(ctx,
+		"SELECT totp_secret FROM admins WHERE id = ? AND totp_enabled_at IS NULL",
+		adminID,
+	).Scan(&secret)
+	if err != nil {
+		return "", err
+	}
+	if !secret.Valid || secret.String == "" {
+		return "", sql.ErrNoRows
+	}
+	return secret.String, nil
+}
+
+// EnableTOTP confirms the pending secret with a code and returns the
+// plaintext recovery codes, which are only shown once.
+func (s *AdminService) EnableTOTP(ctx context.Context, adminID int, code string) ([]string, error) {
+	secret, err := s.PendingTOTPSecret(ctx, adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	step, ok := validateTOTP(secret, code, 0, now)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE admins SET totp_enabled_at = ?, totp_last_step = ? WHERE id = ?",
+		now, step, adminID,
+	); err != nil {
+		return nil, err
+	}
+
+	return s.RegenerateRecoveryCodes(ctx, adminID)
+}
+
+// VerifyTOTP checks an authentication code or an unused recovery code.
+func (s *AdminService) VerifyTOTP(ctx context.Context, adminID int, code string) error {
+	var secret string
+	var lastStep int64
+	err := s.db.QueryRowContext(ctx,
+		"SELECT totp_secret, totp_last_step FROM admins WHERE id = ? AND totp_enabled_at IS NOT NULL",
+		adminID,
+	).Scan(&secret, &lastStep)
+	if err != nil {
+		return err
+	}
+
+	if step, ok := validateTOTP(secret, code, lastStep, time.Now().UTC()); ok {
+		// The condition on the last step prevents concurrent reuse of a code
+		result, err := s.db.ExecContext(ctx,
+			"UPDATE admins SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?",
+			step, adminID, step,
+		)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE admin_recovery_codes SET used_at = ? WHERE admin_id = ? AND code_hash = ? AND used_at IS NULL",
+		time.Now().UTC(), adminID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrInvalidTOTPCode
+	}
+
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the admin.
+func (s *AdminService) RegenerateRecoveryCodes(ctx context.Context, adminID int) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = ?", adminID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO admin_recovery_codes (admin_id, code_hash) VALUES (?, ?)",
+			adminID, hashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes.
+func (s *AdminService) CountRecoveryCodes(ctx context.Context, adminID int) (int, error) {
+	var count int
+	err := s.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM admin_recovery_codes WHERE admin_id = ? AND used_at IS NULL",
+		adminID,
+	).Scan(&count)
+	return count, err
+}
+
+// DisableTOTP turns off two-factor authentication and removes the recovery codes.
+func (s *AdminService) DisableTOTP(ctx context.Context, adminID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE admins SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0 WHERE id = ?",
+		adminID,
+	); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM admin_recovery_codes WHERE admin_id = ?", adminID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// generateRecoveryCode returns a code like "k7m2p-x9qrt" without ambiguous characters.
+func generateRecoveryCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := 0; i < 10; i++ {
+		if i == 5 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(recoveryCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
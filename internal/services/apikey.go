@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const (
+	ScopeSharesRead       = "shares:read"
+	ScopeSharesWrite      = "shares:write"
+	ScopeFilesUpload      = "files:upload"
+	ScopeContentDelete    = "content:delete"
+	ScopeCommentsModerate = "comments:moderate"
+)
+
+// APIScopes lists all scopes with a human readable description.
+var APIScopes = []struct {
+	Name        string
+	Description string
+}{
+	{ScopeSharesRead, "Read shares, files and comments"},
+	{ScopeSharesWrite, "Create and update shares"},
+	{ScopeFilesUpload, "Upload files"},
+	{ScopeContentDelete, "Delete shares and files"},
+	{ScopeCommentsModerate, "Create and delete comments"},
+}
+
+const apiKeyPrefix = "fbk_"
+
+type APIKeyService struct {
+	db *database.DB
+}
+
+func NewAPIKeyService(db *database.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// Create stores a new key and returns it together with the plaintext secret,
+// which is not persisted and can only be shown once.
+func (s *APIKeyService) Create(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (*database.APIKey, string, error) {
+	for _, scope := range scopes {
+		if !validScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, "", fmt.Errorf("at least one scope is required")
+	}
+
+	secret, err := GenerateHash(40)
+	if err != nil {
+		return nil, "", err
+	}
+	key := apiKeyPrefix + secret
+
+	id, err := s.db.InsertContext(ctx,
+		"INSERT INTO api_keys (name, prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?)",
+		name, key[:len(apiKeyPrefix)+8], hashToken(key), strings.Join(scopes, " "), expiresAt,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKey, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// Authenticate resolves a plaintext key, rejecting unknown and expired keys,
+// and records the time of use.
+func (s *APIKeyService) Authenticate(ctx context.Context, key string) (*database.APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, sql.ErrNoRows
+	}
+
+	apiKey, err := s.getBy(ctx, "key_hash", hashToken(key))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.IsExpired() {
+		return nil, sql.ErrNoRows
+	}
+
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = ? WHERE id = ?", now, apiKey.ID); err != nil {
+		return nil, err
+	}
+	apiKey.LastUsedAt = &now
+
+	return apiKey, nil
+}
+
+func (s *APIKeyService) GetByID(ctx context.Context, id int) (*database.APIKey, error) {
+	return s.getBy(ctx, "id", id)
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]database.APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, prefix, scopes, expires_at, last_used_at, created_at FROM api_keys ORDER BY created_at DESC, id DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []database.APIKey
+	for rows.Next() {
+		var k database.APIKey
+		var scopes string
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt); err != nil {
+			return nil, err
+		}
+		k.Scopes = strings.Fields(scopes)
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func (s *APIKeyService) Delete(ctx context.Context, id int) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM api_keys WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("api key not found")
+	}
+
+	return nil
+}
+
+func (s *APIKeyService) getBy(ctx context.Context, column string, value interface{}) (*database.APIKey, error) {
+	k := &database.APIKey{}
+	var scopes string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, prefix, scopes, expires_at, last_used_at, created_at FROM api_keys WHERE "+column+" = ?",
+		value,
+	).Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	k.Scopes = strings.Fields(scopes)
+	return k, nil
+}
+
+func validScope(scope string) bool {
+	for _, s := range APIScopes {
+		if s.Name == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const auditColumns = "id, actor_type, actor_id, actor_name, action, target_type, target_id, target, ip, created_at"
+
+// AuditFilter narrows down the audit log. Empty fields match every event.
+// Actor matches a part of the actor's name, To is exclusive.
+type AuditFilter struct {
+	Actor  string
+	Action string
+	From   *time.Time
+	To     *time.Time
+}
+
+func (f AuditFilter) where() (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if f.Actor != "" {
+		conditions = append(conditions, "LOWER(actor_name) LIKE ?")
+		args = append(args, "%"+strings.ToLower(f.Actor)+"%")
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if f.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND ") + " ", args
+}
+
+type AuditService struct {
+	db *database.DB
+}
+
+func NewAuditService(db *database.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record stores an event. Failures are logged so they never undo the action
+// that was already carried out. The event is stored even if the request is
+// cancelled in the meantime, since the action itself was not.
+func (s *AuditService) Record(ctx context.Context, event database.AuditEvent) {
+	_, err := s.db.ExecContext(context.WithoutCancel(ctx),
+		"INSERT INTO audit_events (actor_type, actor_id, actor_name, action, target_type, target_id, target, ip, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		event.ActorType, event.ActorID, event.ActorName, event.Action, event.TargetType, event.TargetID, event.Target, event.IP, time.Now().UTC(),
+	)
+	if err != nil {
+		log.Printf("Failed to record audit event %s by %s: %v", event.Action, event.ActorName, err)
+	}
+}
+
+// List returns a window of matching events, newest first.
+func (s *AuditService) List(ctx context.Context, filter AuditFilter, limit, offset int) ([]database.AuditEvent, error) {
+	return s.list(ctx, filter, "LIMIT ? OFFSET ?", limit, offset)
+}
+
+// All returns every matching event, newest first.
+func (s *AuditService) All(ctx context.Context, filter AuditFilter) ([]database.AuditEvent, error) {
+	return s.list(ctx, filter, "")
+}
+
+func (s *AuditService) Count(ctx context.Context, filter AuditFilter) (int, error) {
+	where, args := filter.where()
+
+	var count int
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_events "+where, args...).Scan(&count)
+	return count, err
+}
+
+// Actions returns the distinct actions in the log for filtering.
+func (s *AuditService) Actions(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT action FROM audit_events ORDER BY action")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var actions []string
+	for rows.Next() {
+		var action string
+		if err := rows.Scan(&action); err != nil {
+			return nil, err
+		}
+		actions = append(actions, action)
+	}
+	return actions, rows.Err()
+}
+
+func (s *AuditService) list(ctx context.Context, filter AuditFilter, suffix string, args ...interface{}) ([]database.AuditEvent, error) {
+	where, whereArgs := filter.where()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+auditColumns+" FROM audit_events "+where+"ORDER BY created_at DESC, id DESC "+suffix,
+		append(whereArgs, args...)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []database.AuditEvent
+	for rows.Next() {
+		var e database.AuditEvent
+		err := rows.Scan(&e.ID, &e.ActorType, &e.ActorID, &e.ActorName, &e.Action, &e.TargetType, &e.TargetID, &e.Target, &e.IP, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
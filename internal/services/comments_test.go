@@ -0,0 +1,133 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+// Size of the seeded share of the comment benchmarks.
+const (
+	benchFiles           = 500
+	benchCommentsPerFile = 40
+	benchPerFile         = 10
+)
+
+// seedLargeShare creates a share with benchFiles files of
+// benchCommentsPerFile comments each, and a second share of the same size
+// whose rows the queries have to skip.
+func seedLargeShare(b *testing.B, db *database.DB) int {
+	b.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		b.Fatalf("begin: %v", err)
+	}
+	defer tx.Rollback()
+
+	var shareID int
+	created := time.Now().UTC().Add(-time.Hour)
+	for s := 0; s < 2; s++ {
+		result, err := tx.Exec("INSERT INTO shares (hash, name, description) VALUES (?, 'Share', '')", fmt.Sprintf("share-%d", s))
+		if err != nil {
+			b.Fatalf("create share: %v", err)
+		}
+		id, _ := result.LastInsertId()
+		if s == 0 {
+			shareID = int(id)
+		}
+
+		for f := 0; f < benchFiles; f++ {
+			result, err := tx.Exec(
+				"INSERT INTO files (share_id, hash, filename, storage_path, mime_type, size_bytes) VALUES (?, ?, 'file.txt', 'file.txt', 'text/plain', 1)",
+				id, fmt.Sprintf("file-%d-%d", s, f),
+			)
+			if err != nil {
+				b.Fatalf("create file: %v", err)
+			}
+			fileID, _ := result.LastInsertId()
+
+			for c := 0; c < benchCommentsPerFile; c++ {
+				if _, err := tx.Exec(
+					"INSERT INTO comments (file_id, username, content, created_at) VALUES (?, 'Visitor', 'Looks good', ?)",
+					fileID, created.Add(time.Duration(c)*time.Second),
+				); err != nil {
+					b.Fatalf("create comment: %v", err)
+				}
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		b.Fatalf("commit: %v", err)
+	}
+	return shareID
+}
+
+// BenchmarkListWithComments loads the share page comments with one query
+// for all files.
+func BenchmarkListWithComments(b *testing.B) {
+	db := newTestDB(b)
+	shareID := seedLargeShare(b, db)
+	files, _ := newTestFileService(b, db, nil, false, false)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := files.ListWithComments(ctx, shareID, benchPerFile)
+		if err != nil || len(result) != benchFiles || len(result[0].Comments) != benchPerFile {
+			b.Fatalf("list: %d files, error %v", len(result), err)
+		}
+	}
+}
+
+// BenchmarkListCommentsPerFile loads the same comments with one query per
+// file, for comparison with BenchmarkListWithComments.
+func BenchmarkListCommentsPerFile(b *testing.B) {
+	db := newTestDB(b)
+	shareID := seedLargeShare(b, db)
+	files, _ := newTestFileService(b, db, nil, false, false)
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list, err := files.GetByShareID(ctx, shareID)
+		if err != nil {
+			b.Fatalf("list files: %v", err)
+		}
+		for _, file := range list {
+			count, err := files.CountComments(ctx, file.ID)
+			if err != nil {
+				b.Fatalf("count comments: %v", err)
+			}
+			if _, err := files.CommentsPage(ctx, file.ID, benchPerFile, count-benchPerFile); err != nil {
+				b.Fatalf("list comments: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkCommentsBefore loads earlier comments of a file of the share.
+func BenchmarkCommentsBefore(b *testing.B) {
+	db := newTestDB(b)
+	shareID := seedLargeShare(b, db)
+	files, _ := newTestFileService(b, db, nil, false, false)
+	ctx := context.Background()
+
+	recent, err := files.ListWithComments(ctx, shareID, benchPerFile)
+	if err != nil {
+		b.Fatalf("list: %v", err)
+	}
+	oldest := recent[0].Comments[0].ID
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		comments, err := files.CommentsBefore(ctx, recent[0].ID, oldest, benchPerFile)
+		if err != nil || len(comments) != benchPerFile {
+			b.Fatalf("before: %d comments, error %v", len(comments), err)
+		}
+	}
+}
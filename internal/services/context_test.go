@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestServicesUseRequestContext(t *testing.T) {
+	db := newTestDB(t)
+	share := createTestShare(t, db)
+	mailer := NewMailService("", "", "", "", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Queries of a cancelled request are not run
+	for name, call := range map[string]func() error{
+		"subscription": func() error {
+			s, _, _ := newTestSubscriptionService(t, db)
+			_, err := s.Subscribe(ctx, publicAccess(share), nil, "visitor@example.com")
+			return err
+		},
+		"reviewer": func() error {
+			_, err := NewReviewerService(db, mailer, "http://localhost").ListByShareID(ctx, share.ID)
+			return err
+		},
+		"admin": func() error {
+			_, err := NewAdminService(db).List(ctx)
+			return err
+		},
+		"admin session": func() error {
+			_, err := NewAdminSessionService(db).Authenticate(ctx, "token")
+			return err
+		},
+		"pending login": func() error {
+			_, err := NewAdminSessionService(db).StartPendingLogin(ctx, 1)
+			return err
+		},
+		"api key": func() error {
+			_, err := NewAPIKeyService(db).List(ctx)
+			return err
+		},
+		"audit": func() error {
+			_, err := NewAuditService(db).Count(ctx, AuditFilter{})
+			return err
+		},
+	} {
+		if err := call(); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: error %v, want context.Canceled", name, err)
+		}
+	}
+}
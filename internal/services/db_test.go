@@ -0,0 +1,35 @@
+package services
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+// newTestDB opens a migrated SQLite database in a temporary directory.
+func newTestDB(t testing.TB) *database.DB {
+	t.Helper()
+
+	db, err := database.Open(filepath.Join(t.TempDir(), "feedback.db"))
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := database.MigrateUp(db); err != nil {
+		t.Fatalf("migrate database: %v", err)
+	}
+	return db
+}
+
+// createTestShare inserts a share without going through ShareService.
+func createTestShare(t testing.TB, db *database.DB) *database.Share {
+	t.Helper()
+
+	id, err := db.Insert("INSERT INTO shares (hash, name, description) VALUES (?, ?, '')", "share-hash", "Share")
+	if err != nil {
+		t.Fatalf("create share: %v", err)
+	}
+	return &database.Share{ID: id, Hash: "share-hash", Name: "Share"}
+}
@@ -1,30 +1,46 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/repository"
 )
 
 type FileService struct {
-	db      *sql.DB
-	dataDir string
+	files         *repository.FileRepository
+	comments      *repository.CommentRepository
+	dataDir       string
+	scanner       Scanner
+	stripMetadata bool
+	keepOriginals bool
 }
 
-func NewFileService(db *sql.DB, dataDir string) *FileService {
+// NewFileService creates the file service. Uploads are checked for malware
+// with the scanner, which may be nil to skip scanning. With stripMetadata,
+// EXIF and similar metadata is removed from uploaded images; the untouched
+// upload is only kept with keepOriginals.
+func NewFileService(files *repository.FileRepository, comments *repository.CommentRepository, dataDir string, scanner Scanner, stripMetadata, keepOriginals bool) *FileService {
 	return &FileService{
-		db:      db,
-		dataDir: dataDir,
+		files:         files,
+		comments:      comments,
+		dataDir:       dataDir,
+		scanner:       scanner,
+		stripMetadata: stripMetadata,
+		keepOriginals: keepOriginals,
 	}
 }
 
-func (s *FileService) Save(shareID int, fileHeader *multipart.FileHeader) (*database.File, error) {
+func (s *FileService) Save(ctx context.Context, shareID int, fileHeader *multipart.FileHeader) (*database.File, error) {
 	// Open uploaded file
 	file, err := fileHeader.Open()
 	if err != nil {
@@ -56,161 +72,414 @@ func (s *FileService) Save(shareID int, fileHeader *multipart.FileHeader) (*data
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file: %w", err)
 	}
-	defer dst.Close()
 
-	// Copy file contents
+	// Copy file contents, the file is closed before it is scanned
 	size, err := io.Copy(dst, file)
+	dst.Close()
 	if err != nil {
 		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
 
+	// Remove location and camera details from photos before anyone sees them
+	var originalPath *string
+	if s.stripMetadata {
+		stripped, original, err := s.stripFileMetadata(storagePath, shareDir)
+		if err != nil {
+			os.Remove(storagePath)
+			return nil, err
+		}
+		if stripped >= 0 {
+			size = stripped
+			originalPath = original
+		}
+	}
+
 	// Detect MIME type
 	mimeType := fileHeader.Header.Get("Content-Type")
 	if mimeType == "" {
 		mimeType = "application/octet-stream"
 	}
 
+	// Files stay blocked until the scan finished
+	scanStatus := database.ScanStatusUnscanned
+	if s.scanner != nil {
+		scanStatus = database.ScanStatusPending
+	}
+
 	// Save to database
-	result, err := s.db.Exec(
-		"INSERT INTO files (share_id, hash, filename, storage_path, mime_type, size_bytes) VALUES (?, ?, ?, ?, ?, ?)",
-		shareID, fileHash, fileHeader.Filename, storagePath, mimeType, size,
-	)
+	id, err := s.files.Create(ctx, &database.File{
+		ShareID:      shareID,
+		Hash:         fileHash,
+		Filename:     fileHeader.Filename,
+		StoragePath:  storagePath,
+		MimeType:     mimeType,
+		SizeBytes:    size,
+		ScanStatus:   scanStatus,
+		OriginalPath: originalPath,
+	})
 	if err != nil {
 		// Clean up file if database insert fails
 		os.Remove(storagePath)
+		if originalPath != nil {
+			os.Remove(*originalPath)
+		}
 		return nil, err
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return nil, err
+	if s.scanner != nil {
+		// A failed scan leaves the file pending, it can be scanned again later
+		if err := s.Scan(ctx, id); err != nil {
+			log.Printf("Failed to scan file %d: %v", id, err)
+		}
 	}
 
-	return s.GetByID(int(id))
+	return s.GetByID(ctx, id)
 }
 
-func (s *FileService) GetByID(id int) (*database.File, error) {
-	file := &database.File{}
-	err := s.db.QueryRow(
-		"SELECT id, share_id, hash, filename, storage_path, mime_type, size_bytes, uploaded_at FROM files WHERE id = ?",
-		id,
-	).Scan(&file.ID, &file.ShareID, &file.Hash, &file.Filename, &file.StoragePath, &file.MimeType, &file.SizeBytes, &file.UploadedAt)
+// stripFileMetadata removes metadata from the image at path in place and
+// returns its new size, or -1 if the file was left unchanged. The original
+// is moved to the originals directory of the share if it is kept.
+func (s *FileService) stripFileMetadata(path, shareDir string) (int64, *string, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return nil, err
+		return -1, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	header := make([]byte, 12)
+	n, _ := io.ReadFull(f, header)
+	f.Close()
+	if !CanStripMetadata(header[:n]) {
+		return -1, nil, nil
 	}
-	return file, nil
-}
 
-func (s *FileService) GetByHash(hash string) (*database.File, error) {
-	file := &database.File{}
-	err := s.db.QueryRow(
-		"SELECT id, share_id, hash, filename, storage_path, mime_type, size_bytes, uploaded_at FROM files WHERE hash = ?",
-		hash,
-	).Scan(&file.ID, &file.ShareID, &file.Hash, &file.Filename, &file.StoragePath, &file.MimeType, &file.SizeBytes, &file.UploadedAt)
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return -1, nil, fmt.Errorf("failed to read file: %w", err)
 	}
-	return file, nil
+	stripped, changed, err := StripMetadata(data)
+	if err != nil {
+		// Images that cannot be parsed are kept as uploaded
+		log.Printf("Warning: failed to strip metadata of %s: %v", path, err)
+		return -1, nil, nil
+	}
+	if !changed {
+		return -1, nil, nil
+	}
+
+	var originalPath *string
+	if s.keepOriginals {
+		originalsDir := filepath.Join(shareDir, "originals")
+		if err := os.MkdirAll(originalsDir, 0755); err != nil {
+			return -1, nil, fmt.Errorf("failed to create originals directory: %w", err)
+		}
+		original := filepath.Join(originalsDir, filepath.Base(path))
+		if err := os.Rename(path, original); err != nil {
+			return -1, nil, fmt.Errorf("failed to keep original file: %w", err)
+		}
+		originalPath = &original
+	}
+
+	if err := os.WriteFile(path, stripped, 0644); err != nil {
+		if originalPath != nil {
+			os.Remove(*originalPath)
+		}
+		return -1, nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	return int64(len(stripped)), originalPath, nil
+}
+
+// ScanEnabled reports whether uploads are scanned for malware.
+func (s *FileService) ScanEnabled() bool {
+	return s.scanner != nil
 }
 
-func (s *FileService) GetByShareID(shareID int) ([]database.File, error) {
-	rows, err := s.db.Query(
-		"SELECT id, share_id, hash, filename, storage_path, mime_type, size_bytes, uploaded_at FROM files WHERE share_id = ? ORDER BY uploaded_at DESC",
-		shareID,
-	)
+// Scan checks the file and its kept original for malware and stores the
+// verdict. Infected files are moved to the quarantine directory.
+func (s *FileService) Scan(ctx context.Context, id int) error {
+	if s.scanner == nil {
+		return fmt.Errorf("no malware scanner configured")
+	}
+
+	file, err := s.GetByID(ctx, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var files []database.File
-	for rows.Next() {
-		var f database.File
-		err := rows.Scan(&f.ID, &f.ShareID, &f.Hash, &f.Filename, &f.StoragePath, &f.MimeType, &f.SizeBytes, &f.UploadedAt)
+	// The kept original is scanned as well, it contains everything that was
+	// stripped from the served file
+	paths := []string{file.StoragePath}
+	if file.OriginalPath != nil {
+		paths = append(paths, *file.OriginalPath)
+	}
+
+	var result *ScanResult
+	for _, path := range paths {
+		result, err = s.scanPath(path)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if result.Infected {
+			break
 		}
-		files = append(files, f)
 	}
 
-	return files, nil
-}
+	if !result.Infected {
+		return s.files.MarkClean(ctx, id)
+	}
 
-func (s *FileService) Delete(id int) error {
-	// Get file info first
-	file, err := s.GetByID(id)
+	quarantineDir := filepath.Join(s.dataDir, "quarantine")
+	storagePath, err := quarantine(file.StoragePath, quarantineDir)
 	if err != nil {
 		return err
 	}
+	originalPath := file.OriginalPath
+	if originalPath != nil {
+		quarantined, err := quarantine(*originalPath, filepath.Join(quarantineDir, "originals"))
+		if err != nil {
+			return err
+		}
+		originalPath = &quarantined
+	}
 
-	// Delete from database
-	result, err := s.db.Exec("DELETE FROM files WHERE id = ?", id)
+	return s.files.MarkInfected(ctx, id, result.Signature, storagePath, originalPath)
+}
+
+func (s *FileService) scanPath(path string) (*ScanResult, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
+	defer f.Close()
+
+	return s.scanner.Scan(f)
+}
 
-	rows, err := result.RowsAffected()
+// quarantine moves the file into dir unless it is already there and returns
+// its new path.
+func quarantine(path, dir string) (string, error) {
+	if filepath.Dir(path) == dir {
+		return path, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	quarantinePath := filepath.Join(dir, filepath.Base(path))
+	if err := os.Rename(path, quarantinePath); err != nil {
+		return "", fmt.Errorf("failed to quarantine file: %w", err)
+	}
+	return quarantinePath, nil
+}
+
+// GetByID returns a file that is not in the trash.
+func (s *FileService) GetByID(ctx context.Context, id int) (*database.File, error) {
+	return s.files.GetByID(ctx, id)
+}
+
+func (s *FileService) GetByHash(ctx context.Context, hash string) (*database.File, error) {
+	return s.files.GetByHash(ctx, hash)
+}
+
+// GetDeleted returns a file from the trash.
+func (s *FileService) GetDeleted(ctx context.Context, id int) (*database.File, error) {
+	return s.files.GetDeleted(ctx, id)
+}
+
+func (s *FileService) GetByShareID(ctx context.Context, shareID int) ([]database.File, error) {
+	return s.files.ListByShareID(ctx, shareID)
+}
+
+// ListPage returns a window of a share's files ordered like GetByShareID.
+func (s *FileService) ListPage(ctx context.Context, shareID, limit, offset int) ([]database.File, error) {
+	return s.files.ListPage(ctx, shareID, limit, offset)
+}
+
+func (s *FileService) CountByShareID(ctx context.Context, shareID int) (int, error) {
+	return s.files.CountByShareID(ctx, shareID)
+}
+
+// Delete moves the file to the trash. It stays on disk until it is purged.
+func (s *FileService) Delete(ctx context.Context, id int) error {
+	return s.files.SetDeleted(ctx, id, true)
+}
+
+// Restore moves the file out of the trash.
+func (s *FileService) Restore(ctx context.Context, id int) error {
+	return s.files.SetDeleted(ctx, id, false)
+}
+
+// Purge permanently deletes a file from the trash with its comments and
+// removes it from disk.
+func (s *FileService) Purge(ctx context.Context, id int) error {
+	// Get file info first
+	file, err := s.GetDeleted(ctx, id)
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
+
+	// Delete from database
+	if err := s.files.Delete(ctx, id); err == sql.ErrNoRows {
 		return fmt.Errorf("file not found")
+	} else if err != nil {
+		return err
 	}
 
 	// Delete physical file
-	if err := os.Remove(file.StoragePath); err != nil {
-		// Log error but don't fail the operation
-		fmt.Printf("Warning: failed to delete file %s: %v\n", file.StoragePath, err)
-	}
+	removeStoredFile(file)
 
 	return nil
 }
 
-func (s *FileService) GetComments(fileID int) ([]database.Comment, error) {
-	rows, err := s.db.Query(
-		"SELECT id, file_id, username, content, created_at FROM comments WHERE file_id = ? ORDER BY created_at ASC",
-		fileID,
-	)
+// removeStoredFile deletes the file and its kept original from disk. Errors
+// are logged but don't fail the operation, the database row is already gone.
+func removeStoredFile(file *database.File) {
+	if err := os.Remove(file.StoragePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to delete file %s: %v", file.StoragePath, err)
+	}
+	if file.OriginalPath != nil {
+		if err := os.Remove(*file.OriginalPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: failed to delete file %s: %v", *file.OriginalPath, err)
+		}
+	}
+}
+
+// Approve marks the file as approved by the given visitor. Approving again
+// replaces the previous approval.
+func (s *FileService) Approve(ctx context.Context, id int, username string) error {
+	return s.files.Approve(ctx, id, username)
+}
+
+// RevokeApproval removes the approval of the file.
+func (s *FileService) RevokeApproval(ctx context.Context, id int) error {
+	return s.files.RevokeApproval(ctx, id)
+}
+
+// PurgeShare permanently deletes a share with its files and comments and
+// removes its uploads from disk. The files are removed one by one as well,
+// since quarantined files are no longer in the share's upload directory.
+func (s *FileService) PurgeShare(ctx context.Context, shares *ShareService, shareID int) error {
+	files, err := s.files.ListAllByShareID(ctx, shareID)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := shares.Purge(ctx, shareID); err != nil {
+		return err
 	}
-	defer rows.Close()
 
-	var comments []database.Comment
-	for rows.Next() {
-		var c database.Comment
-		err := rows.Scan(&c.ID, &c.FileID, &c.Username, &c.Content, &c.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		comments = append(comments, c)
+	for i := range files {
+		removeStoredFile(&files[i])
 	}
+	if err := os.RemoveAll(filepath.Join(s.dataDir, "uploads", fmt.Sprintf("%d", shareID))); err != nil {
+		log.Printf("Warning: failed to delete uploads of share %d: %v", shareID, err)
+	}
+	return nil
+}
 
-	return comments, nil
+func (s *FileService) GetComments(ctx context.Context, fileID int) ([]database.Comment, error) {
+	return s.comments.ListByFileID(ctx, fileID)
 }
 
-func (s *FileService) AddComment(fileID int, username, content string) (*database.Comment, error) {
-	result, err := s.db.Exec(
-		"INSERT INTO comments (file_id, username, content) VALUES (?, ?, ?)",
-		fileID, username, content,
-	)
+// ListWithComments returns the files of a share with up to perFile of their
+// most recent comments each. Files and comments are loaded with one query
+// each, regardless of the number of files.
+func (s *FileService) ListWithComments(ctx context.Context, shareID, perFile int) ([]database.FileWithComments, error) {
+	files, err := s.files.ListByShareID(ctx, shareID)
 	if err != nil {
 		return nil, err
 	}
 
-	id, err := result.LastInsertId()
+	comments, counts, err := s.comments.ListRecentByShareID(ctx, shareID, perFile)
 	if err != nil {
 		return nil, err
 	}
 
-	comment := &database.Comment{}
-	err = s.db.QueryRow(
-		"SELECT id, file_id, username, content, created_at FROM comments WHERE id = ?",
-		id,
-	).Scan(&comment.ID, &comment.FileID, &comment.Username, &comment.Content, &comment.CreatedAt)
+	result := make([]database.FileWithComments, 0, len(files))
+	for _, file := range files {
+		result = append(result, database.FileWithComments{
+			File:         file,
+			Comments:     comments[file.ID],
+			CommentCount: counts[file.ID],
+		})
+	}
+	return result, nil
+}
+
+// CommentsBefore returns up to limit comments of a file written before the
+// given comment, ordered like GetComments.
+func (s *FileService) CommentsBefore(ctx context.Context, fileID, beforeID, limit int) ([]database.Comment, error) {
+	return s.comments.ListBefore(ctx, fileID, beforeID, limit)
+}
+
+// CommentsPage returns a window of a file's comments ordered like GetComments.
+func (s *FileService) CommentsPage(ctx context.Context, fileID, limit, offset int) ([]database.Comment, error) {
+	return s.comments.ListPage(ctx, fileID, limit, offset)
+}
+
+func (s *FileService) CountComments(ctx context.Context, fileID int) (int, error) {
+	return s.comments.CountByFileID(ctx, fileID)
+}
+
+// AddComment stores a comment. reviewerID is set for comments of invited
+// reviewers, whose name is verified.
+func (s *FileService) AddComment(ctx context.Context, fileID int, username, content string, reviewerID *int) (*database.Comment, error) {
+	id, err := s.comments.Create(ctx, fileID, username, content, reviewerID)
 	if err != nil {
 		return nil, err
 	}
 
-	return comment, nil
+	return s.GetComment(ctx, id)
+}
+
+// GetComment returns a comment that is not in the trash.
+func (s *FileService) GetComment(ctx context.Context, id int) (*database.Comment, error) {
+	return s.comments.GetByID(ctx, id)
+}
+
+// GetDeletedComment returns a comment from the trash.
+func (s *FileService) GetDeletedComment(ctx context.Context, id int) (*database.Comment, error) {
+	return s.comments.GetDeleted(ctx, id)
+}
+
+// DeleteComment moves the comment to the trash.
+func (s *FileService) DeleteComment(ctx context.Context, id int) error {
+	return s.comments.SetDeleted(ctx, id, true)
+}
+
+// RestoreComment moves the comment out of the trash.
+func (s *FileService) RestoreComment(ctx context.Context, id int) error {
+	return s.comments.SetDeleted(ctx, id, false)
+}
+
+// PurgeComment permanently deletes a comment from the trash.
+func (s *FileService) PurgeComment(ctx context.Context, id int) error {
+	if err := s.comments.DeleteTrashed(ctx, id); err == sql.ErrNoRows {
+		return fmt.Errorf("comment not found")
+	} else if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ListDeleted returns the files in the trash whose share is not deleted as
+// well. Files of deleted shares come back with their share.
+func (s *FileService) ListDeleted(ctx context.Context) ([]database.TrashedFile, error) {
+	return s.files.ListDeleted(ctx)
+}
+
+// ListDeletedComments returns the comments in the trash whose file and share
+// are not deleted.
+func (s *FileService) ListDeletedComments(ctx context.Context) ([]database.TrashedComment, error) {
+	return s.comments.ListDeleted(ctx)
+}
+
+// ListDeletedBefore returns the files moved to the trash before the given
+// time, regardless of whether their share is deleted.
+func (s *FileService) ListDeletedBefore(ctx context.Context, before time.Time) ([]database.File, error) {
+	return s.files.ListDeletedBefore(ctx, before)
+}
+
+// PurgeCommentsDeletedBefore permanently deletes the comments moved to the
+// trash before the given time and returns how many were removed.
+func (s *FileService) PurgeCommentsDeletedBefore(ctx context.Context, before time.Time) (int, error) {
+	return s.comments.DeleteTrashedBefore(ctx, before)
 }
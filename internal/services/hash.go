@@ -2,6 +2,8 @@ package services
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"math/big"
 )
 
@@ -18,3 +20,10 @@ func GenerateHash(length int) (string, error) {
 	}
 	return string(hash), nil
 }
+
+// hashToken hashes a long random token for storage. Tokens carry enough
+// entropy that a fast unsalted hash is sufficient.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+	Headers map[string]string
+}
+
+type MailService struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+func NewMailService(host, port, username, password, from string) *MailService {
+	return &MailService{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+func (s *MailService) Send(msg MailMessage) error {
+	// Without an SMTP server, log the message so links can be used during development
+	if s.host == "" {
+		log.Printf("Mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + s.from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	for key, value := range msg.Headers {
+		b.WriteString(key + ": " + value + "\r\n")
+	}
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+
+	if err := smtp.SendMail(s.host+":"+s.port, auth, s.from, []string{msg.To}, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+
+	return nil
+}
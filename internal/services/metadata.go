@@ -0,0 +1,350 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// exifOrientation is the EXIF tag holding the rotation of a photo.
+const exifOrientation = 0x0112
+
+var (
+	pngSignature = []byte("\x89PNG\r\n\x1a\n")
+	exifHeader   = []byte("Exif\x00\x00")
+)
+
+// CanStripMetadata reports whether the header of a file belongs to an image
+// format StripMetadata supports.
+func CanStripMetadata(header []byte) bool {
+	return isJPEG(header) || isPNG(header) || isWebP(header)
+}
+
+// StripMetadata removes EXIF, XMP, IPTC and text metadata such as GPS
+// coordinates and camera details from JPEG, PNG and WebP images. The EXIF
+// orientation is kept so photos are still displayed upright, and color
+// profiles are kept as well. It reports whether anything was removed; other
+// formats are returned unchanged.
+func StripMetadata(data []byte) ([]byte, bool, error) {
+	switch {
+	case isJPEG(data):
+		return stripJPEG(data)
+	case isPNG(data):
+		return stripPNG(data)
+	case isWebP(data):
+		return stripWebP(data)
+	default:
+		return data, false, nil
+	}
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) >= 3 && data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF
+}
+
+func isPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+func isWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// stripJPEG drops all APPn segments except JFIF, ICC profiles and Adobe
+// color information, and all comments. Everything from the start of scan on
+// is copied unchanged.
+func stripJPEG(data []byte) ([]byte, bool, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(data[:2])
+
+	orientation := 0
+	changed := false
+	wroteOrientation := false
+	writeOrientation := func() {
+		if !wroteOrientation && orientation > 1 {
+			tiff := exifWithOrientation(orientation)
+			segment := append(append([]byte{}, exifHeader...), tiff...)
+			out.Write([]byte{0xFF, 0xE1})
+			binary.Write(out, binary.BigEndian, uint16(len(segment)+2))
+			out.Write(segment)
+		}
+		wroteOrientation = true
+	}
+
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, false, fmt.Errorf("invalid JPEG marker at offset %d", i)
+		}
+		// Markers may be preceded by fill bytes
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			return nil, false, fmt.Errorf("truncated JPEG")
+		}
+		marker := data[i+1]
+
+		// Markers without a length
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			out.Write(data[i : i+2])
+			i += 2
+			continue
+		}
+		if marker == 0xD9 {
+			out.Write(data[i:])
+			break
+		}
+
+		if i+4 > len(data) {
+			return nil, false, fmt.Errorf("truncated JPEG")
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:i+4]))
+		if end > len(data) || end < i+4 {
+			return nil, false, fmt.Errorf("invalid JPEG segment length at offset %d", i)
+		}
+		payload := data[i+4 : end]
+
+		switch {
+		case marker == 0xE0:
+			out.Write(data[i:end])
+			i = end
+			continue
+		case marker == 0xE1:
+			tiff, isExif := bytes.CutPrefix(payload, exifHeader)
+			if isExif {
+				if o := readExifOrientation(tiff); o > 0 {
+					orientation = o
+				}
+			}
+			changed = changed || !isExif || !onlyOrientation(tiff)
+			i = end
+			continue
+		case marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00")),
+			marker == 0xEE:
+			// Color profiles and Adobe transforms affect how the image looks
+		case (marker >= 0xE2 && marker <= 0xEF) || marker == 0xFE:
+			changed = true
+			i = end
+			continue
+		}
+
+		writeOrientation()
+		out.Write(data[i:end])
+		i = end
+
+		// The compressed image data follows the start of scan
+		if marker == 0xDA {
+			out.Write(data[i:])
+			break
+		}
+	}
+
+	if !changed {
+		return data, false, nil
+	}
+	return out.Bytes(), true, nil
+}
+
+// stripPNG drops text, time and EXIF chunks. A minimal EXIF chunk with the
+// orientation is written after the header if the image was rotated.
+func stripPNG(data []byte) ([]byte, bool, error) {
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.Write(pngSignature)
+
+	type chunk struct {
+		typ  string
+		data []byte
+	}
+	var chunks []chunk
+	orientation := 0
+	changed := false
+
+	i := len(pngSignature)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, false, fmt.Errorf("truncated PNG")
+		}
+		length := int(binary.BigEndian.Uint32(data[i : i+4]))
+		typ := string(data[i+4 : i+8])
+		end := i + 12 + length
+		if length < 0 || end > len(data) || end < i {
+			return nil, false, fmt.Errorf("invalid PNG chunk length at offset %d", i)
+		}
+		payload := data[i+8 : i+8+length]
+		i = end
+
+		switch typ {
+		case "eXIf":
+			if o := readExifOrientation(payload); o > 0 {
+				orientation = o
+			}
+			changed = changed || !onlyOrientation(payload)
+		case "tEXt", "zTXt", "iTXt", "tIME":
+			changed = true
+		default:
+			chunks = append(chunks, chunk{typ, payload})
+		}
+	}
+
+	if !changed {
+		return data, false, nil
+	}
+
+	for _, c := range chunks {
+		writePNGChunk(out, c.typ, c.data)
+		if c.typ == "IHDR" && orientation > 1 {
+			writePNGChunk(out, "eXIf", exifWithOrientation(orientation))
+		}
+	}
+	return out.Bytes(), true, nil
+}
+
+func writePNGChunk(out *bytes.Buffer, typ string, data []byte) {
+	binary.Write(out, binary.BigEndian, uint32(len(data)))
+	out.WriteString(typ)
+	out.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	binary.Write(out, binary.BigEndian, crc.Sum32())
+}
+
+// stripWebP drops the EXIF and XMP chunks of extended WebP files and clears
+// their flags. Simple WebP files cannot carry metadata.
+func stripWebP(data []byte) ([]byte, bool, error) {
+	const (
+		flagXMP  = 0x04
+		flagEXIF = 0x08
+	)
+
+	type chunk struct {
+		fourCC string
+		data   []byte
+	}
+	var chunks []chunk
+	orientation := 0
+	changed := false
+
+	i := 12
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, false, fmt.Errorf("truncated WebP")
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		end := i + 8 + size
+		if size < 0 || end > len(data) || end < i {
+			return nil, false, fmt.Errorf("invalid WebP chunk size at offset %d", i)
+		}
+		payload := data[i+8 : end]
+		// Chunks are padded to an even size
+		i = end + size%2
+
+		switch fourCC {
+		case "EXIF":
+			tiff := bytes.TrimPrefix(payload, exifHeader)
+			if o := readExifOrientation(tiff); o > 0 {
+				orientation = o
+			}
+			changed = changed || !onlyOrientation(tiff)
+		case "XMP ":
+			changed = true
+		default:
+			chunks = append(chunks, chunk{fourCC, payload})
+		}
+	}
+
+	if !changed {
+		return data, false, nil
+	}
+	if orientation > 1 {
+		chunks = append(chunks, chunk{"EXIF", exifWithOrientation(orientation)})
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(data)))
+	out.WriteString("RIFF")
+	out.Write([]byte{0, 0, 0, 0})
+	out.WriteString("WEBP")
+	for _, c := range chunks {
+		payload := c.data
+		if c.fourCC == "VP8X" && len(payload) > 0 {
+			payload = append([]byte{}, payload...)
+			payload[0] &^= flagXMP | flagEXIF
+			if orientation > 1 {
+				payload[0] |= flagEXIF
+			}
+		}
+		out.WriteString(c.fourCC)
+		binary.Write(out, binary.LittleEndian, uint32(len(payload)))
+		out.Write(payload)
+		if len(payload)%2 == 1 {
+			out.WriteByte(0)
+		}
+	}
+
+	result := out.Bytes()
+	binary.LittleEndian.PutUint32(result[4:8], uint32(len(result)-8))
+	return result, true, nil
+}
+
+// readExifOrientation returns the orientation from the first IFD of TIFF
+// encoded EXIF data, or 0 if it has none.
+func readExifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(tiff[4:8]))
+	if offset < 8 || offset+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	for n := 0; n < count; n++ {
+		entry := offset + 2 + n*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// Orientation is a single SHORT stored in the value field
+		if order.Uint16(tiff[entry:entry+2]) == exifOrientation && order.Uint16(tiff[entry+2:entry+4]) == 3 {
+			o := int(order.Uint16(tiff[entry+8 : entry+10]))
+			if o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// onlyOrientation reports whether the EXIF data is what exifWithOrientation
+// writes, so already stripped images are left alone.
+func onlyOrientation(tiff []byte) bool {
+	o := readExifOrientation(tiff)
+	return o > 1 && bytes.Equal(tiff, exifWithOrientation(o))
+}
+
+// exifWithOrientation encodes TIFF data with only the orientation tag.
+func exifWithOrientation(orientation int) []byte {
+	tiff := make([]byte, 26)
+	copy(tiff, "MM\x00\x2a")
+	binary.BigEndian.PutUint32(tiff[4:8], 8)
+	binary.BigEndian.PutUint16(tiff[8:10], 1)
+	binary.BigEndian.PutUint16(tiff[10:12], exifOrientation)
+	binary.BigEndian.PutUint16(tiff[12:14], 3)
+	binary.BigEndian.PutUint32(tiff[14:18], 1)
+	binary.BigEndian.PutUint16(tiff[18:20], uint16(orientation))
+	// The next IFD offset stays zero
+	return tiff
+}
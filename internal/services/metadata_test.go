@@ -0,0 +1,314 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// secrets are the metadata values the test images carry, none of them may be
+// left after stripping.
+var secrets = []string{"SecretCam", "SecretGPS", "SecretXMP", "SecretIPTC", "SecretComment"}
+
+func TestStripMetadata(t *testing.T) {
+	for name, test := range map[string]struct {
+		image       []byte
+		orientation int
+		// kept are parts of the image that must not be removed
+		kept []string
+		// check validates the format of the stripped image
+		check func(t *testing.T, out []byte)
+	}{
+		"jpeg rotated": {
+			image:       testJPEG(t, 6),
+			orientation: 6,
+			kept:        []string{"JFIF\x00", "ICC_PROFILE\x00\x01\x01iccdata", "Adobe\x00"},
+			check:       decodeJPEG,
+		},
+		"jpeg upright": {
+			image: testJPEG(t, 0),
+			kept:  []string{"JFIF\x00", "ICC_PROFILE\x00\x01\x01iccdata", "Adobe\x00"},
+			check: decodeJPEG,
+		},
+		"png rotated": {
+			image:       testPNG(t, 6),
+			orientation: 6,
+			kept:        []string{"iCCPicc\x00\x00iccdata"},
+			check:       decodePNG,
+		},
+		"png upright": {
+			image: testPNG(t, 0),
+			kept:  []string{"iCCPicc\x00\x00iccdata"},
+			check: decodePNG,
+		},
+		"webp rotated": {
+			image:       testWebP(6),
+			orientation: 6,
+			kept:        []string{"ICCP\x07\x00\x00\x00iccdata", "VP8L"},
+			check:       checkWebP(webpFlagICC | webpFlagEXIF),
+		},
+		"webp upright": {
+			image: testWebP(0),
+			kept:  []string{"ICCP\x07\x00\x00\x00iccdata", "VP8L"},
+			check: checkWebP(webpFlagICC),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out, changed, err := StripMetadata(test.image)
+			if err != nil {
+				t.Fatalf("StripMetadata: %v", err)
+			}
+			if !changed {
+				t.Fatal("metadata not reported as removed")
+			}
+
+			for _, secret := range secrets {
+				if bytes.Contains(out, []byte(secret)) {
+					t.Errorf("%s left in the image", secret)
+				}
+			}
+			for _, part := range test.kept {
+				if !bytes.Contains(out, []byte(part)) {
+					t.Errorf("%q removed from the image", part)
+				}
+			}
+
+			// Only the orientation is left of the EXIF data
+			tiffs := bytes.Count(out, []byte("MM\x00\x2a"))
+			if test.orientation > 1 && (tiffs != 1 || !bytes.Contains(out, exifWithOrientation(test.orientation))) {
+				t.Errorf("orientation %d not kept as only EXIF data", test.orientation)
+			}
+			if test.orientation == 0 && tiffs != 0 {
+				t.Error("EXIF data left in an upright image")
+			}
+
+			test.check(t, out)
+
+			// Stripping again changes nothing
+			again, changed, err := StripMetadata(out)
+			if err != nil || changed || !bytes.Equal(again, out) {
+				t.Errorf("stripped image changed again: changed %v, error %v", changed, err)
+			}
+		})
+	}
+}
+
+func TestStripMetadataWithoutMetadata(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var plainJPEG, plainPNG bytes.Buffer
+	jpeg.Encode(&plainJPEG, img, nil)
+	png.Encode(&plainPNG, img)
+
+	for name, data := range map[string][]byte{
+		"jpeg":  plainJPEG.Bytes(),
+		"png":   plainPNG.Bytes(),
+		"webp":  webpFile(webpChunk("VP8L", []byte("\x2f\x03\x00\x00\x00"))),
+		"gif":   []byte("GIF89a\x01\x00\x01\x00"),
+		"empty": nil,
+	} {
+		out, changed, err := StripMetadata(data)
+		if err != nil || changed || !bytes.Equal(out, data) {
+			t.Errorf("%s: changed %v, error %v", name, changed, err)
+		}
+	}
+}
+
+func TestStripMetadataInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"jpeg truncated segment":  testJPEG(t, 6)[:30],
+		"jpeg short length":       []byte("\xFF\xD8\xFF\xE1\x00\x01"),
+		"jpeg missing marker":     []byte("\xFF\xD8\xFF\xE0\x00\x04ab\x00\x00"),
+		"jpeg marker at end":      []byte("\xFF\xD8\xFF\xFE\x00\x02\xFF"),
+		"png truncated chunk":     append(append([]byte{}, pngSignature...), 0, 0, 0),
+		"png oversized chunk":     append(append([]byte{}, pngSignature...), "\xFF\xFF\xFF\xFFtEXt"...),
+		"webp truncated chunk":    []byte("RIFF\x00\x00\x00\x00WEBPVP8X"),
+		"webp oversized chunk":    []byte("RIFF\x00\x00\x00\x00WEBPEXIF\xFF\xFF\xFF\xFF"),
+		"webp chunk beyond file":  webpFile(webpChunk("XMP ", []byte("SecretXMP")))[:25],
+		"png chunk beyond file":   testPNG(t, 6)[:40],
+		"webp header only broken": []byte("RIFF\x00\x00\x00\x00WEBP\x00"),
+	} {
+		if _, _, err := StripMetadata(data); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+
+	// No prefix of a valid image makes the parsers panic
+	for _, data := range [][]byte{testJPEG(t, 6), testPNG(t, 6), testWebP(6)} {
+		for n := range data {
+			StripMetadata(data[:n])
+		}
+	}
+}
+
+// testExif encodes TIFF data with a camera make, a GPS IFD and, unless
+// orientation is 0, the orientation.
+func testExif(orientation int) []byte {
+	be := binary.BigEndian
+	entries := 2
+	if orientation > 0 {
+		entries++
+	}
+	makeOffset := 8 + 2 + entries*12 + 4
+	gpsOffset := makeOffset + len("SecretCam\x00")
+
+	tiff := []byte("MM\x00\x2a")
+	tiff = be.AppendUint32(tiff, 8)
+	tiff = be.AppendUint16(tiff, uint16(entries))
+	if orientation > 0 {
+		tiff = be.AppendUint16(tiff, exifOrientation)
+		tiff = be.AppendUint16(tiff, 3)
+		tiff = be.AppendUint32(tiff, 1)
+		tiff = be.AppendUint16(tiff, uint16(orientation))
+		tiff = be.AppendUint16(tiff, 0)
+	}
+	// Make, ASCII stored after the IFD
+	tiff = be.AppendUint16(tiff, 0x010F)
+	tiff = be.AppendUint16(tiff, 2)
+	tiff = be.AppendUint32(tiff, uint32(len("SecretCam\x00")))
+	tiff = be.AppendUint32(tiff, uint32(makeOffset))
+	// Pointer to the GPS IFD
+	tiff = be.AppendUint16(tiff, 0x8825)
+	tiff = be.AppendUint16(tiff, 4)
+	tiff = be.AppendUint32(tiff, 1)
+	tiff = be.AppendUint32(tiff, uint32(gpsOffset))
+	tiff = be.AppendUint32(tiff, 0)
+
+	tiff = append(tiff, "SecretCam\x00"...)
+	// GPS IFD with a GPSAreaInformation entry
+	tiff = be.AppendUint16(tiff, 1)
+	tiff = be.AppendUint16(tiff, 0x001C)
+	tiff = be.AppendUint16(tiff, 7)
+	tiff = be.AppendUint32(tiff, uint32(len("SecretGPS")))
+	tiff = be.AppendUint32(tiff, uint32(gpsOffset+2+12+4))
+	tiff = be.AppendUint32(tiff, 0)
+	return append(tiff, "SecretGPS"...)
+}
+
+// testJPEG returns an encoded image with JFIF, EXIF, XMP, ICC, IPTC and Adobe
+// segments and a comment in front of its tables.
+func testJPEG(t *testing.T, orientation int) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, testImage(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, jpegSegment(0xE0, []byte("JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"))...)
+	out = append(out, jpegSegment(0xE1, append(append([]byte{}, exifHeader...), testExif(orientation)...))...)
+	out = append(out, jpegSegment(0xE1, []byte("http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta>SecretXMP</x:xmpmeta>"))...)
+	out = append(out, jpegSegment(0xE2, []byte("ICC_PROFILE\x00\x01\x01iccdata"))...)
+	out = append(out, jpegSegment(0xED, []byte("Photoshop 3.0\x008BIM\x04\x04SecretIPTC"))...)
+	out = append(out, jpegSegment(0xEE, []byte("Adobe\x00\x64\x00\x00\x00\x00\x01"))...)
+	out = append(out, jpegSegment(0xFE, []byte("SecretComment"))...)
+	return append(out, data[2:]...)
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xFF, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testPNG returns an encoded image with ICC, EXIF, text, XMP and time chunks
+// after its header.
+func testPNG(t *testing.T, orientation int) []byte {
+	t.Helper()
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	data := encoded.Bytes()
+	ihdrEnd := len(pngSignature) + 12 + 13
+
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	writePNGChunk(&out, "iCCP", []byte("icc\x00\x00iccdata"))
+	writePNGChunk(&out, "eXIf", testExif(orientation))
+	writePNGChunk(&out, "tEXt", []byte("Comment\x00SecretComment"))
+	writePNGChunk(&out, "iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00SecretXMP"))
+	writePNGChunk(&out, "tIME", []byte{0x07, 0xE8, 1, 1, 0, 0, 0})
+	out.Write(data[ihdrEnd:])
+	return out.Bytes()
+}
+
+const (
+	webpFlagICC  = 0x20
+	webpFlagXMP  = 0x04
+	webpFlagEXIF = 0x08
+)
+
+// testWebP returns an extended WebP file with ICC, EXIF and XMP chunks. The
+// image data has an odd size to check the padding.
+func testWebP(orientation int) []byte {
+	vp8x := []byte{webpFlagICC | webpFlagXMP | webpFlagEXIF, 0, 0, 0, 3, 0, 0, 3, 0, 0}
+	return webpFile(
+		webpChunk("VP8X", vp8x),
+		webpChunk("ICCP", []byte("iccdata")),
+		webpChunk("VP8L", []byte("\x2f\x03\x00\x00\x00")),
+		webpChunk("EXIF", append(append([]byte{}, exifHeader...), testExif(orientation)...)),
+		webpChunk("XMP ", []byte("<x:xmpmeta>SecretXMP</x:xmpmeta>")),
+	)
+}
+
+func webpChunk(fourCC string, payload []byte) []byte {
+	chunk := append([]byte(fourCC), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+func webpFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+	file := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(file, body...)
+}
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+	return img
+}
+
+func decodeJPEG(t *testing.T, out []byte) {
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped JPEG does not decode: %v", err)
+	}
+}
+
+func decodePNG(t *testing.T, out []byte) {
+	if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+		t.Errorf("stripped PNG does not decode: %v", err)
+	}
+}
+
+// checkWebP checks the RIFF size, the padding and the VP8X flags of a
+// stripped WebP file.
+func checkWebP(flags byte) func(t *testing.T, out []byte) {
+	return func(t *testing.T, out []byte) {
+		if size := binary.LittleEndian.Uint32(out[4:8]); int(size) != len(out)-8 {
+			t.Errorf("RIFF size %d, want %d", size, len(out)-8)
+		}
+		if len(out)%2 != 0 {
+			t.Errorf("odd file size %d", len(out))
+		}
+		if string(out[12:16]) != "VP8X" {
+			t.Fatalf("first chunk %q, want VP8X", out[12:16])
+		}
+		if out[20] != flags {
+			t.Errorf("VP8X flags %#x, want %#x", out[20], flags)
+		}
+	}
+}
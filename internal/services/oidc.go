@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ErrOIDCNoRole is returned when none of the user's groups is mapped to a role.
+var ErrOIDCNoRole = fmt.Errorf("no group of the account is mapped to an admin role")
+
+// OIDCIdentity is a verified identity returned by the identity provider.
+type OIDCIdentity struct {
+	Subject  string
+	Username string
+	Groups   []string
+	Role     string
+}
+
+// OIDCOptions configures the OIDC client. RoleMapping maps group names of the
+// groups claim to admin roles.
+type OIDCOptions struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	GroupsClaim  string
+	RoleMapping  map[string]string
+}
+
+type OIDCService struct {
+	provider    *oidc.Provider
+	verifier    *oidc.IDTokenVerifier
+	oauth2      oauth2.Config
+	groupsClaim string
+	roleMapping map[string]string
+}
+
+// NewOIDCService discovers the provider configuration of the issuer.
+func NewOIDCService(ctx context.Context, opts OIDCOptions) (*OIDCService, error) {
+	for group, role := range opts.RoleMapping {
+		if !validRole(role) {
+			return nil, fmt.Errorf("unknown role %q for group %q", role, group)
+		}
+	}
+
+	provider, err := oidc.NewProvider(ctx, opts.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	return &OIDCService{
+		provider: provider,
+		verifier: provider.Verifier(&oidc.Config{ClientID: opts.ClientID}),
+		oauth2: oauth2.Config{
+			ClientID:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			RedirectURL:  opts.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       opts.Scopes,
+		},
+		groupsClaim: opts.GroupsClaim,
+		roleMapping: opts.RoleMapping,
+	}, nil
+}
+
+// AuthCodeURL returns the provider login URL. The PKCE verifier and nonce must
+// be passed to Exchange again.
+func (s *OIDCService) AuthCodeURL(state, nonce, verifier string) string {
+	return s.oauth2.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchange redeems the authorization code, verifies the ID token and resolves
+// the admin role from the groups claim.
+func (s *OIDCService) Exchange(ctx context.Context, code, nonce, verifier string) (*OIDCIdentity, error) {
+	token, err := s.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("token response contains no id_token")
+	}
+
+	idToken, err := s.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, fmt.Errorf("id_token nonce does not match")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	}
+
+	// Some providers only expose groups through the userinfo endpoint
+	if _, ok := claims[s.groupsClaim]; !ok {
+		userInfo, err := s.provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err == nil {
+			var userClaims map[string]interface{}
+			if err := userInfo.Claims(&userClaims); err == nil && userClaims[s.groupsClaim] != nil {
+				claims[s.groupsClaim] = userClaims[s.groupsClaim]
+			}
+		}
+	}
+
+	identity := &OIDCIdentity{
+		Subject:  idToken.Subject,
+		Username: idToken.Subject,
+		Groups:   claimStrings(claims[s.groupsClaim]),
+	}
+	for _, claim := range []string{"preferred_username", "email"} {
+		if v, ok := claims[claim].(string); ok && v != "" {
+			identity.Username = v
+			break
+		}
+	}
+
+	identity.Role = s.RoleForGroups(identity.Groups)
+	if identity.Role == "" {
+		return identity, ErrOIDCNoRole
+	}
+
+	return identity, nil
+}
+
+// RoleForGroups returns the most privileged role mapped to any of the groups,
+// or an empty string if no group is mapped.
+func (s *OIDCService) RoleForGroups(groups []string) string {
+	for _, role := range AdminRoles {
+		for _, group := range groups {
+			if s.roleMapping[group] == role.Name {
+				return role.Name
+			}
+		}
+	}
+	return ""
+}
+
+// claimStrings accepts a claim that is either a single string or a list of strings.
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
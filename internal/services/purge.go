@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const purgeInterval = time.Hour
+
+// SharePurger deletes shares and their uploads once they have been expired
+// for longer than the grace period.
+type SharePurger struct {
+	shareService *ShareService
+	fileService  *FileService
+	grace        time.Duration
+}
+
+func NewSharePurger(shareService *ShareService, fileService *FileService, grace time.Duration) *SharePurger {
+	return &SharePurger{
+		shareService: shareService,
+		fileService:  fileService,
+		grace:        grace,
+	}
+}
+
+// Run purges expired shares every hour until the context is cancelled.
+func (p *SharePurger) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := p.PurgeOnce(ctx); err != nil {
+			log.Printf("Failed to purge expired shares: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d expired shares", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce deletes all shares that expired before the grace period and
+// returns how many were removed.
+func (p *SharePurger) PurgeOnce(ctx context.Context) (int, error) {
+	shares, err := p.shareService.ListExpiredBefore(ctx, time.Now().Add(-p.grace))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, share := range shares {
+		if err := p.fileService.PurgeShare(ctx, p.shareService, share.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}
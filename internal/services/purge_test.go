@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/repository"
+)
+
+func TestPurgeShareRemovesQuarantinedFiles(t *testing.T) {
+	for name, purge := range map[string]func(ctx context.Context, shares *ShareService, files *FileService, share *database.Share) error{
+		"trash": func(ctx context.Context, shares *ShareService, files *FileService, share *database.Share) error {
+			return NewTrashService(shares, files, time.Hour).PurgeShare(ctx, share.ID)
+		},
+		"expired": func(ctx context.Context, shares *ShareService, files *FileService, share *database.Share) error {
+			n, err := NewSharePurger(shares, files, time.Hour).PurgeOnce(ctx)
+			if err == nil && n != 1 {
+				t.Errorf("expired: purged %d shares, want 1", n)
+			}
+			return err
+		},
+	} {
+		ctx := context.Background()
+		db := newTestDB(t)
+		share := createTestShare(t, db)
+		if _, err := db.Exec("UPDATE shares SET expires_at = ? WHERE id = ?", time.Now().Add(-2*time.Hour).UTC(), share.ID); err != nil {
+			t.Fatalf("%s: expire share: %v", name, err)
+		}
+
+		clamd := newFakeClamd(t)
+		clamd.infected["EICAR"] = "Eicar-Signature"
+		files, dataDir := newTestFileService(t, db, clamd.scanner(t, time.Second), false, false)
+		stmts := repository.NewStatements(db, 0)
+		t.Cleanup(func() { stmts.Close() })
+		shares := NewShareService(repository.NewShareRepository(stmts))
+
+		uploadTestFile(t, files, share.ID, "clean.txt", []byte("harmless"))
+		trashed := uploadTestFile(t, files, share.ID, "trashed.txt", []byte("harmless"))
+		if err := files.Delete(ctx, trashed.ID); err != nil {
+			t.Fatalf("%s: delete file: %v", name, err)
+		}
+		infected := uploadTestFile(t, files, share.ID, "infected.txt", []byte("contains EICAR test"))
+		if !infected.Quarantined() {
+			t.Fatalf("%s: file not quarantined", name)
+		}
+
+		if err := purge(ctx, shares, files, share); err != nil {
+			t.Fatalf("%s: purge: %v", name, err)
+		}
+
+		// No stored file is left behind, including the quarantined one
+		filepath.WalkDir(dataDir, func(path string, entry fs.DirEntry, err error) error {
+			if err == nil && !entry.IsDir() {
+				t.Errorf("%s: %s left on disk", name, path)
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+const reviewerColumns = "id, share_id, name, email, permission, verified_at, revoked_at, created_at"
+
+type ReviewerService struct {
+	db      *database.DB
+	mailer  *MailService
+	baseURL string
+}
+
+func NewReviewerService(db *database.DB, mailer *MailService, baseURL string) *ReviewerService {
+	return &ReviewerService{
+		db:      db,
+		mailer:  mailer,
+		baseURL: baseURL,
+	}
+}
+
+// Invite adds a reviewer to the share and emails them a personal magic link.
+// Inviting an existing reviewer again replaces their link and lifts a revocation.
+func (s *ReviewerService) Invite(ctx context.Context, share *database.Share, name, email, permission string) (*database.Reviewer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if !validPermission(permission) {
+		return nil, fmt.Errorf("unknown permission %q", permission)
+	}
+
+	token, err := GenerateHash(32)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM reviewers WHERE share_id = ? AND email = ?", share.ID, email).Scan(&id)
+	switch {
+	case err == sql.ErrNoRows:
+		id, err = s.db.InsertContext(ctx,
+			"INSERT INTO reviewers (share_id, name, email, permission, token_hash) VALUES (?, ?, ?, ?, ?)",
+			share.ID, name, email, permission, hashToken(token),
+		)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		_, err := s.db.ExecContext(ctx,
+			"UPDATE reviewers SET name = ?, permission = ?, token_hash = ?, revoked_at = NULL WHERE id = ?",
+			name, permission, hashToken(token), id,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	reviewer, err := s.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := MailMessage{
+		To:      reviewer.Email,
+		Subject: "You are invited to review " + share.Name,
+		Body: fmt.Sprintf(
+			"Hi %s,\n\nyou are invited to review \"%s\". Open your personal link to view the files and leave feedback:\n\n%s\n\nDo not forward this link, comments made with it are shown under your name.",
+			reviewer.Name, share.Name, s.baseURL+"/review/"+token,
+		),
+	}
+	if err := s.mailer.Send(msg); err != nil {
+		return nil, fmt.Errorf("failed to send invitation: %w", err)
+	}
+
+	return reviewer, nil
+}
+
+// Authenticate resolves a magic link token to its reviewer. Tokens of revoked
+// reviewers are reported as sql.ErrNoRows.
+func (s *ReviewerService) Authenticate(ctx context.Context, token string) (*database.Reviewer, error) {
+	reviewer, err := s.getBy(ctx, "token_hash", hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	if reviewer.RevokedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	if reviewer.VerifiedAt == nil {
+		now := time.Now().UTC()
+		if _, err := s.db.ExecContext(ctx, "UPDATE reviewers SET verified_at = ? WHERE id = ?", now, reviewer.ID); err != nil {
+			return nil, err
+		}
+		reviewer.VerifiedAt = &now
+	}
+
+	return reviewer, nil
+}
+
+func (s *ReviewerService) GetByID(ctx context.Context, id int) (*database.Reviewer, error) {
+	return s.getBy(ctx, "id", id)
+}
+
+func (s *ReviewerService) getBy(ctx context.Context, column string, value interface{}) (*database.Reviewer, error) {
+	reviewer := &database.Reviewer{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT "+reviewerColumns+" FROM reviewers WHERE "+column+" = ?",
+		value,
+	).Scan(&reviewer.ID, &reviewer.ShareID, &reviewer.Name, &reviewer.Email, &reviewer.Permission, &reviewer.VerifiedAt, &reviewer.RevokedAt, &reviewer.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return reviewer, nil
+}
+
+// ListByShareID returns the reviewers of a share including revoked ones.
+func (s *ReviewerService) ListByShareID(ctx context.Context, shareID int) ([]database.Reviewer, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+reviewerColumns+" FROM reviewers WHERE share_id = ? ORDER BY name ASC, id ASC",
+		shareID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reviewers []database.Reviewer
+	for rows.Next() {
+		var r database.Reviewer
+		if err := rows.Scan(&r.ID, &r.ShareID, &r.Name, &r.Email, &r.Permission, &r.VerifiedAt, &r.RevokedAt, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		reviewers = append(reviewers, r)
+	}
+
+	return reviewers, nil
+}
+
+// Revoke signs the reviewer out and disables their magic link. Their comments
+// are kept.
+func (s *ReviewerService) Revoke(ctx context.Context, shareID, id int) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE reviewers SET revoked_at = ? WHERE id = ? AND share_id = ? AND revoked_at IS NULL",
+		time.Now().UTC(), id, shareID,
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
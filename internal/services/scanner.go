@@ -0,0 +1,116 @@
+package services
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Scanner checks uploaded files for malware.
+type Scanner interface {
+	Scan(r io.Reader) (*ScanResult, error)
+}
+
+// ScanResult is the verdict of a scan. Signature names the detected malware.
+type ScanResult struct {
+	Infected  bool
+	Signature string
+}
+
+// clamdChunkSize is the size of the chunks streamed to clamd. It must stay
+// below clamd's StreamMaxLength.
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner scans files with a ClamAV daemon using the INSTREAM command.
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd at address, either
+// "tcp://host:port", "unix:///path/to/clamd.sock", a plain "host:port" or a
+// socket path. The timeout limits a whole scan.
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	s := &ClamdScanner{timeout: timeout}
+
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		s.network, s.address = "tcp", strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		s.network, s.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "/"):
+		s.network, s.address = "unix", address
+	default:
+		s.network, s.address = "tcp", address
+	}
+
+	if s.address == "" {
+		return nil, fmt.Errorf("missing clamd address")
+	}
+	return s, nil
+}
+
+// Scan streams the content to clamd and parses its verdict.
+func (s *ClamdScanner) Scan(r io.Reader) (*ScanResult, error) {
+	conn, err := net.DialTimeout(s.network, s.address, s.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(s.timeout)); err != nil {
+		return nil, err
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("failed to send command to clamd: %w", err)
+	}
+
+	// Each chunk is prefixed with its length, a zero length ends the stream
+	buf := make([]byte, 4+clamdChunkSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, werr := conn.Write(buf[:4+n]); werr != nil {
+				return nil, fmt.Errorf("failed to stream file to clamd: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("failed to stream file to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return nil, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply parses replies like "stream: OK" and
+// "stream: Eicar-Signature FOUND".
+func parseClamdReply(reply string) (*ScanResult, error) {
+	result := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case result == "OK":
+		return &ScanResult{}, nil
+	case strings.HasSuffix(result, " FOUND"):
+		return &ScanResult{Infected: true, Signature: strings.TrimSuffix(result, " FOUND")}, nil
+	default:
+		return nil, fmt.Errorf("clamd error: %s", reply)
+	}
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/repository"
+)
+
+// fakeClamd answers INSTREAM commands like clamd. The reply is "stream: OK",
+// "stream: <signature> FOUND" if the stream contains an infected marker, or
+// the configured error reply. With hang set it never replies.
+type fakeClamd struct {
+	listener net.Listener
+
+	mu       sync.Mutex
+	infected map[string]string // marker in the content to signature
+	errReply string
+	hang     bool
+	streams  [][]byte
+}
+
+func newFakeClamd(t *testing.T) *fakeClamd {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	c := &fakeClamd{listener: listener, infected: map[string]string{}}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go c.serve(conn)
+		}
+	}()
+
+	return c
+}
+
+func (c *fakeClamd) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	command, err := r.ReadString(0)
+	if err != nil || command != "zINSTREAM\x00" {
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+		return
+	}
+
+	var stream bytes.Buffer
+	for {
+		var size uint32
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return
+		}
+		if size == 0 {
+			break
+		}
+		if size > clamdChunkSize {
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+			return
+		}
+		if _, err := io.CopyN(&stream, r, int64(size)); err != nil {
+			return
+		}
+	}
+
+	c.mu.Lock()
+	c.streams = append(c.streams, stream.Bytes())
+	hang, errReply := c.hang, c.errReply
+	reply := "stream: OK"
+	for marker, signature := range c.infected {
+		if bytes.Contains(stream.Bytes(), []byte(marker)) {
+			reply = "stream: " + signature + " FOUND"
+		}
+	}
+	c.mu.Unlock()
+
+	if hang {
+		// Keep the connection open without replying until the client gives up
+		io.Copy(io.Discard, r)
+		return
+	}
+	if errReply != "" {
+		reply = errReply
+	}
+	conn.Write([]byte(reply + "\x00"))
+}
+
+func (c *fakeClamd) scanner(t *testing.T, timeout time.Duration) *ClamdScanner {
+	t.Helper()
+
+	scanner, err := NewClamdScanner("tcp://"+c.listener.Addr().String(), timeout)
+	if err != nil {
+		t.Fatalf("create scanner: %v", err)
+	}
+	return scanner
+}
+
+func (c *fakeClamd) set(fn func(c *fakeClamd)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(c)
+}
+
+func (c *fakeClamd) lastStream() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.streams) == 0 {
+		return nil
+	}
+	return c.streams[len(c.streams)-1]
+}
+
+func TestClamdScannerReplies(t *testing.T) {
+	clamd := newFakeClamd(t)
+	clamd.infected["EICAR"] = "Eicar-Signature"
+	scanner := clamd.scanner(t, time.Second)
+
+	result, err := scanner.Scan(strings.NewReader("harmless"))
+	if err != nil || result.Infected {
+		t.Fatalf("clean file: result %+v, error %v", result, err)
+	}
+
+	result, err = scanner.Scan(strings.NewReader("contains EICAR test"))
+	if err != nil || !result.Infected || result.Signature != "Eicar-Signature" {
+		t.Fatalf("infected file: result %+v, error %v", result, err)
+	}
+
+	clamd.set(func(c *fakeClamd) { c.errReply = "INSTREAM size limit exceeded. ERROR" })
+	if _, err := scanner.Scan(strings.NewReader("harmless")); err == nil || !strings.Contains(err.Error(), "size limit exceeded") {
+		t.Fatalf("error reply: error %v", err)
+	}
+}
+
+func TestClamdScannerStreamsChunks(t *testing.T) {
+	clamd := newFakeClamd(t)
+	scanner := clamd.scanner(t, time.Second)
+
+	// Larger than a chunk, so the content is split
+	content := bytes.Repeat([]byte("0123456789"), clamdChunkSize/4)
+	if _, err := scanner.Scan(bytes.NewReader(content)); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if !bytes.Equal(clamd.lastStream(), content) {
+		t.Errorf("clamd received %d bytes, want %d", len(clamd.lastStream()), len(content))
+	}
+}
+
+func TestClamdScannerTimeout(t *testing.T) {
+	clamd := newFakeClamd(t)
+	clamd.hang = true
+	scanner := clamd.scanner(t, 200*time.Millisecond)
+
+	start := time.Now()
+	if _, err := scanner.Scan(strings.NewReader("harmless")); err == nil {
+		t.Fatal("scan without reply succeeded")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("scan gave up after %v", elapsed)
+	}
+}
+
+func TestClamdScannerUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	scanner, err := NewClamdScanner(address, time.Second)
+	if err != nil {
+		t.Fatalf("create scanner: %v", err)
+	}
+	if _, err := scanner.Scan(strings.NewReader("harmless")); err == nil {
+		t.Fatal("scan without clamd succeeded")
+	}
+}
+
+// newTestFileService creates a file service storing uploads in a temporary
+// directory.
+func newTestFileService(t testing.TB, db *database.DB, scanner Scanner, stripMetadata, keepOriginals bool) (*FileService, string) {
+	t.Helper()
+
+	stmts := repository.NewStatements(db, 0)
+	t.Cleanup(func() { stmts.Close() })
+
+	dataDir := t.TempDir()
+	return NewFileService(repository.NewFileRepository(stmts), repository.NewCommentRepository(stmts), dataDir, scanner, stripMetadata, keepOriginals), dataDir
+}
+
+// uploadTestFile saves content as an upload of the share.
+func uploadTestFile(t *testing.T, files *FileService, shareID int, filename string, content []byte) *database.File {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("files", filename)
+	if err != nil {
+		t.Fatalf("create form file: %v", err)
+	}
+	part.Write(content)
+	writer.Close()
+
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Type", writer.FormDataContentType())
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("parse form: %v", err)
+	}
+
+	file, err := files.Save(context.Background(), shareID, r.MultipartForm.File["files"][0])
+	if err != nil {
+		t.Fatalf("save file: %v", err)
+	}
+	return file
+}
+
+func TestFileServiceScanVerdicts(t *testing.T) {
+	db := newTestDB(t)
+	share := createTestShare(t, db)
+	clamd := newFakeClamd(t)
+	clamd.infected["EICAR"] = "Eicar-Signature"
+	files, dataDir := newTestFileService(t, db, clamd.scanner(t, time.Second), false, false)
+
+	clean := uploadTestFile(t, files, share.ID, "clean.txt", []byte("harmless"))
+	if clean.ScanStatus != database.ScanStatusClean || clean.ScannedAt == nil || clean.Blocked() {
+		t.Errorf("clean file: status %q, scanned at %v", clean.ScanStatus, clean.ScannedAt)
+	}
+
+	infected := uploadTestFile(t, files, share.ID, "infected.txt", []byte("contains EICAR test"))
+	if infected.ScanStatus != database.ScanStatusInfected || !infected.Quarantined() {
+		t.Fatalf("infected file: status %q", infected.ScanStatus)
+	}
+	if infected.ScanSignature == nil || *infected.ScanSignature != "Eicar-Signature" {
+		t.Errorf("infected file: signature %v", infected.ScanSignature)
+	}
+
+	// The infected file was moved out of the uploads into the quarantine
+	if dir := filepath.Dir(infected.StoragePath); dir != filepath.Join(dataDir, "quarantine") {
+		t.Errorf("infected file stored in %s", dir)
+	}
+	if content, err := os.ReadFile(infected.StoragePath); err != nil || string(content) != "contains EICAR test" {
+		t.Errorf("quarantined file: %q, %v", content, err)
+	}
+	uploads, _ := filepath.Glob(filepath.Join(dataDir, "uploads", "*", "*infected.txt"))
+	if len(uploads) != 0 {
+		t.Errorf("infected file left in uploads: %v", uploads)
+	}
+}
+
+func TestFileServiceScanFailureKeepsFilePending(t *testing.T) {
+	db := newTestDB(t)
+	share := createTestShare(t, db)
+	clamd := newFakeClamd(t)
+	files, _ := newTestFileService(t, db, clamd.scanner(t, 200*time.Millisecond), false, false)
+
+	for name, configure := range map[string]func(c *fakeClamd){
+		"error reply": func(c *fakeClamd) { c.errReply = "INSTREAM size limit exceeded. ERROR" },
+		"timeout":     func(c *fakeClamd) { c.hang = true },
+	} {
+		clamd.set(configure)
+		file := uploadTestFile(t, files, share.ID, "file.txt", []byte("harmless"))
+		if file.ScanStatus != database.ScanStatusPending || !file.Blocked() {
+			t.Errorf("%s: status %q, want pending", name, file.ScanStatus)
+		}
+		if _, err := os.Stat(file.StoragePath); err != nil {
+			t.Errorf("%s: file not kept: %v", name, err)
+		}
+
+		// Scanning again once clamd works records the verdict
+		clamd.set(func(c *fakeClamd) { c.errReply, c.hang = "", false })
+		if err := files.Scan(context.Background(), file.ID); err != nil {
+			t.Fatalf("%s: rescan: %v", name, err)
+		}
+		file, err := files.GetByID(context.Background(), file.ID)
+		if err != nil {
+			t.Fatalf("%s: load file: %v", name, err)
+		}
+		if file.ScanStatus != database.ScanStatusClean {
+			t.Errorf("%s: status after rescan %q, want clean", name, file.ScanStatus)
+		}
+	}
+}
+
+func TestFileServiceScansKeptOriginal(t *testing.T) {
+	db := newTestDB(t)
+	share := createTestShare(t, db)
+	clamd := newFakeClamd(t)
+	clamd.infected["EICAR"] = "Eicar-Signature"
+	files, dataDir := newTestFileService(t, db, clamd.scanner(t, time.Second), true, true)
+
+	// The marker is hidden in a JPEG comment, which stripping removes from
+	// the served file but not from the kept original
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, image.NewGray(image.Rect(0, 0, 1, 1)), nil); err != nil {
+		t.Fatalf("encode image: %v", err)
+	}
+	comment := []byte("contains EICAR test")
+	content := append([]byte{0xFF, 0xD8, 0xFF, 0xFE, 0, byte(len(comment) + 2)}, comment...)
+	content = append(content, encoded.Bytes()[2:]...)
+
+	file := uploadTestFile(t, files, share.ID, "photo.jpg", content)
+	if file.OriginalPath == nil {
+		t.Fatal("original not kept")
+	}
+	if served, _ := os.ReadFile(file.StoragePath); bytes.Contains(served, []byte("EICAR")) {
+		t.Fatal("comment not stripped from served file")
+	}
+	if file.ScanStatus != database.ScanStatusInfected || !file.Quarantined() {
+		t.Fatalf("status %q, want infected", file.ScanStatus)
+	}
+
+	// Both the served file and the original were moved to the quarantine
+	if dir := filepath.Dir(file.StoragePath); dir != filepath.Join(dataDir, "quarantine") {
+		t.Errorf("file stored in %s", dir)
+	}
+	if dir := filepath.Dir(*file.OriginalPath); dir != filepath.Join(dataDir, "quarantine", "originals") {
+		t.Errorf("original stored in %s", dir)
+	}
+	if original, err := os.ReadFile(*file.OriginalPath); err != nil || !bytes.Equal(original, content) {
+		t.Errorf("quarantined original: %v", err)
+	}
+}
@@ -1,127 +1,201 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/repository"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type ShareService struct {
-	db *sql.DB
+	shares *repository.ShareRepository
 }
 
-func NewShareService(db *sql.DB) *ShareService {
-	return &ShareService{db: db}
+func NewShareService(shares *repository.ShareRepository) *ShareService {
+	return &ShareService{shares: shares}
 }
 
-func (s *ShareService) Create(name, description string) (*database.Share, error) {
-	// Generate unique hash
-	var hash string
-	for {
-		var err error
-		hash, err = GenerateHash(12)
-		if err != nil {
-			return nil, err
-		}
+// ShareOptions are the access settings a share is created with.
+type ShareOptions struct {
+	Password   string
+	ExpiresAt  *time.Time
+	InviteOnly bool
+}
 
-		// Check if hash already exists
-		var exists bool
-		err = s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM shares WHERE hash = ?)", hash).Scan(&exists)
+// Create stores a new share. ownerID may be nil for shares without an owner,
+// which only owner admins can see. The share is stored with its options in
+// one statement, so it is never reachable without its protection.
+func (s *ShareService) Create(ctx context.Context, name, description string, ownerID *int, options ShareOptions) (*database.Share, error) {
+	var passwordHash *string
+	if options.Password != "" {
+		hash, err := hashPassword(options.Password)
 		if err != nil {
 			return nil, err
 		}
-		if !exists {
-			break
-		}
+		passwordHash = &hash
 	}
 
-	result, err := s.db.Exec(
-		"INSERT INTO shares (hash, name, description) VALUES (?, ?, ?)",
-		hash, name, description,
-	)
+	hash, err := s.uniqueHash(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	id, err := result.LastInsertId()
+	id, err := s.shares.Create(ctx, hash, name, description, ownerID, passwordHash, options.ExpiresAt, options.InviteOnly)
 	if err != nil {
 		return nil, err
 	}
 
-	return s.GetByID(int(id))
+	return s.GetByID(ctx, id)
 }
 
-func (s *ShareService) GetByID(id int) (*database.Share, error) {
-	share := &database.Share{}
-	err := s.db.QueryRow(
-		"SELECT id, hash, name, description, created_at, updated_at FROM shares WHERE id = ?",
-		id,
-	).Scan(&share.ID, &share.Hash, &share.Name, &share.Description, &share.CreatedAt, &share.UpdatedAt)
-	if err != nil {
-		return nil, err
+// uniqueHash generates a hash that is used neither by a share nor a share link.
+func (s *ShareService) uniqueHash(ctx context.Context) (string, error) {
+	for {
+		hash, err := GenerateHash(12)
+		if err != nil {
+			return "", err
+		}
+
+		// Check if hash already exists
+		taken, err := s.shares.HashTaken(ctx, hash)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return hash, nil
+		}
 	}
-	return share, nil
 }
 
-func (s *ShareService) GetByHash(hash string) (*database.Share, error) {
-	share := &database.Share{}
-	err := s.db.QueryRow(
-		"SELECT id, hash, name, description, created_at, updated_at FROM shares WHERE hash = ?",
-		hash,
-	).Scan(&share.ID, &share.Hash, &share.Name, &share.Description, &share.CreatedAt, &share.UpdatedAt)
-	if err != nil {
-		return nil, err
-	}
-	return share, nil
-}
-
-func (s *ShareService) List() ([]database.ShareWithStats, error) {
-	rows, err := s.db.Query(`
-		SELECT
-			s.id, s.hash, s.name, s.description, s.created_at, s.updated_at,
-			COUNT(DISTINCT f.id) as file_count,
-			COUNT(DISTINCT c.id) as comment_count
-		FROM shares s
-		LEFT JOIN files f ON s.id = f.share_id
-		LEFT JOIN comments c ON f.id = c.file_id
-		GROUP BY s.id
-		ORDER BY s.created_at DESC
-	`)
-	if err != nil {
+// GetByID returns a share that is not in the trash.
+func (s *ShareService) GetByID(ctx context.Context, id int) (*database.Share, error) {
+	return s.shares.GetByID(ctx, id)
+}
+
+func (s *ShareService) GetByHash(ctx context.Context, hash string) (*database.Share, error) {
+	return s.shares.GetByHash(ctx, hash)
+}
+
+// GetDeleted returns a share from the trash.
+func (s *ShareService) GetDeleted(ctx context.Context, id int) (*database.Share, error) {
+	return s.shares.GetDeleted(ctx, id)
+}
+
+func (s *ShareService) List(ctx context.Context) ([]database.ShareWithStats, error) {
+	return s.shares.List(ctx)
+}
+
+// ListOwnedBy returns the shares assigned to an admin.
+func (s *ShareService) ListOwnedBy(ctx context.Context, adminID int) ([]database.ShareWithStats, error) {
+	return s.shares.ListOwnedBy(ctx, adminID)
+}
+
+// ListPage returns a window of shares ordered like List.
+func (s *ShareService) ListPage(ctx context.Context, limit, offset int) ([]database.ShareWithStats, error) {
+	return s.shares.ListPage(ctx, limit, offset)
+}
+
+// ListDeleted returns the shares in the trash.
+func (s *ShareService) ListDeleted(ctx context.Context) ([]database.ShareWithStats, error) {
+	return s.shares.ListDeleted(ctx)
+}
+
+func (s *ShareService) Count(ctx context.Context) (int, error) {
+	return s.shares.Count(ctx)
+}
+
+func (s *ShareService) Update(ctx context.Context, id int, name, description string) (*database.Share, error) {
+	if err := s.shares.Update(ctx, id, name, description); err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var shares []database.ShareWithStats
-	for rows.Next() {
-		var s database.ShareWithStats
-		err := rows.Scan(
-			&s.ID, &s.Hash, &s.Name, &s.Description, &s.CreatedAt, &s.UpdatedAt,
-			&s.FileCount, &s.CommentCount,
-		)
+
+	return s.GetByID(ctx, id)
+}
+
+// SetOwner assigns the share to an admin, or removes the owner if ownerID is nil.
+func (s *ShareService) SetOwner(ctx context.Context, id int, ownerID *int) error {
+	return s.shares.SetOwner(ctx, id, ownerID)
+}
+
+// SetPassword protects the share with a password, or removes the protection
+// if password is empty. Changing the password locks out existing visitors.
+func (s *ShareService) SetPassword(ctx context.Context, id int, password string) error {
+	var passwordHash *string
+	if password != "" {
+		hash, err := hashPassword(password)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		shares = append(shares, s)
+		passwordHash = &hash
 	}
 
-	return shares, nil
+	return s.shares.SetPasswordHash(ctx, id, passwordHash)
 }
 
-func (s *ShareService) Delete(id int) error {
-	result, err := s.db.Exec("DELETE FROM shares WHERE id = ?", id)
-	if err != nil {
-		return err
+// SetExpiry sets the time after which the share is no longer accessible, or
+// removes the expiry if expiresAt is nil.
+func (s *ShareService) SetExpiry(ctx context.Context, id int, expiresAt *time.Time) error {
+	return s.shares.SetExpiry(ctx, id, expiresAt)
+}
+
+// SetInviteOnly restricts the share to invited reviewers, or opens it again to
+// everyone with a link.
+func (s *ShareService) SetInviteOnly(ctx context.Context, id int, inviteOnly bool) error {
+	return s.shares.SetInviteOnly(ctx, id, inviteOnly)
+}
+
+// ListExpiredBefore returns the shares that expired before the given time,
+// including shares in the trash.
+func (s *ShareService) ListExpiredBefore(ctx context.Context, before time.Time) ([]database.Share, error) {
+	return s.shares.ListExpiredBefore(ctx, before)
+}
+
+// ListDeletedBefore returns the shares moved to the trash before the given time.
+func (s *ShareService) ListDeletedBefore(ctx context.Context, before time.Time) ([]database.Share, error) {
+	return s.shares.ListDeletedBefore(ctx, before)
+}
+
+// CheckPassword reports whether the password unlocks the share.
+func (s *ShareService) CheckPassword(share *database.Share, password string) bool {
+	if !share.HasPassword() {
+		return true
 	}
+	return bcrypt.CompareHashAndPassword([]byte(*share.PasswordHash), []byte(password)) == nil
+}
 
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return err
+// UnlockFingerprint identifies the current password of a share. It is stored
+// in the visitor session on unlock and no longer matches once the password changes.
+func UnlockFingerprint(share *database.Share) string {
+	if !share.HasPassword() {
+		return ""
 	}
-	if rows == 0 {
+	return hashToken(*share.PasswordHash)
+}
+
+// Delete moves the share to the trash. Its files and comments stay untouched
+// and come back when the share is restored.
+func (s *ShareService) Delete(ctx context.Context, id int) error {
+	return shareNotFound(s.shares.SetDeleted(ctx, id, true))
+}
+
+// Restore moves the share out of the trash.
+func (s *ShareService) Restore(ctx context.Context, id int) error {
+	return shareNotFound(s.shares.SetDeleted(ctx, id, false))
+}
+
+// Purge permanently deletes the share with its files and comments. Use
+// FileService.PurgeShare to remove the uploads from disk as well.
+func (s *ShareService) Purge(ctx context.Context, id int) error {
+	return shareNotFound(s.shares.Delete(ctx, id))
+}
+
+func shareNotFound(err error) error {
+	if err == sql.ErrNoRows {
 		return fmt.Errorf("share not found")
 	}
-
-	return nil
+	return err
 }
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+// CreateLink adds an access link with its own hash and permission to a share.
+func (s *ShareService) CreateLink(ctx context.Context, shareID int, label, permission string) (*database.ShareLink, error) {
+	label = strings.TrimSpace(label)
+	if label == "" {
+		return nil, fmt.Errorf("label is required")
+	}
+	if !validPermission(permission) {
+		return nil, fmt.Errorf("unknown permission %q", permission)
+	}
+
+	hash, err := s.uniqueHash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := s.shares.CreateLink(ctx, shareID, hash, label, permission)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.shares.GetLinkByID(ctx, id)
+}
+
+// ListLinks returns the access links of a share including revoked ones.
+func (s *ShareService) ListLinks(ctx context.Context, shareID int) ([]database.ShareLink, error) {
+	return s.shares.ListLinks(ctx, shareID)
+}
+
+// RevokeLink disables an access link of the share. Revoked links are kept so
+// they are listed, but no longer open the share.
+func (s *ShareService) RevokeLink(ctx context.Context, shareID, linkID int) error {
+	return s.shares.RevokeLink(ctx, shareID, linkID)
+}
+
+// Resolve looks up the share reached through a hash. The share's own hash
+// allows commenting, access links grant their own permission. Revoked links
+// are reported as sql.ErrNoRows.
+func (s *ShareService) Resolve(ctx context.Context, hash string) (*database.ShareAccess, error) {
+	share, err := s.GetByHash(ctx, hash)
+	if err == nil {
+		return &database.ShareAccess{Share: share, Hash: hash, Permission: database.PermissionComment}, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	link, err := s.shares.GetLinkByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt != nil {
+		return nil, sql.ErrNoRows
+	}
+
+	share, err = s.GetByID(ctx, link.ShareID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &database.ShareAccess{Share: share, Link: link, Hash: hash, Permission: link.Permission}, nil
+}
+
+func validPermission(permission string) bool {
+	for _, p := range database.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,444 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+// confirmResendCooldown is the minimum time between two confirmation mails of
+// a pending subscription, so subscribing repeatedly cannot flood an address.
+const confirmResendCooldown = 15 * time.Minute
+
+const subscriptionColumns = "id, share_id, file_id, email, access_hash, reviewer_id, unlock_fingerprint, confirm_token, unsubscribe_token, confirmed_at, confirm_sent_at, created_at"
+
+// subscriptionFields returns the scan destinations of subscriptionColumns.
+func subscriptionFields(sub *database.Subscription) []interface{} {
+	return []interface{}{&sub.ID, &sub.ShareID, &sub.FileID, &sub.Email, &sub.AccessHash, &sub.ReviewerID, &sub.UnlockFingerprint, &sub.ConfirmToken, &sub.UnsubscribeToken, &sub.ConfirmedAt, &sub.ConfirmSentAt, &sub.CreatedAt}
+}
+
+type SubscriptionService struct {
+	db        *database.DB
+	shares    *ShareService
+	reviewers *ReviewerService
+	mailer    *MailService
+	baseURL   string
+}
+
+func NewSubscriptionService(db *database.DB, shares *ShareService, reviewers *ReviewerService, mailer *MailService, baseURL string) *SubscriptionService {
+	return &SubscriptionService{
+		db:        db,
+		shares:    shares,
+		reviewers: reviewers,
+		mailer:    mailer,
+		baseURL:   baseURL,
+	}
+}
+
+// NormalizeEmail validates an email address and returns it in lowercase.
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil {
+		return "", fmt.Errorf("invalid email address")
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// Subscribe creates a pending subscription to a share, or to a single file if
+// file is not nil, and sends the double opt-in confirmation link. The access
+// the visitor opened the share with is kept: notifications link to its hash so
+// they grant no more than the visitor's link, and stop once its link or
+// reviewer is revoked or the share password changes. The confirmation mail of
+// a pending subscription is resent at most once per cooldown.
+func (s *SubscriptionService) Subscribe(ctx context.Context, access *database.ShareAccess, file *database.File, email string) (*database.Subscription, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	share := access.Share
+
+	var fileID *int
+	if file != nil {
+		fileID = &file.ID
+	}
+
+	// Reuse an existing subscription for the same target
+	sub, err := s.find(ctx, share.ID, fileID, email)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	var linkHash *string
+	if access.Link != nil {
+		linkHash = &access.Hash
+	}
+	var reviewerID *int
+	if access.Reviewer != nil {
+		reviewerID = &access.Reviewer.ID
+	}
+	var fingerprint *string
+	if share.HasPassword() {
+		f := UnlockFingerprint(share)
+		fingerprint = &f
+	}
+
+	if sub == nil {
+		confirmToken, err := GenerateHash(32)
+		if err != nil {
+			return nil, err
+		}
+		unsubscribeToken, err := GenerateHash(32)
+		if err != nil {
+			return nil, err
+		}
+
+		id, err := s.db.InsertContext(ctx,
+			"INSERT INTO subscriptions (share_id, file_id, email, access_hash, reviewer_id, unlock_fingerprint, confirm_token, unsubscribe_token) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			share.ID, fileID, email, linkHash, reviewerID, fingerprint, confirmToken, unsubscribeToken,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sub, err = s.getBy(ctx, "id", id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Subscribing again moves the subscription to the current access
+		_, err := s.db.ExecContext(ctx,
+			"UPDATE subscriptions SET access_hash = ?, reviewer_id = ?, unlock_fingerprint = ? WHERE id = ?",
+			linkHash, reviewerID, fingerprint, sub.ID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sub.AccessHash, sub.ReviewerID, sub.UnlockFingerprint = linkHash, reviewerID, fingerprint
+	}
+
+	if sub.ConfirmedAt != nil {
+		return sub, nil
+	}
+
+	// Claim the mail so concurrent requests cannot send it twice
+	now := time.Now().UTC()
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE subscriptions SET confirm_sent_at = ? WHERE id = ? AND (confirm_sent_at IS NULL OR confirm_sent_at < ?)",
+		now, sub.ID, now.Add(-confirmResendCooldown),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if rows == 0 {
+		return sub, nil
+	}
+	sub.ConfirmSentAt = &now
+
+	target := fmt.Sprintf("the share \"%s\"", share.Name)
+	if file != nil {
+		target = fmt.Sprintf("the file \"%s\" in \"%s\"", file.Filename, share.Name)
+	}
+
+	// The unsubscribe link lets recipients who did not subscribe stop the mails
+	s.send(*sub, MailMessage{
+		To:      sub.Email,
+		Subject: "Confirm your subscription to " + share.Name,
+		Body: fmt.Sprintf(
+			"Please confirm that you want to receive notifications about %s by opening this link:\n\n%s\n\nIf you did not request this, you can ignore this email.",
+			target, s.baseURL+"/subscriptions/confirm/"+sub.ConfirmToken,
+		),
+	})
+
+	return sub, nil
+}
+
+func (s *SubscriptionService) Confirm(ctx context.Context, token string) (*database.Subscription, error) {
+	sub, err := s.getBy(ctx, "confirm_token", token)
+	if err != nil {
+		return nil, err
+	}
+
+	if sub.ConfirmedAt == nil {
+		now := time.Now().UTC()
+		if _, err := s.db.ExecContext(ctx, "UPDATE subscriptions SET confirmed_at = ? WHERE id = ?", now, sub.ID); err != nil {
+			return nil, err
+		}
+		sub.ConfirmedAt = &now
+	}
+
+	return sub, nil
+}
+
+func (s *SubscriptionService) GetByUnsubscribeToken(ctx context.Context, token string) (*database.Subscription, error) {
+	return s.getBy(ctx, "unsubscribe_token", token)
+}
+
+func (s *SubscriptionService) Unsubscribe(ctx context.Context, token string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM subscriptions WHERE unsubscribe_token = ?", token)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CancelRevoked deletes the subscriptions of a share whose link or reviewer
+// was revoked, that were made without invitation to an invite-only share, or
+// with an outdated password. Call it after restricting the access of a share.
+func (s *SubscriptionService) CancelRevoked(ctx context.Context, shareID int) error {
+	share, err := s.shares.GetByID(ctx, shareID)
+	if err != nil {
+		return err
+	}
+
+	subs, err := s.list(ctx, "share_id = ?", shareID)
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range subs {
+		allowed, err := s.allowed(ctx, share, sub)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			continue
+		}
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM subscriptions WHERE id = ?", sub.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NotifyComment informs confirmed subscribers of the share or file about a new
+// comment. The author's own address is skipped.
+func (s *SubscriptionService) NotifyComment(ctx context.Context, share *database.Share, file *database.File, comment *database.Comment, authorEmail string) {
+	subs, err := s.listConfirmed(ctx, share,
+		"share_id = ? AND (file_id IS NULL OR file_id = ?) AND email != ?",
+		share.ID, file.ID, strings.ToLower(authorEmail),
+	)
+	if err != nil {
+		log.Printf("Failed to load subscriptions for share %d: %v", share.ID, err)
+		return
+	}
+
+	for _, sub := range subs {
+		s.send(sub, MailMessage{
+			To:      sub.Email,
+			Subject: fmt.Sprintf("New comment on %s", file.Filename),
+			Body: fmt.Sprintf(
+				"%s commented on \"%s\" in \"%s\":\n\n%s\n\nView the share: %s",
+				comment.Username, file.Filename, share.Name, comment.Content, s.shareURL(share, sub),
+			),
+		})
+	}
+}
+
+// NotifyUploads informs confirmed share-level subscribers about new files.
+func (s *SubscriptionService) NotifyUploads(ctx context.Context, share *database.Share, files []database.File) {
+	if len(files) == 0 {
+		return
+	}
+
+	subs, err := s.listConfirmed(ctx, share, "share_id = ? AND file_id IS NULL", share.ID)
+	if err != nil {
+		log.Printf("Failed to load subscriptions for share %d: %v", share.ID, err)
+		return
+	}
+
+	names := make([]string, 0, len(files))
+	for _, f := range files {
+		// Files held back by the malware scan are left out
+		if f.Blocked() {
+			continue
+		}
+		names = append(names, "- "+f.Filename)
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	for _, sub := range subs {
+		s.send(sub, MailMessage{
+			To:      sub.Email,
+			Subject: fmt.Sprintf("New files in %s", share.Name),
+			Body: fmt.Sprintf(
+				"%d new file(s) were uploaded to \"%s\":\n\n%s\n\nView the share: %s",
+				len(names), share.Name, strings.Join(names, "\n"), s.shareURL(share, sub),
+			),
+		})
+	}
+}
+
+// allowed reports whether the access a subscription was made with still opens
+// the share, checked like a visit with middleware.ApplyReviewer and
+// middleware.ShareUnlocked. The expiry is left to the callers, it is not a
+// revocation and can be lifted.
+func (s *SubscriptionService) allowed(ctx context.Context, share *database.Share, sub database.Subscription) (bool, error) {
+	hash := share.Hash
+	if sub.AccessHash != nil {
+		hash = *sub.AccessHash
+	}
+	access, err := s.shares.Resolve(ctx, hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	share = access.Share
+	if share.ID != sub.ShareID {
+		return false, nil
+	}
+
+	if share.HasPassword() && (sub.UnlockFingerprint == nil || *sub.UnlockFingerprint != UnlockFingerprint(share)) {
+		return false, nil
+	}
+
+	// Subscriptions made as reviewer end with the invitation
+	if sub.ReviewerID != nil {
+		reviewer, err := s.reviewers.GetByID(ctx, *sub.ReviewerID)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if reviewer.ShareID != share.ID || reviewer.RevokedAt != nil {
+			return false, nil
+		}
+		access.Reviewer = reviewer
+		access.Permission = reviewer.Permission
+	} else if share.InviteOnly {
+		access.Permission = ""
+	}
+
+	return access.CanView(), nil
+}
+
+// shareURL returns the share link of a subscription, which is the link the
+// subscriber used to subscribe.
+func (s *SubscriptionService) shareURL(share *database.Share, sub database.Subscription) string {
+	if sub.AccessHash != nil {
+		return s.baseURL + "/share/" + *sub.AccessHash
+	}
+	return s.baseURL + "/share/" + share.Hash
+}
+
+// send delivers the message in the background. Notification mails get a
+// one-click unsubscribe link (RFC 8058) when a subscription is given.
+func (s *SubscriptionService) send(sub database.Subscription, msg MailMessage) {
+	if sub.UnsubscribeToken != "" {
+		unsubscribeURL := s.baseURL + "/subscriptions/unsubscribe/" + sub.UnsubscribeToken
+		msg.Body += "\n\nUnsubscribe: " + unsubscribeURL
+		msg.Headers = map[string]string{
+			"List-Unsubscribe":      "<" + unsubscribeURL + ">",
+			"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+		}
+	}
+
+	go func() {
+		if err := s.mailer.Send(msg); err != nil {
+			log.Printf("Failed to send mail to %s: %v", msg.To, err)
+		}
+	}()
+}
+
+func (s *SubscriptionService) find(ctx context.Context, shareID int, fileID *int, email string) (*database.Subscription, error) {
+	var id int
+	var err error
+	if fileID == nil {
+		err = s.db.QueryRowContext(ctx,
+			"SELECT id FROM subscriptions WHERE share_id = ? AND file_id IS NULL AND email = ?",
+			shareID, email,
+		).Scan(&id)
+	} else {
+		err = s.db.QueryRowContext(ctx,
+			"SELECT id FROM subscriptions WHERE share_id = ? AND file_id = ? AND email = ?",
+			shareID, *fileID, email,
+		).Scan(&id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.getBy(ctx, "id", id)
+}
+
+func (s *SubscriptionService) getBy(ctx context.Context, column string, value interface{}) (*database.Subscription, error) {
+	sub := &database.Subscription{}
+	err := s.db.QueryRowContext(ctx,
+		"SELECT "+subscriptionColumns+" FROM subscriptions WHERE "+column+" = ?",
+		value,
+	).Scan(subscriptionFields(sub)...)
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
+
+// listConfirmed returns the confirmed subscriptions whose access still opens
+// the share. Nothing is sent for expired shares.
+func (s *SubscriptionService) listConfirmed(ctx context.Context, share *database.Share, where string, args ...interface{}) ([]database.Subscription, error) {
+	if share.IsExpired() {
+		return nil, nil
+	}
+
+	subs, err := s.list(ctx, "confirmed_at IS NOT NULL AND "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := subs[:0]
+	for _, sub := range subs {
+		ok, err := s.allowed(ctx, share, sub)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			allowed = append(allowed, sub)
+		}
+	}
+
+	return allowed, nil
+}
+
+func (s *SubscriptionService) list(ctx context.Context, where string, args ...interface{}) ([]database.Subscription, error) {
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+subscriptionColumns+" FROM subscriptions WHERE "+where,
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []database.Subscription
+	for rows.Next() {
+		var sub database.Subscription
+		err := rows.Scan(subscriptionFields(&sub)...)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+	"github.com/romanzipp/feedback/internal/repository"
+)
+
+// newTestSubscriptionService returns the subscription service with the share
+// and reviewer services it checks access with.
+func newTestSubscriptionService(t testing.TB, db *database.DB) (*SubscriptionService, *ShareService, *ReviewerService) {
+	t.Helper()
+
+	stmts := repository.NewStatements(db, 0)
+	t.Cleanup(func() { stmts.Close() })
+
+	mailer := NewMailService("", "", "", "", "")
+	shares := NewShareService(repository.NewShareRepository(stmts))
+	reviewers := NewReviewerService(db, mailer, "http://localhost")
+	return NewSubscriptionService(db, shares, reviewers, mailer, "http://localhost"), shares, reviewers
+}
+
+// publicAccess is the access of a visitor who opened the share's own hash.
+func publicAccess(share *database.Share) *database.ShareAccess {
+	return &database.ShareAccess{Share: share, Hash: share.Hash, Permission: database.PermissionComment}
+}
+
+func TestSubscribeResendsConfirmationAfterCooldown(t *testing.T) {
+	db := newTestDB(t)
+	share := createTestShare(t, db)
+	s, _, _ := newTestSubscriptionService(t, db)
+
+	first, err := s.Subscribe(context.Background(), publicAccess(share), nil, "Visitor@Example.com")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if first.ConfirmSentAt == nil {
+		t.Fatal("first Subscribe did not send the confirmation")
+	}
+
+	// Within the cooldown the confirmation is not sent again
+	second, err := s.Subscribe(context.Background(), publicAccess(share), nil, "visitor@example.com")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if second.ID != first.ID {
+		t.Fatalf("Subscribe created subscription %d, want to reuse %d", second.ID, first.ID)
+	}
+	if !second.ConfirmSentAt.Equal(*first.ConfirmSentAt) {
+		t.Fatalf("confirmation resent within the cooldown at %v", second.ConfirmSentAt)
+	}
+
+	// Once the cooldown passed it is sent again
+	past := time.Now().UTC().Add(-confirmResendCooldown - time.Minute)
+	if _, err := db.Exec("UPDATE subscriptions SET confirm_sent_at = ? WHERE id = ?", past, first.ID); err != nil {
+		t.Fatal(err)
+	}
+	third, err := s.Subscribe(context.Background(), publicAccess(share), nil, "visitor@example.com")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if third.ConfirmSentAt == nil || !third.ConfirmSentAt.After(past) {
+		t.Fatalf("confirmation not resent after the cooldown, sent at %v", third.ConfirmSentAt)
+	}
+}
+
+func TestSubscribeConfirmedSendsNoConfirmation(t *testing.T) {
+	db := newTestDB(t)
+	share := createTestShare(t, db)
+	s, _, _ := newTestSubscriptionService(t, db)
+
+	sub, err := s.Subscribe(context.Background(), publicAccess(share), nil, "visitor@example.com")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if _, err := s.Confirm(context.Background(), sub.ConfirmToken); err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if _, err := db.Exec("UPDATE subscriptions SET confirm_sent_at = NULL WHERE id = ?", sub.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err = s.Subscribe(context.Background(), publicAccess(share), nil, "visitor@example.com")
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	if sub.ConfirmedAt == nil || sub.ConfirmSentAt != nil {
+		t.Fatalf("confirmed subscription got a confirmation mail: %+v", sub)
+	}
+}
+
+func TestNotificationsRequireCurrentAccess(t *testing.T) {
+	ctx := context.Background()
+
+	for name, test := range map[string]struct {
+		// subscribe returns the access the visitor subscribed with
+		subscribe func(t *testing.T, shares *ShareService, reviewers *ReviewerService, share *database.Share) *database.ShareAccess
+		// restrict changes the access of the share afterwards
+		restrict func(t *testing.T, shares *ShareService, reviewers *ReviewerService, access *database.ShareAccess)
+		// cancelled is whether CancelRevoked deletes the subscription
+		cancelled bool
+	}{
+		"link revoked": {
+			subscribe: func(t *testing.T, shares *ShareService, _ *ReviewerService, share *database.Share) *database.ShareAccess {
+				link, err := shares.CreateLink(ctx, share.ID, "Client", database.PermissionView)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return &database.ShareAccess{Share: share, Link: link, Hash: link.Hash, Permission: link.Permission}
+			},
+			restrict: func(t *testing.T, shares *ShareService, _ *ReviewerService, access *database.ShareAccess) {
+				if err := shares.RevokeLink(ctx, access.Share.ID, access.Link.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			cancelled: true,
+		},
+		"reviewer revoked": {
+			subscribe: func(t *testing.T, _ *ShareService, reviewers *ReviewerService, share *database.Share) *database.ShareAccess {
+				reviewer, err := reviewers.Invite(ctx, share, "Alice", "alice@example.com", database.PermissionComment)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return &database.ShareAccess{Share: share, Reviewer: reviewer, Hash: share.Hash, Permission: reviewer.Permission}
+			},
+			restrict: func(t *testing.T, _ *ShareService, reviewers *ReviewerService, access *database.ShareAccess) {
+				if err := reviewers.Revoke(ctx, access.Share.ID, access.Reviewer.ID); err != nil {
+					t.Fatal(err)
+				}
+			},
+			cancelled: true,
+		},
+		"password set": {
+			subscribe: func(t *testing.T, _ *ShareService, _ *ReviewerService, share *database.Share) *database.ShareAccess {
+				return publicAccess(share)
+			},
+			restrict: func(t *testing.T, shares *ShareService, _ *ReviewerService, access *database.ShareAccess) {
+				if err := shares.SetPassword(ctx, access.Share.ID, "correct horse"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			cancelled: true,
+		},
+		"password changed": {
+			subscribe: func(t *testing.T, shares *ShareService, _ *ReviewerService, share *database.Share) *database.ShareAccess {
+				if err := shares.SetPassword(ctx, share.ID, "correct horse"); err != nil {
+					t.Fatal(err)
+				}
+				share, err := shares.GetByID(ctx, share.ID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return publicAccess(share)
+			},
+			restrict: func(t *testing.T, shares *ShareService, _ *ReviewerService, access *database.ShareAccess) {
+				if err := shares.SetPassword(ctx, access.Share.ID, "battery staple"); err != nil {
+					t.Fatal(err)
+				}
+			},
+			cancelled: true,
+		},
+		"invite only": {
+			subscribe: func(t *testing.T, _ *ShareService, _ *ReviewerService, share *database.Share) *database.ShareAccess {
+				return publicAccess(share)
+			},
+			restrict: func(t *testing.T, shares *ShareService, _ *ReviewerService, access *database.ShareAccess) {
+				if err := shares.SetInviteOnly(ctx, access.Share.ID, true); err != nil {
+					t.Fatal(err)
+				}
+			},
+			cancelled: true,
+		},
+		"expired": {
+			subscribe: func(t *testing.T, _ *ShareService, _ *ReviewerService, share *database.Share) *database.ShareAccess {
+				return publicAccess(share)
+			},
+			restrict: func(t *testing.T, shares *ShareService, _ *ReviewerService, access *database.ShareAccess) {
+				past := time.Now().Add(-time.Hour)
+				if err := shares.SetExpiry(ctx, access.Share.ID, &past); err != nil {
+					t.Fatal(err)
+				}
+			},
+			// The expiry can be lifted again
+			cancelled: false,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := newTestDB(t)
+			s, shares, reviewers := newTestSubscriptionService(t, db)
+			share, err := shares.Create(ctx, "Share", "", nil, ShareOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			access := test.subscribe(t, shares, reviewers, share)
+			sub, err := s.Subscribe(ctx, access, nil, "visitor@example.com")
+			if err != nil {
+				t.Fatalf("Subscribe: %v", err)
+			}
+			if _, err := s.Confirm(ctx, sub.ConfirmToken); err != nil {
+				t.Fatalf("Confirm: %v", err)
+			}
+
+			if subs := notified(t, s, shares, share.ID); len(subs) != 1 {
+				t.Fatalf("%d subscriptions notified before the change, want 1", len(subs))
+			}
+
+			test.restrict(t, shares, reviewers, access)
+			if subs := notified(t, s, shares, share.ID); len(subs) != 0 {
+				t.Errorf("%d subscriptions notified after the change, want 0", len(subs))
+			}
+
+			if err := s.CancelRevoked(ctx, share.ID); err != nil {
+				t.Fatalf("CancelRevoked: %v", err)
+			}
+			_, err = s.GetByUnsubscribeToken(ctx, sub.UnsubscribeToken)
+			if cancelled := err != nil; cancelled != test.cancelled {
+				t.Errorf("subscription cancelled %v, want %v", cancelled, test.cancelled)
+			}
+		})
+	}
+}
+
+// notified returns the subscriptions that upload notifications of the share
+// are sent to.
+func notified(t *testing.T, s *SubscriptionService, shares *ShareService, shareID int) []database.Subscription {
+	t.Helper()
+
+	share, err := shares.GetByID(context.Background(), shareID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subs, err := s.listConfirmed(context.Background(), share, "share_id = ? AND file_id IS NULL", share.ID)
+	if err != nil {
+		t.Fatalf("listConfirmed: %v", err)
+	}
+	return subs
+}
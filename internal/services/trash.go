@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/romanzipp/feedback/internal/database"
+)
+
+// TrashService manages deleted shares, files and comments. They can be
+// restored until the retention period has passed, then they are purged
+// together with their uploads.
+type TrashService struct {
+	shareService *ShareService
+	fileService  *FileService
+	retention    time.Duration
+}
+
+func NewTrashService(shareService *ShareService, fileService *FileService, retention time.Duration) *TrashService {
+	return &TrashService{
+		shareService: shareService,
+		fileService:  fileService,
+		retention:    retention,
+	}
+}
+
+// Retention returns how long deleted items are kept in the trash.
+func (t *TrashService) Retention() time.Duration {
+	return t.retention
+}
+
+// Run purges expired items from the trash every hour until the context is
+// cancelled.
+func (t *TrashService) Run(ctx context.Context) {
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := t.PurgeOnce(ctx); err != nil {
+			log.Printf("Failed to purge trash: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d items from the trash", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PurgeOnce permanently deletes all items that have been in the trash for
+// longer than the retention period and returns how many were removed.
+func (t *TrashService) PurgeOnce(ctx context.Context) (int, error) {
+	before := time.Now().Add(-t.retention)
+
+	shares, err := t.shareService.ListDeletedBefore(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, share := range shares {
+		if err := t.PurgeShare(ctx, share.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	files, err := t.fileService.ListDeletedBefore(ctx, before)
+	if err != nil {
+		return purged, err
+	}
+	for _, file := range files {
+		if err := t.fileService.Purge(ctx, file.ID); err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	n, err := t.fileService.PurgeCommentsDeletedBefore(ctx, before)
+	return purged + n, err
+}
+
+// PurgeShare permanently deletes a share with its files, comments and
+// uploads.
+func (t *TrashService) PurgeShare(ctx context.Context, id int) error {
+	return t.fileService.PurgeShare(ctx, t.shareService, id)
+}
+
+// Trash lists the deleted items of a trash view.
+type Trash struct {
+	Shares   []database.ShareWithStats
+	Files    []database.TrashedFile
+	Comments []database.TrashedComment
+}
+
+// List returns the items in the trash that the admin may restore.
+func (t *TrashService) List(ctx context.Context, admin *database.Admin) (*Trash, error) {
+	shares, err := t.shareService.ListDeleted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files, err := t.fileService.ListDeleted(ctx)
+	if err != nil {
+		return nil, err
+	}
+	comments, err := t.fileService.ListDeletedComments(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	trash := &Trash{}
+	for _, s := range shares {
+		if admin.CanEditShare(s.Share) {
+			trash.Shares = append(trash.Shares, s)
+		}
+	}
+	for _, f := range files {
+		if admin.CanEditShare(f.Share) {
+			trash.Files = append(trash.Files, f)
+		}
+	}
+	for _, c := range comments {
+		if admin.CanEditShare(c.Share) {
+			trash.Comments = append(trash.Comments, c)
+		}
+	}
+	return trash, nil
+}
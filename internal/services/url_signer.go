@@ -0,0 +1,62 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// URLSigner creates short-lived file URLs that can be embedded elsewhere. The
+// signature is an HMAC of the file hash and expiry, so it cannot be moved to
+// another file or extended.
+type URLSigner struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewURLSigner derives the signing key from the session secret, so a signature
+// is never valid as a session cookie or the other way round.
+func NewURLSigner(secret string, ttl time.Duration) *URLSigner {
+	key := sha256.Sum256([]byte("signed-file-urls:" + secret))
+	return &URLSigner{key: key[:], ttl: ttl}
+}
+
+// SignFile returns a download URL for the file that is valid for the TTL.
+func (s *URLSigner) SignFile(fileHash string) (string, time.Time) {
+	expiresAt := time.Now().Add(s.ttl).UTC().Truncate(time.Second)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+
+	query := url.Values{}
+	query.Set("expires", expires)
+	query.Set("signature", s.signature(fileHash, expires))
+	return "/files/" + fileHash + "?" + query.Encode(), expiresAt
+}
+
+// VerifyFile checks the expires and signature query parameters of a file URL.
+func (s *URLSigner) VerifyFile(fileHash, expires, signature string) bool {
+	if expires == "" || signature == "" {
+		return false
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+
+	return hmac.Equal([]byte(signature), []byte(s.signature(fileHash, expires)))
+}
+
+// ExpiresIn returns the remaining validity of a verified expires parameter.
+func (s *URLSigner) ExpiresIn(expires string) time.Duration {
+	unix, _ := strconv.ParseInt(expires, 10, 64)
+	return time.Until(time.Unix(unix, 0))
+}
+
+func (s *URLSigner) signature(fileHash, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(fileHash + ":" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
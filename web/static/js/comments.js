@@ -1,4 +1,5 @@
 document.addEventListener('DOMContentLoaded', function() {
+    const csrfToken = document.querySelector('meta[name="csrf-token"]').content;
     const forms = document.querySelectorAll('.comment-form');
 
     forms.forEach(form => {
@@ -6,6 +7,7 @@ document.addEventListener('DOMContentLoaded', function() {
             e.preventDefault();
 
             const fileHash = this.dataset.fileHash;
+            const access = this.dataset.access;
             const content = this.querySelector('[name="content"]').value;
             const commentsContainer = document.getElementById(`comments-${fileHash}`);
 
@@ -14,8 +16,9 @@ document.addEventListener('DOMContentLoaded', function() {
                     method: 'POST',
                     headers: {
                         'Content-Type': 'application/x-www-form-urlencoded',
+                        'X-CSRF-Token': csrfToken,
                     },
-                    body: `content=${encodeURIComponent(content)}`
+                    body: `content=${encodeURIComponent(content)}&access=${encodeURIComponent(access)}`
                 });
 
                 if (!response.ok) {
@@ -25,17 +28,7 @@ document.addEventListener('DOMContentLoaded', function() {
                 const comment = await response.json();
 
                 // Add comment to UI
-                const commentDiv = document.createElement('div');
-                commentDiv.className = 'bg-gray-50 rounded p-2';
-                const commentDate = new Date(comment.CreatedAt);
-                commentDiv.innerHTML = `
-                    <div class="flex items-baseline gap-1 mb-1">
-                        <span class="text-xs font-medium text-gray-900">${escapeHtml(comment.Username)}</span>
-                        <span class="text-xs text-gray-400 relative-time" data-time="${commentDate.toISOString()}">${getRelativeTime(commentDate)}</span>
-                    </div>
-                    <p class="text-xs text-gray-700">${escapeHtml(comment.Content)}</p>
-                `;
-                commentsContainer.appendChild(commentDiv);
+                commentsContainer.appendChild(renderComment(comment));
 
                 // Clear form
                 this.reset();
@@ -46,8 +39,54 @@ document.addEventListener('DOMContentLoaded', function() {
             }
         });
     });
+
+    // Only the most recent comments are part of the page, earlier ones are
+    // loaded in pages above them
+    document.querySelectorAll('.load-earlier-comments').forEach(button => {
+        button.addEventListener('click', async function() {
+            const fileHash = this.dataset.fileHash;
+            const params = new URLSearchParams({access: this.dataset.access, before: this.dataset.before});
+
+            this.disabled = true;
+            try {
+                const response = await fetch(`/api/files/${fileHash}/comments?${params}`);
+                if (!response.ok) {
+                    throw new Error('Failed to load comments');
+                }
+
+                const page = await response.json();
+                this.after(...page.comments.map(renderComment));
+                if (page.comments.length > 0) {
+                    this.dataset.before = page.comments[0].ID;
+                }
+                if (!page.has_more) {
+                    this.remove();
+                }
+            } catch (error) {
+                alert('Failed to load comments. Please try again.');
+                console.error(error);
+            } finally {
+                this.disabled = false;
+            }
+        });
+    });
 });
 
+function renderComment(comment) {
+    const commentDiv = document.createElement('div');
+    commentDiv.className = 'bg-gray-50 rounded p-2';
+    const commentDate = new Date(comment.CreatedAt);
+    commentDiv.innerHTML = `
+        <div class="flex items-baseline gap-1 mb-1">
+            <span class="text-xs font-medium text-gray-900">${escapeHtml(comment.Username)}</span>
+            ${comment.ReviewerID ? '<span class="text-xs text-green-700" title="Verified reviewer">✓</span>' : ''}
+            <span class="text-xs text-gray-400 relative-time" data-time="${commentDate.toISOString()}">${getRelativeTime(commentDate)}</span>
+        </div>
+        <p class="text-xs text-gray-700">${escapeHtml(comment.Content)}</p>
+    `;
+    return commentDiv;
+}
+
 function escapeHtml(text) {
     const div = document.createElement('div');
     div.textContent = text;
@@ -0,0 +1,7 @@
+// Asks for confirmation before submitting buttons with a data-confirm message
+document.addEventListener('click', function(e) {
+    const button = e.target.closest('[data-confirm]');
+    if (button && !confirm(button.dataset.confirm)) {
+        e.preventDefault();
+    }
+});
@@ -14,6 +14,14 @@ window.closeModal = function() {
     document.body.style.overflow = 'auto';
 };
 
+document.querySelectorAll('img[data-file-hash]').forEach(img => {
+    img.addEventListener('click', function() {
+        openModal(this.dataset.fileHash);
+    });
+});
+
+document.getElementById('modal')?.addEventListener('click', closeModal);
+
 document.addEventListener('keydown', function(e) {
     if (e.key === 'Escape') {
         closeModal();
@@ -0,0 +1,35 @@
+// Update relative times
+function updateRelativeTimes() {
+    document.querySelectorAll('.relative-time').forEach(el => {
+        const time = new Date(el.dataset.time);
+        el.textContent = getRelativeTime(time);
+    });
+}
+
+function getRelativeTime(date) {
+    const seconds = Math.floor((new Date() - date) / 1000);
+
+    if (seconds < 60) return 'just now';
+    if (seconds < 3600) {
+        const mins = Math.floor(seconds / 60);
+        return mins + 'm ago';
+    }
+    if (seconds < 86400) {
+        const hours = Math.floor(seconds / 3600);
+        return hours + 'h ago';
+    }
+    if (seconds < 2592000) {
+        const days = Math.floor(seconds / 86400);
+        return days + 'd ago';
+    }
+
+    const month = String(date.getMonth() + 1).padStart(2, '0');
+    const day = String(date.getDate()).padStart(2, '0');
+    return month + '/' + day;
+}
+
+// Initial update
+updateRelativeTimes();
+
+// Update every minute
+setInterval(updateRelativeTimes, 60000);
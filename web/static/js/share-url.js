@@ -0,0 +1,5 @@
+// Shows the full share URL for elements with a data-hash
+document.querySelectorAll('.share-url').forEach(el => {
+    const hash = el.dataset.hash;
+    el.textContent = window.location.origin + '/share/' + hash;
+});
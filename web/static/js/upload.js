@@ -0,0 +1,30 @@
+// Submits upload forms with fetch, multipart bodies must carry the CSRF token
+// in the X-CSRF-Token header instead of a form field
+document.querySelectorAll('form[data-upload]').forEach(form => {
+    form.addEventListener('submit', async function(e) {
+        e.preventDefault();
+
+        const button = this.querySelector('[type="submit"]');
+        button.disabled = true;
+        try {
+            const response = await fetch(this.action, {
+                method: 'POST',
+                headers: {
+                    'X-CSRF-Token': this.querySelector('[name="csrf_token"]').value,
+                },
+                body: new FormData(this),
+            });
+
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+
+            // The redirect to the share page was followed
+            window.location.href = response.url;
+        } catch (error) {
+            alert('Upload failed: ' + error.message);
+            console.error(error);
+            button.disabled = false;
+        }
+    });
+});